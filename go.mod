@@ -1,3 +1,5 @@
 module github.com/night-codes/gorefactor
 
 go 1.21
+
+require golang.org/x/mod v0.20.0
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/night-codes/gorefactor/refactor"
@@ -19,7 +20,7 @@ func main() {
 	}
 
 	cmd := os.Args[1]
-	args := os.Args[2:]
+	args := globalFlags(os.Args[2:])
 
 	var result any
 	var err error
@@ -40,6 +41,79 @@ func main() {
 		}
 		result, err = refactor.ListPackages(dir)
 
+	case "deps":
+		opts := &refactor.DepsOptions{}
+		for i := 0; i < len(args); i++ {
+			if i+1 >= len(args) {
+				break
+			}
+			switch args[i] {
+			case "--format":
+				opts.Format = args[i+1]
+				i++
+			case "--reverse":
+				opts.Reverse = args[i+1]
+				i++
+			case "--rules":
+				opts.Rules = args[i+1]
+				i++
+			}
+		}
+		result, err = refactor.Deps(opts)
+
+	case "cycles":
+		result, err = refactor.Cycles()
+
+	case "metrics":
+		pkg := ""
+		opts := &refactor.MetricsOptions{}
+		for i := 0; i < len(args); i++ {
+			switch args[i] {
+			case "--sort", "--top":
+				if i+1 >= len(args) {
+					fatal(fmt.Sprintf("%s needs a value", args[i]))
+				}
+				if args[i] == "--sort" {
+					opts.Sort = args[i+1]
+				} else {
+					opts.Top = atoiArg(args[i+1])
+				}
+				i++
+			default:
+				pkg = args[i]
+			}
+		}
+		result, err = refactor.Metrics(pkg, opts)
+
+	case "generate":
+		pkg := ""
+		if len(args) > 0 {
+			pkg = args[0]
+		}
+		result, err = refactor.Generate(pkg)
+
+	case "mod":
+		if len(args) < 1 {
+			fatal("usage: gorefactor mod show|require|replace|drop|why [arg]")
+		}
+		if args[0] != "show" && len(args) < 2 {
+			fatal("usage: gorefactor mod " + args[0] + " <arg>")
+		}
+		switch args[0] {
+		case "show":
+			result, err = refactor.ModShow()
+		case "require":
+			result, err = refactor.ModRequire(args[1])
+		case "replace":
+			result, err = refactor.ModReplace(strings.Join(args[1:], ""))
+		case "drop":
+			result, err = refactor.ModDrop(args[1])
+		case "why":
+			result, err = refactor.ModWhy(args[1])
+		default:
+			fatal("usage: gorefactor mod show|require|replace|drop|why [arg]")
+		}
+
 	case "symbols":
 		if len(args) < 1 {
 			fatal("usage: gorefactor symbols <file.go|package>")
@@ -48,10 +122,29 @@ func main() {
 
 	case "api":
 		pkg := "."
-		if len(args) > 0 {
-			pkg = args[0]
+		docReport := false
+		for _, a := range args {
+			if a == "--doc-report" {
+				docReport = true
+			} else if !strings.HasPrefix(a, "-") {
+				pkg = a
+			}
+		}
+		if docReport {
+			result, err = refactor.DocReport(pkg)
+		} else {
+			result, err = refactor.PackageAPI(pkg)
+		}
+
+	case "apidiff":
+		if len(args) < 1 {
+			fatal("usage: gorefactor apidiff <old-ref> [new-ref] [pkg...]")
+		}
+		oldRef, newRef, pkgs := args[0], "", args[1:]
+		if len(pkgs) > 0 && refactor.IsRevision(pkgs[0]) {
+			newRef, pkgs = pkgs[0], pkgs[1:]
 		}
-		result, err = refactor.PackageAPI(pkg)
+		result, err = refactor.APIDiff(oldRef, newRef, pkgs)
 
 	// === Find & Read (unified) ===
 	case "find":
@@ -74,6 +167,15 @@ func main() {
 		}
 		result, err = refactor.Read(args[0], file)
 
+	case "doc":
+		if len(args) < 1 {
+			fatal("usage: gorefactor doc <name> [file]")
+		}
+		file := ""
+		if len(args) > 1 {
+			file = args[1]
+		}
+		result, err = refactor.Doc(args[0], file)
 
 	case "grep":
 		if len(args) < 1 {
@@ -100,6 +202,67 @@ func main() {
 			}
 		}
 		result, err = refactor.Grep(pattern, dir, opts)
+	case "unused":
+		dir := "."
+		opts := &refactor.UnusedOptions{}
+		for _, a := range args {
+			switch a {
+			case "--exported":
+				opts.Exported = true
+			case "--apply":
+				opts.Apply = true
+			default:
+				if !strings.HasPrefix(a, "-") {
+					dir = a
+				}
+			}
+		}
+		result, err = refactor.Unused(dir, opts)
+	case "errors":
+		pkg := ""
+		opts := &refactor.ErrorsOptions{}
+		for _, a := range args {
+			if a == "--fix" {
+				opts.Fix = true
+			} else {
+				pkg = a
+			}
+		}
+		result, err = refactor.Errors(pkg, opts)
+
+	case "dupes":
+		dir := "."
+		opts := &refactor.DupesOptions{}
+		for i := 0; i < len(args); i++ {
+			if args[i] == "--min-tokens" {
+				if i+1 >= len(args) {
+					fatal("--min-tokens needs a value")
+				}
+				opts.MinTokens = atoiArg(args[i+1])
+				i++
+			} else {
+				dir = args[i]
+			}
+		}
+		result, err = refactor.Dupes(dir, opts)
+
+	case "exhaustive":
+		dir := "."
+		opts := &refactor.ExhaustiveOptions{}
+		for _, a := range args {
+			switch a {
+			case "--fill":
+				opts.Fill = true
+			case "--todo":
+				opts.Fill, opts.Todo = true, true
+			default:
+				if !strings.HasPrefix(a, "-") {
+					dir = a
+				}
+			}
+		}
+		result, err = refactor.Exhaustive(dir, opts)
+
 	// === Modify code ===
 	case "replace":
 		if len(args) < 1 {
@@ -121,6 +284,122 @@ func main() {
 		}
 		result, err = refactor.Delete(args[0], file)
 
+	case "field":
+		if len(args) < 3 {
+			fatal("usage: gorefactor field add|remove|move|retype <Type> <Name> [type] [--tag tag] [--after F|--before F]")
+		}
+		opts := &refactor.FieldOptions{}
+		var pos []string
+		for i := 1; i < len(args); i++ {
+			if i+1 < len(args) {
+				switch args[i] {
+				case "--tag":
+					opts.Tag = args[i+1]
+					i++
+					continue
+				case "--after":
+					opts.After = args[i+1]
+					i++
+					continue
+				case "--before":
+					opts.Before = args[i+1]
+					i++
+					continue
+				}
+			}
+			pos = append(pos, args[i])
+		}
+		switch {
+		case args[0] == "add" && len(pos) == 3:
+			result, err = refactor.AddField(pos[0], pos[1], pos[2], opts)
+		case args[0] == "remove" && len(pos) == 2:
+			result, err = refactor.RemoveField(pos[0], pos[1])
+		case args[0] == "move" && len(pos) == 2:
+			result, err = refactor.MoveField(pos[0], pos[1], opts)
+		case args[0] == "retype" && len(pos) == 3:
+			result, err = refactor.RetypeField(pos[0], pos[1], pos[2])
+		default:
+			fatal("usage: gorefactor field add <Type> <Name> <type> [--tag tag] [--after F] | remove <Type> <Name> | move <Type> <Name> --after F|--before F | retype <Type> <Name> <type>")
+		}
+
+	case "tags":
+		if len(args) < 1 {
+			fatal("usage: gorefactor tags <Type> [--add json,yaml] [--case snake|camel|kebab] [--omitempty] [--remove db] [--set Field tag] [--check]")
+		}
+		opts := &refactor.TagsOptions{}
+		for i := 1; i < len(args); i++ {
+			switch args[i] {
+			case "--omitempty":
+				opts.OmitEmpty = true
+			case "--check":
+				opts.Check = true
+			case "--add", "--remove", "--case":
+				if i+1 >= len(args) {
+					fatal(fmt.Sprintf("%s needs a value", args[i]))
+				}
+				switch args[i] {
+				case "--add":
+					opts.Add = append(opts.Add, strings.Split(args[i+1], ",")...)
+				case "--remove":
+					opts.Remove = append(opts.Remove, strings.Split(args[i+1], ",")...)
+				case "--case":
+					opts.Case = args[i+1]
+				}
+				i++
+			case "--set":
+				if i+2 >= len(args) {
+					fatal("usage: --set <Field> <tag>")
+				}
+				opts.SetField, opts.SetTag = args[i+1], args[i+2]
+				i += 2
+			}
+		}
+		result, err = refactor.Tags(args[0], opts)
+
+	case "gen":
+		if len(args) < 2 {
+			fatal("usage: gorefactor gen constructor|accessors|options|enum|test <Name> [--fields a,b]")
+		}
+		var fields []string
+		text := false
+		testFile := ""
+		for i := 2; i < len(args); i++ {
+			switch {
+			case args[i] == "--fields" && i+1 < len(args):
+				fields = strings.Split(args[i+1], ",")
+				i++
+			case args[i] == "--file" && i+1 < len(args):
+				testFile = args[i+1]
+				i++
+			case args[i] == "--text":
+				text = true
+			}
+		}
+		switch args[0] {
+		case "constructor":
+			result, err = refactor.GenConstructor(args[1], fields)
+		case "accessors":
+			result, err = refactor.GenAccessors(args[1], fields)
+		case "options":
+			result, err = refactor.GenOptions(args[1], fields)
+		case "enum":
+			result, err = refactor.GenEnum(args[1], &refactor.EnumOptions{Text: text})
+		case "test":
+			result, err = refactor.GenTest(args[1], &refactor.GenTestOptions{File: testFile})
+		default:
+			fatal("usage: gorefactor gen constructor|accessors|options|enum|test <Name> [--fields a,b]")
+		}
+
+	case "set-doc":
+		if len(args) < 1 {
+			fatal("usage: gorefactor set-doc <name> [file] < text")
+		}
+		file := ""
+		if len(args) > 1 {
+			file = args[1]
+		}
+		result, err = refactor.SetDoc(args[0], file, os.Stdin)
+
 	case "add":
 		if len(args) < 1 {
 			fatal("usage: gorefactor add <file> < newcode")
@@ -195,11 +474,61 @@ func main() {
 		}
 		result, err = refactor.Implementations(args[0])
 
-	case "callers":
+	case "hierarchy":
+		if len(args) < 1 {
+			fatal("usage: gorefactor hierarchy <type>")
+		}
+		result, err = refactor.Hierarchy(args[0])
+
+	case "callers", "callees":
 		if len(args) < 1 {
-			fatal("usage: gorefactor callers <func>")
+			fatal("usage: gorefactor " + cmd + " <func> [--depth N]")
+		}
+		depth := 1
+		for i := 1; i < len(args); i++ {
+			if args[i] == "--depth" && i+1 < len(args) {
+				depth = atoiArg(args[i+1])
+				i++
+			}
+		}
+		if cmd == "callers" {
+			result, err = refactor.Callers(args[0], depth)
+		} else {
+			result, err = refactor.Callees(args[0], depth)
+		}
+
+	case "callgraph":
+		pkg := ""
+		opts := &refactor.CallGraphOptions{}
+		for i := 0; i < len(args); i++ {
+			switch args[i] {
+			case "--format":
+				if i+1 < len(args) {
+					opts.Format = args[i+1]
+					i++
+				}
+			case "--algo":
+				if i+1 < len(args) {
+					opts.Algo = args[i+1]
+					i++
+				}
+			case "--focus":
+				if i+1 < len(args) {
+					opts.Focus = args[i+1]
+					i++
+				}
+			case "--stdlib":
+				if i+1 < len(args) {
+					opts.Stdlib = args[i+1]
+					i++
+				}
+			default:
+				if !strings.HasPrefix(args[i], "-") {
+					pkg = args[i]
+				}
+			}
 		}
-		result, err = refactor.Callers(args[0])
+		result, err = refactor.CallGraph(pkg, opts)
 
 	case "context":
 		if len(args) < 1 {
@@ -220,6 +549,54 @@ func main() {
 		}
 		result, err = refactor.RenamePackage(args[0], args[1])
 
+	case "to-method":
+		if len(args) < 2 {
+			fatal("usage: gorefactor to-method <func> <param>")
+		}
+		result, err = refactor.ToMethod(args[0], args[1])
+
+	case "to-func":
+		if len(args) < 1 {
+			fatal("usage: gorefactor to-func <Type.Method>")
+		}
+		result, err = refactor.ToFunc(args[0])
+
+	case "extract-var":
+		if len(args) < 2 {
+			fatal("usage: gorefactor extract-var <file:line:col-col> <name> [--all]")
+		}
+		opts := &refactor.ExtractVarOptions{}
+		for _, a := range args[2:] {
+			if a == "--all" {
+				opts.All = true
+			}
+		}
+		result, err = refactor.ExtractVar(args[0], args[1], opts)
+
+	case "inline-var":
+		if len(args) < 2 {
+			fatal("usage: gorefactor inline-var <func> <var>")
+		}
+		result, err = refactor.InlineVar(args[0], args[1])
+
+	case "add-context":
+		if len(args) < 1 {
+			fatal("usage: gorefactor add-context <func> [--roots a,b] [--dry-run]")
+		}
+		opts := &refactor.AddContextOptions{}
+		for i := 1; i < len(args); i++ {
+			switch args[i] {
+			case "--roots":
+				if i+1 < len(args) {
+					opts.Roots = append(opts.Roots, strings.Split(args[i+1], ",")...)
+					i++
+				}
+			case "--dry-run":
+				opts.DryRun = true
+			}
+		}
+		result, err = refactor.AddContext(args[0], opts)
+
 	// === Validation ===
 	case "format":
 		target := "./..."
@@ -242,6 +619,22 @@ func main() {
 		}
 		result, err = refactor.Test(pkg)
 
+	case "coverage":
+		pkg := ""
+		opts := &refactor.CoverageOptions{}
+		for i := 0; i < len(args); i++ {
+			switch {
+			case args[i] == "--func" && i+1 < len(args):
+				opts.Func = args[i+1]
+				i++
+			case args[i] == "--tests":
+				opts.Tests = true
+			case !strings.HasPrefix(args[i], "-"):
+				pkg = args[i]
+			}
+		}
+		result, err = refactor.Coverage(pkg, opts)
+
 	case "version":
 		fmt.Println(version)
 		return
@@ -259,6 +652,7 @@ func main() {
 
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
 	enc.Encode(result)
 }
 
@@ -267,21 +661,76 @@ func printUsage() {
 
 PROJECT
   project [dir]           Project structure and stats
-  packages [dir]          List all packages
+  packages [dir]          List all packages (per module in go.work workspaces)
   symbols <file|pkg>      List symbols in file/package
-  api [pkg]               Public API of package
+  api [pkg]               Public API of package (--doc-report: missing docs on
+                          symbols and fields, comments not starting with the
+                          name, stale params)
+  apidiff <old> [new] [pkg...]
+                          API changes between git revisions (new defaults to
+                          the working tree), breaking or not, and semver bump
+  deps                    Package import graph (--format json|dot, --reverse <pkg>,
+                          --rules <file> with "<pkg> must not import <pkg>" lines)
+  cycles                  Import cycles with the import closing each one
+  metrics [pkg]           Per function: cyclomatic and cognitive complexity, lines,
+                          params/results, nesting, fan-in/out; per package totals
+                          (--sort complexity|cognitive|lines|nesting|fanin|fanout, --top N)
+  mod show                go.mod requires, replaces, excludes, toolchain
+  mod require <path@v>    Add or update a requirement (offline, from the module cache)
+  mod replace <old=>new>  Add a replace directive (new: path@v or ./dir)
+  mod drop <path>         Remove a requirement and its replaces
+  mod why <path>          Files importing a dependency
 
 FIND & READ
   find <name> [dir]       Find symbol (func, type, var, const, field)
   read <name> [file]      Read code of function or type
+  doc <name> [file]       Doc comment parsed into blocks, links, deprecation
   grep <pattern> [dir] Search text in project (-i ignore case, -r regex)
+  unused [dir]            Dead code (--exported: include exported symbols,
+                          --apply: delete all but fields, revert if build breaks)
+  errors [pkg]            Unchecked errors, "return err" from other packages without
+                          wrapping, %v of errors in fmt.Errorf, errors.New(fmt.Sprintf)
+                          (--fix: rewrite to %w and fmt.Errorf)
+  dupes [dir]             Clone groups of structurally identical code with file:N:M
+                          ranges and a suggested extract name (--min-tokens N, 40)
+  exhaustive [dir]        Switches missing enum constants or sealed interface
+                          implementers (--fill: add panic("unhandled") cases,
+                          --todo: add TODO cases instead)
 
 MODIFY (pipe new code via stdin: echo 'code' | gorefactor ...)
   replace <name> [file]    Replace symbol with new code
   delete <name> [file]     Delete symbol
+  set-doc <name> [file]    Insert or replace doc comment (empty stdin removes it)
   add <file>               Append code to file
   move <name> <dst>        Move symbol to another file in same package
 
+STRUCTS
+  field add <T> <Name> <type>  Add field (--tag 'json:"x"', --after F, --before F);
+                               positional literals get the zero value
+  field remove <T> <Name>      Remove field and its values in literals,
+                               report selector uses
+  field move <T> <Name>        Reorder field (--after F or --before F) and
+                               positional literals
+  field retype <T> <Name> <type>  Change field type, report literal values
+                               and uses to review
+  tags <T>                     Parsed struct tags; --add json,yaml [--case
+                               snake|camel|kebab] [--omitempty], --remove db,
+                               --set Field 'json:"id,string"', --check (duplicate
+                               JSON keys, malformed tags, tagged unexported fields)
+
+GENERATE (code is placed after the type; existing functions are skipped)
+  gen constructor <T> [--fields a,b]  New<T> taking the fields, by default those
+                                      without a usable zero value
+  gen accessors <T> [--fields a,b]    Getters and setters for unexported fields
+  gen options <T> [--fields a,b]      Option func type and With<Field> funcs
+  gen enum <T> [--text]              String, Parse<T>, <T>Values, IsValid for typed
+                                      consts (--text: MarshalText/UnmarshalText);
+                                      re-run to sync after adding constants
+  gen test <Func> [--file x_test.go]  Table-driven test with fields for params and
+                                      results, appended to the _test.go file
+  generate [pkg]                      Run go generate; report the directives and the
+                                      files changed, added or removed
+
 LINES (raw line operations, file:N or file:N:M format)
   lines <file:N:M>          Read lines N to M (or single line N)
   replace-lines <file:N:M>  Replace lines N-M with stdin
@@ -292,17 +741,47 @@ NAVIGATION (gopls)
   definition <symbol>     Where symbol is defined
   references <symbol>     All usages of symbol
   implementations <iface> Types implementing interface
-  callers <func>          Functions calling this function
+  hierarchy <type>        Embedded types, method set, satisfied interfaces
+                          (for interfaces: embedded, methods, implementers)
   context <file:line>     Scope/function at position
 
+CALLS
+  callers <func> [--depth N]  Call sites of function (call tree with depth)
+  callees <func> [--depth N]  Functions called by function
+  callgraph [pkg]         Call graph (--format json|dot|mermaid, --algo static|cha|rta,
+                          --focus <func>, --stdlib include|collapse|exclude)
+
 REFACTORING (gopls)
   rename <old> <new>           Rename symbol globally
   rename-package <old> <new>   Rename package and fix imports
+  to-method <func> <param>     Turn func into a method on param's type; calls
+                               f(x, a) become x.f(a), func values method expressions
+  to-func <Type.Method>        Turn method into a func taking the receiver first;
+                               refused when the method satisfies an interface
+  extract-var <file:L:C-C> <name>  Declare name := expr before the statement and use
+                               it (range also file:L:C-L:C; --all: identical
+                               side-effect-free occurrences later in the block too)
+  inline-var <func> <var>      Replace a single-assignment local with its initializer
+  add-context <func>           Add ctx context.Context as first parameter and pass it
+                               from callers, threading it up the call chain; roots
+                               (--roots a,b, main, tests, funcs used as values)
+                               pass context.TODO(). --dry-run: only show the diff
 
 VALIDATION
   format [target]         Format code (goimports/gofmt)
   check [dir]             Run go build + go vet
   test [pkg]              Run tests
+  coverage [pkg]          Coverage per function with uncovered file:N:M ranges
+                          (--func Name, --tests: tests hitting each function)
+
+GLOBAL OPTIONS (all commands)
+  --tags a,b              Build tags files are matched against
+  --goos os --goarch arch Target platform instead of the host's
+  --force                 Let modifying commands edit generated files
+                          ("Code generated ... DO NOT EDIT.")
+  Symbols report the build constraint of their file and whether it is
+  generated; modifying commands warn about definitions in files the
+  configuration excludes.
 
 EXAMPLES
   gorefactor find HandleRequest
@@ -324,6 +803,49 @@ Output is JSON. File argument is optional - tool auto-finds in project.`
 	fmt.Fprintln(os.Stderr, usage)
 }
 
+// globalFlags applies the flags every command accepts, the build
+// configuration and --force, and returns the remaining arguments.
+func globalFlags(args []string) []string {
+	opts := &refactor.BuildOptions{}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--force" {
+			refactor.SetForce(true)
+			continue
+		}
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--tags" && name != "--goos" && name != "--goarch" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				fatal(fmt.Sprintf("%s needs a value", name))
+			}
+			value = args[i+1]
+			i++
+		}
+		switch name {
+		case "--tags":
+			opts.Tags = append(opts.Tags, strings.Split(value, ",")...)
+		case "--goos":
+			opts.GOOS = value
+		case "--goarch":
+			opts.GOARCH = value
+		}
+	}
+	refactor.SetBuildOptions(opts)
+	return rest
+}
+
+func atoiArg(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		fatal(fmt.Sprintf("invalid number: %s", s))
+	}
+	return n
+}
+
 func fatal(msg string) {
 	if !strings.HasPrefix(msg, "{") {
 		msg = fmt.Sprintf(`{"success":false,"error":%q}`, msg)
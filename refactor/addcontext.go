@@ -0,0 +1,297 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// ContextRoot is a caller that passes context.TODO() instead of taking a
+// context itself.
+type ContextRoot struct {
+	Func   string `json:"func"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+type AddContextResult struct {
+	Success      bool          `json:"success"`
+	Func         string        `json:"func"`
+	Threaded     []string      `json:"threaded"` // functions that gained the ctx parameter
+	Roots        []ContextRoot `json:"roots,omitempty"`
+	CallsUpdated int           `json:"callsUpdated"`
+	FilesChanged []string      `json:"filesChanged"`
+	Diff         string        `json:"diff"`
+	DryRun       bool          `json:"dryRun,omitempty"`
+	Message      string        `json:"message"`
+	Warnings     []string      `json:"warnings,omitempty"`
+}
+
+type AddContextOptions struct {
+	Roots  []string // functions that get context.TODO() rather than a ctx parameter
+	DryRun bool     // report the diff without writing
+}
+
+// contextThreader adds a ctx parameter to functions and passes it at their
+// call sites, queueing callers that have no context to pass.
+type contextThreader struct {
+	prog    *program
+	roots   []string
+	gaining map[*types.Func]bool
+	queue   []*types.Func
+	called  map[*ast.Ident]bool // function names in call position
+	edits   []textEdit
+	result  *AddContextResult
+}
+
+// AddContext adds "ctx context.Context" as the first parameter of
+// funcName and passes a context at every call. A caller with a
+// context.Context in scope passes it, or r.Context() for an
+// *http.Request r; any other caller takes a ctx parameter itself, up the
+// call chain. Roots pass context.TODO() instead: opts.Roots, main, init,
+// tests, package-level initializers and functions whose signature is
+// fixed because they are used as values or implement an interface. All
+// files change together, or none does.
+func AddContext(funcName string, opts *AddContextOptions) (*AddContextResult, error) {
+	if opts == nil {
+		opts = &AddContextOptions{}
+	}
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	_, decl, fn := prog.lookupFunc(funcName)
+	if decl == nil || fn == nil {
+		return nil, fmt.Errorf("function %s not found", funcName)
+	}
+	if decl.Body == nil {
+		return nil, fmt.Errorf("%s has no body", funcName)
+	}
+	if sig := fn.Type().(*types.Signature); sig.Params().Len() > 0 && isContextType(sig.Params().At(0).Type()) {
+		return nil, fmt.Errorf("%s already takes a context.Context", funcName)
+	}
+
+	t := &contextThreader{
+		prog:    prog,
+		roots:   opts.Roots,
+		gaining: map[*types.Func]bool{fn: true},
+		queue:   []*types.Func{fn},
+		called:  prog.calledIdents(),
+		result:  &AddContextResult{Success: true, Func: funcDeclName(decl), DryRun: opts.DryRun},
+	}
+	if reason := t.fixedSignature(fn); reason != "" {
+		return nil, fmt.Errorf("cannot change the signature of %s: %s", funcName, reason)
+	}
+
+	for len(t.queue) > 0 {
+		f := t.queue[0]
+		t.queue = t.queue[1:]
+		if err := t.thread(f); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := renderEdits(t.edits)
+	if err != nil {
+		return nil, err
+	}
+	result := t.result
+	result.FilesChanged = r.files
+	result.Diff = r.diff(prog.Root)
+	if !opts.DryRun {
+		if err := r.write(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(result.Threaded)
+	result.Message = fmt.Sprintf("ctx added to %d function(s), %d call(s) updated, %d root(s) pass context.TODO()",
+		len(result.Threaded), result.CallsUpdated, len(result.Roots))
+	if opts.DryRun {
+		result.Message = "dry run: " + result.Message
+	}
+	return result, nil
+}
+
+// thread adds the parameter to f and a context argument to its calls.
+func (t *contextThreader) thread(f *types.Func) error {
+	pkg, decl := t.prog.declOf(f)
+	if decl == nil {
+		return fmt.Errorf("declaration of %s not found", t.prog.funcName(f))
+	}
+	name := funcDeclName(decl)
+	for id, obj := range pkg.Info.Defs {
+		if obj != nil && id.Name == "ctx" && decl.Pos() <= id.Pos() && id.Pos() < decl.End() {
+			return fmt.Errorf("%s already declares ctx at %s; rename it first", name, t.prog.position(id.Pos()))
+		}
+	}
+
+	file := fileOf(pkg, decl.Pos())
+	param := t.qualifier(file) + ".Context"
+	if decl.Type.Params.NumFields() > 0 {
+		param += ", "
+	}
+	t.edits = append(t.edits, t.prog.edit(decl.Type.Params.Opening+1, decl.Type.Params.Opening+1, "ctx "+param))
+	t.result.Threaded = append(t.result.Threaded, name)
+	if ast.IsExported(decl.Name.Name) && pkg.Name != "main" {
+		t.result.Warnings = append(t.result.Warnings, fmt.Sprintf("exported %s changes signature; callers outside the workspace must be updated", name))
+	}
+
+	var err error
+	t.prog.callSites(func(cs callSite) {
+		if err != nil || cs.callee == nil || cs.callee.Origin() != f {
+			return
+		}
+		if len(cs.call.Args) == 1 {
+			if tuple, ok := cs.pkg.Info.TypeOf(cs.call.Args[0]).(*types.Tuple); ok && tuple.Len() > 1 {
+				err = fmt.Errorf("%s passes a multi-value call to %s; split it first", t.prog.position(cs.call.Pos()), name)
+				return
+			}
+		}
+		arg := t.contextIn(cs)
+		if len(cs.call.Args) > 0 {
+			arg += ", "
+		}
+		t.edits = append(t.edits, t.prog.edit(cs.call.Lparen+1, cs.call.Lparen+1, arg))
+		t.result.CallsUpdated++
+	})
+	return err
+}
+
+// contextIn returns the context a call site passes: a context.Context or
+// the context of an *http.Request in scope, ctx when the caller takes one
+// (queueing it if needed), or context.TODO() at roots.
+func (t *contextThreader) contextIn(cs callSite) string {
+	pos := cs.call.Pos()
+	var request string
+	for s := cs.pkg.Types.Scope().Innermost(pos); s != nil && s != cs.pkg.Types.Scope(); s = s.Parent() {
+		for _, name := range s.Names() {
+			v, ok := s.Lookup(name).(*types.Var)
+			if !ok || name == "_" || v.Pos() >= pos {
+				continue
+			}
+			if _, obj := s.LookupParent(name, pos); obj != v {
+				continue
+			}
+			if isContextType(v.Type()) {
+				return name
+			}
+			if ptr, ok := v.Type().(*types.Pointer); ok && request == "" && isNamed(ptr.Elem(), "net/http", "Request") {
+				request = name + ".Context()"
+			}
+		}
+	}
+	if request != "" {
+		return request
+	}
+
+	todo := t.qualifier(cs.file) + ".TODO()"
+	if cs.caller == nil {
+		t.root("", cs, "package-level initializer")
+		return todo
+	}
+	caller, _ := cs.pkg.Info.Defs[cs.caller.Name].(*types.Func)
+	if caller == nil {
+		return todo
+	}
+	if t.gaining[caller] {
+		return "ctx"
+	}
+	if reason := t.rootReason(caller, cs); reason != "" {
+		t.root(funcDeclName(cs.caller), cs, reason)
+		return todo
+	}
+	t.gaining[caller] = true
+	t.queue = append(t.queue, caller)
+	return "ctx"
+}
+
+func (t *contextThreader) root(name string, cs callSite, reason string) {
+	pos := t.prog.position(cs.call.Pos())
+	for _, r := range t.result.Roots {
+		if r.Func == name && r.File == pos.Filename && name != "" {
+			return
+		}
+	}
+	t.result.Roots = append(t.result.Roots, ContextRoot{Func: name, File: pos.Filename, Line: pos.Line, Reason: reason})
+}
+
+// rootReason says why a caller passes context.TODO() rather than taking a
+// ctx parameter, or returns "" if it should take one.
+func (t *contextThreader) rootReason(caller *types.Func, cs callSite) string {
+	for _, root := range t.roots {
+		if matchFunc(cs.caller, root) {
+			return "configured root"
+		}
+	}
+	name := cs.caller.Name.Name
+	if cs.caller.Recv == nil && (name == "main" || name == "init") {
+		return name
+	}
+	if strings.HasSuffix(t.prog.position(cs.caller.Pos()).Filename, "_test.go") {
+		for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+			if strings.HasPrefix(name, prefix) {
+				return "test function"
+			}
+		}
+	}
+	return t.fixedSignature(caller)
+}
+
+// fixedSignature explains why the signature of fn cannot change: it is
+// used as a value, or it is a method implementing an interface.
+func (t *contextThreader) fixedSignature(fn *types.Func) string {
+	for _, pkg := range t.prog.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		for id, obj := range pkg.Info.Uses {
+			if f, ok := obj.(*types.Func); ok && f.Origin() == fn && !t.called[id] {
+				return fmt.Sprintf("used as a value at %s", t.prog.position(id.Pos()))
+			}
+		}
+	}
+	if sig := fn.Type().(*types.Signature); sig.Recv() != nil {
+		if ifaces := t.prog.satisfiedInterfaces(sig.Recv().Type(), fn.Name()); len(ifaces) > 0 {
+			return "it implements " + strings.Join(ifaces, ", ")
+		}
+	}
+	return ""
+}
+
+// qualifier returns the name file imports the context package by, adding
+// the import if needed.
+func (t *contextThreader) qualifier(file *ast.File) string {
+	if name := importName(file, "context"); name != "" {
+		return name
+	}
+	for _, e := range t.edits {
+		if e.File == t.prog.position(file.Pos()).Filename && strings.Contains(e.Text, `"context"`) {
+			return "context"
+		}
+	}
+	t.edits = append(t.edits, t.prog.addImports(file, "context")...)
+	return "context"
+}
+
+func isContextType(t types.Type) bool {
+	return isNamed(t, "context", "Context")
+}
+
+func isNamed(t types.Type, pkgPath, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == pkgPath && named.Obj().Name() == name
+}
+
+// fileOf returns the file of pkg containing pos.
+func fileOf(pkg *loadedPackage, pos token.Pos) *ast.File {
+	for _, f := range pkg.Files {
+		if f.Pos() <= pos && pos < f.End() {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestAddContext(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": `package app
+
+import "net/http"
+
+func load(id int) int { return id }
+
+func get(id int) int { return load(id) }
+
+func Handler(w http.ResponseWriter, r *http.Request) { get(1) }
+
+func Run() { get(2) }
+
+var handle = http.HandlerFunc(Handler)
+`,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.AddContext("load", &refactor.AddContextOptions{Roots: []string{"Run"}, DryRun: true})
+	if err != nil {
+		t.Fatalf("AddContext error: %v", err)
+	}
+	if !strings.Contains(result.Diff, "+func get(ctx context.Context, id int) int { return load(ctx, id) }") {
+		t.Errorf("diff should thread ctx through get:\n%s", result.Diff)
+	}
+	if content := readModuleFile(t, dir, "app.go"); strings.Contains(content, "ctx") {
+		t.Fatalf("dry run wrote the file:\n%s", content)
+	}
+
+	if _, err := refactor.AddContext("load", &refactor.AddContextOptions{Roots: []string{"Run"}}); err != nil {
+		t.Fatalf("AddContext error: %v", err)
+	}
+	content := readModuleFile(t, dir, "app.go")
+	for _, s := range []string{"get(r.Context(), 1)", "get(context.TODO(), 2)", `"context"`} {
+		if !strings.Contains(content, s) {
+			t.Errorf("expected %s:\n%s", s, content)
+		}
+	}
+
+	if _, err := refactor.AddContext("Handler", nil); err == nil || !strings.Contains(err.Error(), "used as a value") {
+		t.Errorf("expected Handler to be refused, got %v", err)
+	}
+}
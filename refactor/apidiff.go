@@ -0,0 +1,674 @@
+package refactor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type APIChange struct {
+	Package  string `json:"package"`
+	Symbol   string `json:"symbol"`
+	Kind     string `json:"kind"`
+	Change   string `json:"change"`
+	Breaking bool   `json:"breaking"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Message  string `json:"message"`
+}
+
+type APIDiffResult struct {
+	Success    bool        `json:"success"`
+	Old        string      `json:"old"`
+	New        string      `json:"new"`
+	Packages   []string    `json:"packages"`
+	Changes    []APIChange `json:"changes"`
+	Breaking   int         `json:"breaking"`
+	Compatible int         `json:"compatible"`
+	Bump       string      `json:"bump"`
+	Version    string      `json:"version,omitempty"`
+}
+
+// apiSymbol is the comparable shape of an exported declaration. Decl holds
+// the parts whose change breaks callers; Members holds struct fields or
+// interface methods, compared one by one; Recv is the receiver of a method
+// and Value the value expression of a constant, with its iota if it uses
+// one.
+//
+// PackageAPI is not reused: it reads a directory on disk while both sides
+// here come from git objects, its signatures keep parameter names, which
+// callers never depend on, and it has no struct fields or interface methods.
+type apiSymbol struct {
+	Kind    string
+	Decl    string
+	Recv    string
+	Value   string
+	Members map[string]string
+}
+
+// apiPackage maps symbol names, in SymbolLocation.Name form, to their shape.
+type apiPackage map[string]*apiSymbol
+
+// APIDiff compares the exported API of packages between two git revisions
+// and classifies every change as compatible or breaking. Both revisions are
+// read straight from git objects; an empty newRef compares against the
+// working tree. pkgs are directories relative to the module root, all
+// non-internal library packages when empty.
+func APIDiff(oldRef, newRef string, pkgs []string) (*APIDiffResult, error) {
+	root, modulePath, err := findModuleRoot(".")
+	if err != nil {
+		return nil, err
+	}
+
+	oldFiles, err := gitTreeFiles(root, oldRef)
+	if err != nil {
+		return nil, err
+	}
+	var newFiles map[string][]byte
+	if newRef == "" {
+		newFiles, err = worktreeFiles(root)
+	} else {
+		newFiles, err = gitTreeFiles(root, newRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	oldAPI := extractAPI(oldFiles)
+	newAPI := extractAPI(newFiles)
+
+	dirs := make(map[string]bool)
+	if len(pkgs) == 0 {
+		for dir := range oldAPI {
+			dirs[dir] = true
+		}
+		for dir := range newAPI {
+			dirs[dir] = true
+		}
+	} else {
+		for _, p := range pkgs {
+			p = path.Clean(filepath.ToSlash(p))
+			if p == "./..." || p == "..." {
+				for dir := range oldAPI {
+					dirs[dir] = true
+				}
+				for dir := range newAPI {
+					dirs[dir] = true
+				}
+				continue
+			}
+			dirs[strings.TrimPrefix(strings.TrimPrefix(p, modulePath), "/")] = true
+		}
+	}
+
+	result := &APIDiffResult{Success: true, Old: oldRef, New: newRef}
+	if newRef == "" {
+		result.New = "worktree"
+	}
+	for dir := range dirs {
+		if dir == "" {
+			dir = "."
+		}
+		if len(pkgs) == 0 && isInternalDir(dir) {
+			continue
+		}
+		importPath := modulePath
+		if dir != "." {
+			importPath += "/" + dir
+		}
+		result.Packages = append(result.Packages, importPath)
+		result.Changes = append(result.Changes, diffPackage(importPath, oldAPI[dir], newAPI[dir])...)
+	}
+	sort.Strings(result.Packages)
+	sort.SliceStable(result.Changes, func(i, j int) bool {
+		a, b := result.Changes[i], result.Changes[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Symbol < b.Symbol
+	})
+
+	for _, c := range result.Changes {
+		if c.Breaking {
+			result.Breaking++
+		} else {
+			result.Compatible++
+		}
+	}
+	switch {
+	case result.Breaking > 0:
+		result.Bump = "major"
+	case result.Compatible > 0:
+		result.Bump = "minor"
+	default:
+		result.Bump = "patch"
+	}
+	result.Version = nextVersion(oldRef, result.Bump)
+	return result, nil
+}
+
+func isInternalDir(dir string) bool {
+	for _, part := range strings.Split(dir, "/") {
+		if part == "internal" {
+			return true
+		}
+	}
+	return false
+}
+
+// nextVersion suggests the version following ref when ref is a semver tag.
+// Below v1 breaking changes only bump the minor version.
+func nextVersion(ref, bump string) string {
+	v := strings.TrimPrefix(ref, "v")
+	if v == ref {
+		return ""
+	}
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	var n [3]int
+	for i, p := range parts {
+		if i == 2 {
+			p, _, _ = strings.Cut(p, "-")
+			p, _, _ = strings.Cut(p, "+")
+		}
+		x, err := strconv.Atoi(p)
+		if err != nil {
+			return ""
+		}
+		n[i] = x
+	}
+	if bump == "major" && n[0] == 0 {
+		bump = "minor"
+	}
+	switch bump {
+	case "major":
+		n = [3]int{n[0] + 1, 0, 0}
+	case "minor":
+		n = [3]int{n[0], n[1] + 1, 0}
+	default:
+		n[2]++
+	}
+	return fmt.Sprintf("v%d.%d.%d", n[0], n[1], n[2])
+}
+
+// gitTreeFiles reads the Go files of the module at root as of ref, using
+// git ls-tree and git cat-file so that nothing is checked out.
+func gitTreeFiles(root, ref string) (map[string][]byte, error) {
+	prefix, err := gitOutput(root, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimSpace(prefix)
+
+	list, err := gitOutput(root, "ls-tree", "-r", "--full-name", "--name-only", ref, "--", ".")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read revision %s: %v", ref, err)
+	}
+
+	// Nested modules have an API of their own.
+	var nested []string
+	for _, name := range strings.Split(list, "\n") {
+		if dir := path.Dir(strings.TrimPrefix(name, prefix)); path.Base(name) == "go.mod" && dir != "." {
+			nested = append(nested, dir)
+		}
+	}
+
+	var names []string
+	var input bytes.Buffer
+	for _, name := range strings.Split(list, "\n") {
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || inNestedModule(strings.TrimPrefix(name, prefix), nested) {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(name, prefix))
+		fmt.Fprintf(&input, "%s:%s\n", ref, name)
+	}
+	if len(names) == 0 {
+		return map[string][]byte{}, nil
+	}
+
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = root
+	cmd.Stdin = &input
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, name := range names {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		files[name] = content[:size]
+	}
+	return files, nil
+}
+
+// inNestedModule reports whether the slash-separated path name lies in one
+// of the module directories nested.
+func inNestedModule(name string, nested []string) bool {
+	for _, dir := range nested {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevision reports whether ref names a commit in the current repository.
+func IsRevision(ref string) bool {
+	_, err := gitOutput(".", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	return err == nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// worktreeFiles reads the Go files of the module at root from disk, keyed
+// like gitTreeFiles.
+func worktreeFiles(root string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if p != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil && p != root {
+				return filepath.SkipDir // a nested module
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = src
+		return nil
+	})
+	return files, err
+}
+
+// extractAPI groups files by directory and collects the exported API of
+// each library package, honouring build constraints of the host platform.
+func extractAPI(files map[string][]byte) map[string]apiPackage {
+	ctxt := buildContext
+	ctxt.OpenFile = func(name string) (io.ReadCloser, error) {
+		src, ok := files[filepath.ToSlash(name)]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(bytes.NewReader(src)), nil
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fset := token.NewFileSet()
+	result := make(map[string]apiPackage)
+	for _, name := range names {
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if dir == "" {
+			dir = "."
+		}
+		if isSkippedPath(dir) {
+			continue
+		}
+		if ok, err := ctxt.MatchFile(dir, base); err != nil || !ok {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, files[name], 0)
+		if err != nil || f.Name.Name == "main" {
+			continue
+		}
+		api := result[dir]
+		if api == nil {
+			api = make(apiPackage)
+			result[dir] = api
+		}
+		collectAPI(api, f)
+	}
+	return result
+}
+
+func isSkippedPath(dir string) bool {
+	for _, part := range strings.Split(dir, "/") {
+		if part != "." && (strings.HasPrefix(part, ".") || strings.HasPrefix(part, "_") || part == "vendor" || part == "testdata") {
+			return true
+		}
+	}
+	return false
+}
+
+func collectAPI(api apiPackage, f *ast.File) {
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !ast.IsExported(d.Name.Name) {
+				continue
+			}
+			name, sig, recv := d.Name.Name, "", ""
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv = types.ExprString(d.Recv.List[0].Type)
+				base := strings.TrimPrefix(recv, "*")
+				if i := strings.IndexByte(base, '['); i >= 0 {
+					base = base[:i]
+				}
+				if !ast.IsExported(base) {
+					continue
+				}
+				name = base + "." + name
+				sig = "(" + recv + ") "
+			}
+			api[name] = &apiSymbol{Kind: "func", Decl: sig + "func" + apiFuncType(d.Type), Recv: recv}
+
+		case *ast.GenDecl:
+			var lastType ast.Expr
+			var lastValues []ast.Expr
+			for index, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if ast.IsExported(s.Name.Name) {
+						api[s.Name.Name] = apiType(s)
+					}
+
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+						// Implicitly repeated const specs inherit the type
+						// and the values.
+						if s.Type != nil || len(s.Values) > 0 {
+							lastType, lastValues = s.Type, s.Values
+						}
+					} else {
+						lastType = s.Type
+					}
+					typ := ""
+					if lastType != nil {
+						typ = types.ExprString(lastType)
+					}
+					for i, ident := range s.Names {
+						if !ast.IsExported(ident.Name) {
+							continue
+						}
+						sym := &apiSymbol{Kind: kind, Decl: typ}
+						if kind == "const" && i < len(lastValues) {
+							sym.Value = constValue(lastValues[i], index)
+						}
+						api[ident.Name] = sym
+					}
+				}
+			}
+		}
+	}
+}
+
+// constValue renders the value expression of a constant, with the iota
+// of its spec when the expression uses it.
+func constValue(e ast.Expr, iota int) string {
+	value := types.ExprString(e)
+	usesIota := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+			usesIota = true
+		}
+		return !usesIota
+	})
+	if usesIota {
+		value += fmt.Sprintf(" (iota %d)", iota)
+	}
+	return value
+}
+
+func apiType(s *ast.TypeSpec) *apiSymbol {
+	var tparams string
+	if s.TypeParams != nil {
+		tparams = "[" + apiFieldTypes(s.TypeParams, true) + "]"
+	}
+	if s.Assign.IsValid() {
+		return &apiSymbol{Kind: "type", Decl: tparams + "= " + types.ExprString(s.Type)}
+	}
+
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		sym := &apiSymbol{Kind: "struct", Decl: tparams + "struct", Members: make(map[string]string)}
+		for _, field := range t.Fields.List {
+			typ := types.ExprString(field.Type)
+			if len(field.Names) == 0 {
+				name := strings.TrimPrefix(typ, "*")
+				if i := strings.LastIndexByte(name, '.'); i >= 0 {
+					name = name[i+1:]
+				}
+				if i := strings.IndexByte(name, '['); i >= 0 {
+					name = name[:i]
+				}
+				if ast.IsExported(name) {
+					sym.Members[name] = "embedded " + typ
+				}
+				continue
+			}
+			for _, n := range field.Names {
+				if ast.IsExported(n.Name) {
+					sym.Members[n.Name] = typ
+				}
+			}
+		}
+		return sym
+
+	case *ast.InterfaceType:
+		// Unexported methods count too: adding one makes the interface
+		// impossible to implement outside the package.
+		sym := &apiSymbol{Kind: "interface", Decl: tparams + "interface", Members: make(map[string]string)}
+		for _, m := range t.Methods.List {
+			if ft, ok := m.Type.(*ast.FuncType); ok && len(m.Names) > 0 {
+				sym.Members[m.Names[0].Name] = "func" + apiFuncType(ft)
+			} else {
+				sym.Members[types.ExprString(m.Type)] = "embedded"
+			}
+		}
+		return sym
+	}
+	return &apiSymbol{Kind: "type", Decl: tparams + types.ExprString(s.Type)}
+}
+
+// apiFuncType renders a signature without parameter names, which callers
+// never depend on.
+func apiFuncType(ft *ast.FuncType) string {
+	var b strings.Builder
+	if ft.TypeParams != nil {
+		b.WriteString("[" + apiFieldTypes(ft.TypeParams, true) + "]")
+	}
+	b.WriteString("(" + apiFieldTypes(ft.Params, false) + ")")
+	if ft.Results != nil && len(ft.Results.List) > 0 {
+		results := apiFieldTypes(ft.Results, false)
+		if len(ft.Results.List) == 1 && len(ft.Results.List[0].Names) <= 1 {
+			b.WriteString(" " + results)
+		} else {
+			b.WriteString(" (" + results + ")")
+		}
+	}
+	return b.String()
+}
+
+// apiFieldTypes lists the types of a field list, once per name. Type
+// parameter lists keep their names as they are part of the constraint.
+func apiFieldTypes(list *ast.FieldList, keepNames bool) string {
+	if list == nil {
+		return ""
+	}
+	var parts []string
+	for _, field := range list.List {
+		typ := types.ExprString(field.Type)
+		if len(field.Names) == 0 {
+			parts = append(parts, typ)
+			continue
+		}
+		for _, n := range field.Names {
+			if keepNames {
+				parts = append(parts, n.Name+" "+typ)
+			} else {
+				parts = append(parts, typ)
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func diffPackage(pkg string, oldAPI, newAPI apiPackage) []APIChange {
+	var changes []APIChange
+	for name, o := range oldAPI {
+		n, ok := newAPI[name]
+		if !ok {
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: o.Kind, Change: "removed", Breaking: true,
+				Old: o.Decl, Message: fmt.Sprintf("%s %s removed", o.Kind, name),
+			})
+			continue
+		}
+		if o.Kind != n.Kind {
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: n.Kind, Change: "changed", Breaking: true,
+				Old: o.Kind, New: n.Kind, Message: fmt.Sprintf("%s changed from %s to %s", name, o.Kind, n.Kind),
+			})
+			continue
+		}
+		if o.Decl != n.Decl && pointerToValue(o, n) {
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: o.Kind, Change: "changed",
+				Old: o.Decl, New: n.Decl, Message: fmt.Sprintf("receiver of %s changed from pointer to value", name),
+			})
+		} else if o.Decl != n.Decl {
+			msg := fmt.Sprintf("%s %s changed", o.Kind, name)
+			if o.Kind == "func" {
+				msg = fmt.Sprintf("signature of %s changed", name)
+			}
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: o.Kind, Change: "changed", Breaking: true,
+				Old: o.Decl, New: n.Decl, Message: msg,
+			})
+		}
+		if o.Value != n.Value {
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: o.Kind, Change: "changed", Breaking: true,
+				Old: o.Value, New: n.Value, Message: fmt.Sprintf("value of const %s changed", name),
+			})
+		}
+		changes = append(changes, diffMembers(pkg, name, o, n)...)
+	}
+	for name, n := range newAPI {
+		if _, ok := oldAPI[name]; !ok {
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: name, Kind: n.Kind, Change: "added",
+				New: n.Decl, Message: fmt.Sprintf("%s %s added", n.Kind, name),
+			})
+		}
+	}
+	return changes
+}
+
+// pointerToValue reports whether a method only moved from a pointer to a
+// value receiver. The method stays in the method set of *T and joins that
+// of T, so every existing call and interface assignment still compiles.
+func pointerToValue(o, n *apiSymbol) bool {
+	if o.Recv == "" || o.Recv != "*"+n.Recv {
+		return false
+	}
+	return strings.TrimPrefix(o.Decl, "("+o.Recv+") ") == strings.TrimPrefix(n.Decl, "("+n.Recv+") ")
+}
+
+// diffMembers compares struct fields and interface methods. New fields are
+// compatible; a new interface method breaks every outside implementation.
+func diffMembers(pkg, typeName string, o, n *apiSymbol) []APIChange {
+	member := "field"
+	if o.Kind == "interface" {
+		member = "method"
+	}
+
+	var changes []APIChange
+	for name, ot := range o.Members {
+		symbol := typeName + "." + name
+		nt, ok := n.Members[name]
+		switch {
+		case !ok:
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: symbol, Kind: member, Change: "removed", Breaking: true,
+				Old: ot, Message: fmt.Sprintf("%s %s removed from %s", member, name, typeName),
+			})
+		case ot != nt:
+			changes = append(changes, APIChange{
+				Package: pkg, Symbol: symbol, Kind: member, Change: "changed", Breaking: true,
+				Old: ot, New: nt, Message: fmt.Sprintf("type of %s %s changed", member, symbol),
+			})
+		}
+	}
+	for name, nt := range n.Members {
+		if _, ok := o.Members[name]; ok {
+			continue
+		}
+		c := APIChange{
+			Package: pkg, Symbol: typeName + "." + name, Kind: member, Change: "added",
+			New: nt, Message: fmt.Sprintf("%s %s added to %s", member, name, typeName),
+		}
+		if o.Kind == "interface" {
+			c.Breaking = true
+			c.Message += "; existing implementations no longer satisfy it"
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
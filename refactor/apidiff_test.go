@@ -0,0 +1,142 @@
+package refactor_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestAPIDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/lib\n\ngo 1.21\n",
+		"lib.go": `package lib
+
+type Config struct {
+	Name string
+	Port int
+}
+
+type Store interface {
+	Get(key string) string
+}
+
+func Open(path string) error { return nil }
+
+func Close() {}
+
+func (c *Config) Addr() string { return "" }
+
+func (c Config) Valid() bool { return true }
+
+const Version = "1"
+
+const (
+	Low = iota
+	High
+)
+`,
+		"internal/x/x.go": "package x\n\nfunc Gone() {}\n",
+		"tools/go.mod":    "module example.com/lib/tools\n\ngo 1.21\n",
+		"tools/tools.go":  "package tools\n\nfunc Gone() {}\n",
+	})
+	git(t, dir, "init", "-q")
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "v1")
+	git(t, dir, "tag", "v1.2.3")
+
+	os.WriteFile(filepath.Join(dir, "lib.go"), []byte(`package lib
+
+type Config struct {
+	Name    string
+	Timeout int
+}
+
+type Store interface {
+	Get(k string) string
+	Set(key, value string)
+}
+
+func Open(p string) error { return nil }
+
+func Close(force bool) {}
+
+func New() *Config { return nil }
+
+func (c Config) Addr() string { return "" }
+
+func (c *Config) Valid() bool { return true }
+
+const Version = "2"
+
+const (
+	Low = iota
+	Mid
+	High
+)
+`), 0644)
+	os.Remove(filepath.Join(dir, "internal/x/x.go"))
+	os.WriteFile(filepath.Join(dir, "tools/tools.go"), []byte("package tools\n"), 0644)
+	chdir(t, dir)
+
+	result, err := refactor.APIDiff("v1.2.3", "", nil)
+	if err != nil {
+		t.Fatalf("APIDiff error: %v", err)
+	}
+
+	changes := make(map[string]refactor.APIChange)
+	for _, c := range result.Changes {
+		changes[c.Symbol] = c
+	}
+	want := map[string]bool{
+		"Config.Port":    true,
+		"Config.Timeout": false,
+		"Store.Set":      true,
+		"Close":          true,
+		"New":            false,
+		"Config.Addr":    false,
+		"Config.Valid":   true,
+		"Version":        true,
+		"Mid":            false,
+		"High":           true,
+	}
+	for symbol, breaking := range want {
+		c, ok := changes[symbol]
+		if !ok {
+			t.Errorf("expected change for %s", symbol)
+			continue
+		}
+		if c.Breaking != breaking {
+			t.Errorf("%s: expected breaking=%v, got %+v", symbol, breaking, c)
+		}
+	}
+	if len(result.Changes) != len(want) {
+		t.Errorf("parameter renames, internal packages and nested modules should not count: %+v", result.Changes)
+	}
+	if result.Bump != "major" || result.Version != "v2.0.0" {
+		t.Errorf("expected major bump to v2.0.0, got %s %s", result.Bump, result.Version)
+	}
+
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "v2")
+	committed, err := refactor.APIDiff("v1.2.3", "HEAD", nil)
+	if err != nil {
+		t.Fatalf("APIDiff error: %v", err)
+	}
+	if len(committed.Changes) != len(result.Changes) {
+		t.Errorf("git and worktree comparisons differ: %+v", committed.Changes)
+	}
+}
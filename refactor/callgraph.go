@@ -0,0 +1,448 @@
+package refactor
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type GraphNode struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Package  string `json:"package"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	External bool   `json:"external,omitempty"`
+}
+
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Dispatch string `json:"dispatch"`
+	Count    int    `json:"count"`
+}
+
+type CallGraphResult struct {
+	Success bool        `json:"success"`
+	Algo    string      `json:"algo"`
+	Format  string      `json:"format"`
+	Focus   string      `json:"focus,omitempty"`
+	Nodes   []GraphNode `json:"nodes,omitempty"`
+	Edges   []GraphEdge `json:"edges,omitempty"`
+	Graph   string      `json:"graph,omitempty"`
+}
+
+type CallGraphOptions struct {
+	Format string // json, dot, mermaid
+	Algo   string // static, cha, rta
+	Focus  string
+	Stdlib string // standard library callees: include, collapse, exclude
+}
+
+type callGraph struct {
+	prog  *program
+	opts  *CallGraphOptions
+	nodes map[string]*GraphNode
+	edges map[[2]string]*GraphEdge
+}
+
+// CallGraph builds the call graph of the package in pkgPath, or of the whole
+// module when pkgPath is empty or "./...".
+//
+// The static algorithm only follows calls whose target is known at compile
+// time. CHA additionally resolves interface calls to every module type that
+// implements the interface, RTA only to types instantiated in code reachable
+// from main, init, tests and exported functions.
+//
+// opts.Stdlib decides what becomes of calls into the standard library:
+// a node per function, one per package, or none. Functions of other
+// dependencies are always nodes of their own, marked external.
+func CallGraph(pkgPath string, opts *CallGraphOptions) (*CallGraphResult, error) {
+	if opts == nil {
+		opts = &CallGraphOptions{}
+	}
+	if opts.Format == "" {
+		opts.Format = "json"
+	}
+	if opts.Algo == "" {
+		opts.Algo = "static"
+	}
+	if opts.Stdlib == "" {
+		opts.Stdlib = "include"
+	}
+	switch opts.Algo {
+	case "static", "cha", "rta":
+	default:
+		return nil, fmt.Errorf("unknown algorithm %s (want static, cha or rta)", opts.Algo)
+	}
+	switch opts.Format {
+	case "json", "dot", "mermaid":
+	default:
+		return nil, fmt.Errorf("unknown format %s (want json, dot or mermaid)", opts.Format)
+	}
+	switch opts.Stdlib {
+	case "include", "collapse", "exclude":
+	default:
+		return nil, fmt.Errorf("unknown stdlib mode %s (want include, collapse or exclude)", opts.Stdlib)
+	}
+
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var scope string
+	if pkgPath != "" && pkgPath != "./..." {
+		scope, err = filepath.Abs(strings.TrimSuffix(pkgPath, "/..."))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g := &callGraph{
+		prog:  prog,
+		opts:  opts,
+		nodes: make(map[string]*GraphNode),
+		edges: make(map[[2]string]*GraphEdge),
+	}
+
+	implementers := prog.methodImplementers()
+	var allocated map[types.Type]bool
+	if opts.Algo == "rta" {
+		allocated = prog.reachableAllocations(implementers)
+	}
+
+	prog.callSites(func(cs callSite) {
+		if cs.caller == nil || (scope != "" && !withinDir(cs.pkg.Dir, scope, strings.HasSuffix(pkgPath, "/..."))) {
+			return
+		}
+		caller, ok := cs.pkg.Info.Defs[cs.caller.Name].(*types.Func)
+		if !ok || cs.callee == nil {
+			return
+		}
+		from := g.addNode(caller)
+		if cs.dispatch != DispatchInterface {
+			if to := g.addNode(cs.callee); to != "" {
+				g.addEdge(from, to, cs.dispatch)
+			}
+			return
+		}
+		if opts.Algo == "static" {
+			return
+		}
+		for _, impl := range implementers[cs.callee] {
+			if allocated != nil && !allocated[recvBase(impl)] {
+				continue
+			}
+			if to := g.addNode(impl); to != "" {
+				g.addEdge(from, to, DispatchInterface)
+			}
+		}
+	})
+
+	if opts.Focus != "" {
+		_, decl, fn := prog.lookupFunc(opts.Focus)
+		if fn == nil {
+			return nil, fmt.Errorf("function %s not found", opts.Focus)
+		}
+		g.focus(g.nodeID(fn))
+		opts.Focus = funcDeclName(decl)
+	}
+
+	result := &CallGraphResult{
+		Success: true,
+		Algo:    opts.Algo,
+		Format:  opts.Format,
+		Focus:   opts.Focus,
+	}
+	for _, n := range g.nodes {
+		result.Nodes = append(result.Nodes, *n)
+	}
+	for _, e := range g.edges {
+		result.Edges = append(result.Edges, *e)
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].ID < result.Nodes[j].ID })
+	sort.Slice(result.Edges, func(i, j int) bool {
+		if result.Edges[i].From != result.Edges[j].From {
+			return result.Edges[i].From < result.Edges[j].From
+		}
+		return result.Edges[i].To < result.Edges[j].To
+	})
+
+	switch opts.Format {
+	case "dot":
+		result.Graph = renderDot(result.Nodes, result.Edges)
+		result.Nodes, result.Edges = nil, nil
+	case "mermaid":
+		result.Graph = renderMermaid(result.Nodes, result.Edges)
+		result.Nodes, result.Edges = nil, nil
+	}
+
+	return result, nil
+}
+
+func withinDir(dir, scope string, recursive bool) bool {
+	if dir == scope {
+		return true
+	}
+	return recursive && strings.HasPrefix(dir, scope+string(filepath.Separator))
+}
+
+// nodeID identifies fn in the graph. Module functions are qualified by their
+// import path so that equally named functions of different packages differ;
+// with stdlib collapsing, standard library functions map to their package.
+func (g *callGraph) nodeID(fn *types.Func) string {
+	if g.prog.pkgOf(fn) == nil {
+		if g.opts.Stdlib == "collapse" && fn.Pkg() != nil && g.stdlib(fn) {
+			return fn.Pkg().Path()
+		}
+		return g.prog.funcName(fn)
+	}
+	return fn.Pkg().Path() + "." + g.prog.funcName(fn)
+}
+
+// stdlib reports whether fn, declared outside the module, belongs to the
+// standard library or, like error.Error, to the language.
+func (g *callGraph) stdlib(fn *types.Func) bool {
+	return fn.Pkg() == nil || isStdlib(fn.Pkg().Path())
+}
+
+func (g *callGraph) addNode(fn *types.Func) string {
+	fn = fn.Origin()
+	external := g.prog.pkgOf(fn) == nil
+	if external && g.opts.Stdlib == "exclude" && g.stdlib(fn) {
+		return ""
+	}
+	id := g.nodeID(fn)
+	if _, ok := g.nodes[id]; ok {
+		return id
+	}
+
+	node := &GraphNode{ID: id, Name: g.prog.funcName(fn), External: external}
+	if fn.Pkg() != nil {
+		node.Package = fn.Pkg().Path()
+	}
+	if external && g.opts.Stdlib == "collapse" && fn.Pkg() != nil && g.stdlib(fn) {
+		node.Name = node.Package
+	}
+	if !external {
+		pos := g.prog.position(fn.Pos())
+		node.File = pos.Filename
+		node.Line = pos.Line
+	}
+	g.nodes[id] = node
+	return id
+}
+
+func (g *callGraph) addEdge(from, to, dispatch string) {
+	key := [2]string{from, to}
+	if e, ok := g.edges[key]; ok {
+		e.Count++
+		return
+	}
+	g.edges[key] = &GraphEdge{From: from, To: to, Dispatch: dispatch, Count: 1}
+}
+
+// focus keeps only the nodes that reach or are reached from id.
+func (g *callGraph) focus(id string) {
+	out := make(map[string][]string)
+	in := make(map[string][]string)
+	for key := range g.edges {
+		out[key[0]] = append(out[key[0]], key[1])
+		in[key[1]] = append(in[key[1]], key[0])
+	}
+
+	keep := map[string]bool{id: true}
+	for _, adj := range []map[string][]string{out, in} {
+		queue := []string{id}
+		seen := map[string]bool{id: true}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, next := range adj[cur] {
+				if !seen[next] {
+					seen[next] = true
+					keep[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+
+	for key := range g.nodes {
+		if !keep[key] {
+			delete(g.nodes, key)
+		}
+	}
+	for key := range g.edges {
+		if !keep[key[0]] || !keep[key[1]] {
+			delete(g.edges, key)
+		}
+	}
+}
+
+// methodImplementers maps every interface method used in the module to the
+// concrete module methods that may be called through it.
+func (p *program) methodImplementers() map[*types.Func][]*types.Func {
+	result := make(map[*types.Func][]*types.Func)
+	var ifaceMethods []*types.Func
+	seen := make(map[*types.Func]bool)
+	p.callSites(func(cs callSite) {
+		if cs.dispatch == DispatchInterface && !seen[cs.callee] {
+			seen[cs.callee] = true
+			ifaceMethods = append(ifaceMethods, cs.callee)
+		}
+	})
+
+	named := p.namedTypes()
+	for _, m := range ifaceMethods {
+		for _, tn := range named {
+			if types.IsInterface(tn.Type()) {
+				continue
+			}
+			cs := callSite{callee: m, dispatch: DispatchInterface}
+			for _, t := range []types.Type{tn.Type(), types.NewPointer(tn.Type())} {
+				obj, _, _ := types.LookupFieldOrMethod(t, false, m.Pkg(), m.Name())
+				impl, ok := obj.(*types.Func)
+				if ok && cs.calls(impl) {
+					result[m] = append(result[m], impl)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+func recvBase(fn *types.Func) types.Type {
+	sig, _ := fn.Type().(*types.Signature)
+	if sig == nil || sig.Recv() == nil {
+		return nil
+	}
+	t := sig.Recv().Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	return t
+}
+
+// reachableAllocations returns the named types instantiated (composite
+// literals, new, conversions) in functions reachable from main, init, tests
+// and exported functions, following interface calls to implementers. Used
+// by RTA to prune interface call targets.
+func (p *program) reachableAllocations(implementers map[*types.Func][]*types.Func) map[types.Type]bool {
+	allocated := make(map[types.Type]bool)
+	reached := make(map[*types.Func]bool)
+	var queue []*types.Func
+
+	p.funcDecls(func(pkg *loadedPackage, file *ast.File, fn *ast.FuncDecl) {
+		obj, ok := pkg.Info.Defs[fn.Name].(*types.Func)
+		if !ok {
+			return
+		}
+		name := fn.Name.Name
+		isTest := strings.HasSuffix(p.position(file.Pos()).Filename, "_test.go")
+		if name == "main" || name == "init" || (isTest && strings.HasPrefix(name, "Test")) || (fn.Recv == nil && ast.IsExported(name)) {
+			reached[obj] = true
+			queue = append(queue, obj)
+		}
+	})
+
+	addType := func(t types.Type) {
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if named, ok := t.(*types.Named); ok {
+			allocated[named.Origin()] = true
+		}
+	}
+
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		pkg, decl := p.declOf(fn)
+		if decl == nil || decl.Body == nil {
+			continue
+		}
+		ast.Inspect(decl.Body, func(n ast.Node) bool {
+			switch e := n.(type) {
+			case *ast.CompositeLit:
+				addType(pkg.Info.TypeOf(e))
+			case *ast.CallExpr:
+				if tv, ok := pkg.Info.Types[unparen(e.Fun)]; ok && tv.IsType() {
+					addType(tv.Type)
+					return true
+				}
+				if id, ok := unparen(e.Fun).(*ast.Ident); ok && id.Name == "new" && len(e.Args) == 1 {
+					addType(pkg.Info.TypeOf(e.Args[0]))
+				}
+				callee, dispatch, ok := resolveCall(pkg.Info, e)
+				if !ok || callee == nil {
+					return true
+				}
+				targets := []*types.Func{callee}
+				if dispatch == DispatchInterface {
+					targets = implementers[callee]
+				}
+				for _, t := range targets {
+					if !reached[t.Origin()] {
+						reached[t.Origin()] = true
+						queue = append(queue, t.Origin())
+					}
+				}
+			case *ast.Ident:
+				// Functions used as values may be called from anywhere.
+				if f, ok := pkg.Info.Uses[e].(*types.Func); ok && !reached[f.Origin()] {
+					reached[f.Origin()] = true
+					queue = append(queue, f.Origin())
+				}
+			}
+			return true
+		})
+	}
+	return allocated
+}
+
+func renderDot(nodes []GraphNode, edges []GraphEdge) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph callgraph {\n")
+	buf.WriteString("\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range nodes {
+		attrs := fmt.Sprintf("label=%q", n.Name)
+		if n.External {
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(&buf, "\t%q [%s];\n", n.ID, attrs)
+	}
+	for _, e := range edges {
+		attrs := ""
+		if e.Dispatch == DispatchInterface {
+			attrs = " [style=dashed]"
+		}
+		fmt.Fprintf(&buf, "\t%q -> %q%s;\n", e.From, e.To, attrs)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func renderMermaid(nodes []GraphNode, edges []GraphEdge) string {
+	ids := make(map[string]string)
+	var buf bytes.Buffer
+	buf.WriteString("graph LR\n")
+	for i, n := range nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&buf, "\t%s[\"%s\"]\n", ids[n.ID], strings.ReplaceAll(n.Name, `"`, "#quot;"))
+	}
+	for _, e := range edges {
+		arrow := "-->"
+		if e.Dispatch == DispatchInterface {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&buf, "\t%s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+	return buf.String()
+}
@@ -0,0 +1,338 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// Dispatch kinds of a call expression.
+const (
+	DispatchStatic    = "static"
+	DispatchInterface = "interface"
+	DispatchDynamic   = "dynamic"
+)
+
+type CallNode struct {
+	Caller   string     `json:"caller"`
+	Callee   string     `json:"callee"`
+	Package  string     `json:"package,omitempty"`
+	Dispatch string     `json:"dispatch"`
+	File     string     `json:"file"`
+	Line     int        `json:"line"`
+	Column   int        `json:"column"`
+	Args     []string   `json:"args,omitempty"`
+	Calls    []CallNode `json:"calls,omitempty"`
+}
+
+type CallHierarchyResult struct {
+	Success   bool       `json:"success"`
+	Symbol    string     `json:"symbol"`
+	Direction string     `json:"direction"`
+	Depth     int        `json:"depth"`
+	Calls     []CallNode `json:"calls"`
+	Count     int        `json:"count"`
+}
+
+// callSite is a resolved call expression.
+type callSite struct {
+	pkg      *loadedPackage
+	file     *ast.File
+	call     *ast.CallExpr
+	caller   *ast.FuncDecl
+	callee   *types.Func
+	dispatch string
+}
+
+// resolveCall classifies a call expression. Conversions and builtins return
+// ok=false. For func-value calls callee is nil.
+func resolveCall(info *types.Info, call *ast.CallExpr) (callee *types.Func, dispatch string, ok bool) {
+	fun := unparen(call.Fun)
+	if tv, found := info.Types[fun]; found && tv.IsType() {
+		return nil, "", false
+	}
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = unparen(f.X)
+	case *ast.IndexListExpr:
+		fun = unparen(f.X)
+	}
+
+	switch f := fun.(type) {
+	case *ast.Ident:
+		switch obj := info.Uses[f].(type) {
+		case *types.Builtin:
+			return nil, "", false
+		case *types.Func:
+			return obj, DispatchStatic, true
+		}
+	case *ast.SelectorExpr:
+		if sel, found := info.Selections[f]; found {
+			fn, isFunc := sel.Obj().(*types.Func)
+			if !isFunc || sel.Kind() == types.FieldVal {
+				return nil, DispatchDynamic, true
+			}
+			if types.IsInterface(sel.Recv()) && sel.Kind() == types.MethodVal {
+				return fn, DispatchInterface, true
+			}
+			return fn, DispatchStatic, true
+		}
+		if fn, isFunc := info.Uses[f.Sel].(*types.Func); isFunc {
+			return fn, DispatchStatic, true
+		}
+		if _, isBuiltin := info.Uses[f.Sel].(*types.Builtin); isBuiltin {
+			return nil, "", false
+		}
+	}
+	return nil, DispatchDynamic, true
+}
+
+// callSites visits every call expression in the program.
+func (p *program) callSites(visit func(cs callSite)) {
+	for _, pkg := range p.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				callee, dispatch, ok := resolveCall(pkg.Info, call)
+				if !ok {
+					return true
+				}
+				visit(callSite{
+					pkg:      pkg,
+					file:     f,
+					call:     call,
+					caller:   enclosingFunc(f, call.Pos()),
+					callee:   callee,
+					dispatch: dispatch,
+				})
+				return true
+			})
+		}
+	}
+}
+
+// calledIdents returns the names functions are called by (see calleeIdent),
+// to tell calls from uses of a function as a value.
+func (p *program) calledIdents() map[*ast.Ident]bool {
+	called := make(map[*ast.Ident]bool)
+	p.callSites(func(cs callSite) {
+		if id := calleeIdent(cs.call); id != nil {
+			called[id] = true
+		}
+	})
+	return called
+}
+
+// calleeIdent returns the name a call refers to its function by: F in
+// F(x), pkg.F(x), x.F(x) and F[T](x).
+func calleeIdent(call *ast.CallExpr) *ast.Ident {
+	fun := unparen(call.Fun)
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = unparen(f.X)
+	case *ast.IndexListExpr:
+		fun = unparen(f.X)
+	}
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f
+	case *ast.SelectorExpr:
+		return f.Sel
+	}
+	return nil
+}
+
+// calls reports whether cs may invoke target. Interface calls match every
+// concrete method whose receiver type implements the interface.
+func (cs callSite) calls(target *types.Func) bool {
+	if cs.callee == nil {
+		return false
+	}
+	if cs.callee.Origin() == target.Origin() {
+		return true
+	}
+	if cs.dispatch != DispatchInterface || cs.callee.Name() != target.Name() {
+		return false
+	}
+	sig, _ := target.Type().(*types.Signature)
+	if sig == nil || sig.Recv() == nil {
+		return false
+	}
+	iface, _ := cs.callee.Type().(*types.Signature).Recv().Type().Underlying().(*types.Interface)
+	if iface == nil {
+		return false
+	}
+	recv := sig.Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	return types.Implements(recv, iface) || types.Implements(types.NewPointer(recv), iface)
+}
+
+// callPos returns the position of the called name, so that a call whose
+// receiver spans several lines is reported where the method is named.
+func callPos(call *ast.CallExpr) token.Pos {
+	fun := unparen(call.Fun)
+	for {
+		switch e := fun.(type) {
+		case *ast.IndexExpr:
+			fun = unparen(e.X)
+			continue
+		case *ast.IndexListExpr:
+			fun = unparen(e.X)
+			continue
+		case *ast.SelectorExpr:
+			return e.Sel.Pos()
+		}
+		return call.Pos()
+	}
+}
+
+func (p *program) callNode(cs callSite) CallNode {
+	pos := p.position(callPos(cs.call))
+	node := CallNode{
+		Dispatch: cs.dispatch,
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+	}
+	if cs.caller != nil {
+		node.Caller = funcDeclName(cs.caller)
+	} else {
+		node.Caller = "init"
+	}
+	if cs.callee != nil {
+		node.Callee = p.funcName(cs.callee)
+		if p.pkgOf(cs.callee) == nil && cs.callee.Pkg() != nil {
+			node.Package = cs.callee.Pkg().Path()
+		}
+	} else {
+		node.Callee = formatNode(p.Fset, cs.call.Fun)
+	}
+	for _, arg := range cs.call.Args {
+		node.Args = append(node.Args, formatNode(p.Fset, arg))
+	}
+	return node
+}
+
+func (p *program) callersOf(target *types.Func, depth int, visiting map[*types.Func]bool) []CallNode {
+	if depth <= 0 || visiting[target] {
+		return nil
+	}
+	visiting[target] = true
+	defer delete(visiting, target)
+
+	var nodes []CallNode
+	p.callSites(func(cs callSite) {
+		if !cs.calls(target) {
+			return
+		}
+		node := p.callNode(cs)
+		if cs.caller != nil {
+			if caller, ok := cs.pkg.Info.Defs[cs.caller.Name].(*types.Func); ok {
+				node.Calls = p.callersOf(caller, depth-1, visiting)
+			}
+		}
+		nodes = append(nodes, node)
+	})
+	return nodes
+}
+
+func (p *program) calleesOf(target *types.Func, depth int, visiting map[*types.Func]bool) []CallNode {
+	if depth <= 0 || visiting[target] {
+		return nil
+	}
+	pkg, decl := p.declOf(target)
+	if decl == nil || decl.Body == nil {
+		return nil
+	}
+	visiting[target] = true
+	defer delete(visiting, target)
+
+	var nodes []CallNode
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		callee, dispatch, ok := resolveCall(pkg.Info, call)
+		if !ok {
+			return true
+		}
+		cs := callSite{pkg: pkg, call: call, caller: decl, callee: callee, dispatch: dispatch}
+		node := p.callNode(cs)
+		if callee != nil && dispatch == DispatchStatic {
+			node.Calls = p.calleesOf(callee, depth-1, visiting)
+		}
+		nodes = append(nodes, node)
+		return true
+	})
+	return nodes
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
+
+func countCalls(nodes []CallNode) int {
+	n := len(nodes)
+	for _, node := range nodes {
+		n += countCalls(node.Calls)
+	}
+	return n
+}
+
+// Callers returns the call expressions that invoke funcName. With depth > 1
+// the callers of each caller are nested, forming a call tree.
+func Callers(funcName string, depth int) (*CallHierarchyResult, error) {
+	return callHierarchy(funcName, depth, "callers")
+}
+
+// Callees returns the calls made from the body of funcName. With depth > 1
+// the callees of each module function are nested.
+func Callees(funcName string, depth int) (*CallHierarchyResult, error) {
+	return callHierarchy(funcName, depth, "callees")
+}
+
+func callHierarchy(funcName string, depth int, direction string) (*CallHierarchyResult, error) {
+	if depth < 1 {
+		depth = 1
+	}
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	_, decl, fn := prog.lookupFunc(funcName)
+	if fn == nil {
+		return nil, fmt.Errorf("function %s not found", funcName)
+	}
+
+	var calls []CallNode
+	if direction == "callers" {
+		calls = prog.callersOf(fn, depth, make(map[*types.Func]bool))
+	} else {
+		calls = prog.calleesOf(fn, depth, make(map[*types.Func]bool))
+	}
+
+	return &CallHierarchyResult{
+		Success:   true,
+		Symbol:    funcDeclName(decl),
+		Direction: direction,
+		Depth:     depth,
+		Calls:     calls,
+		Count:     countCalls(calls),
+	}, nil
+}
@@ -0,0 +1,234 @@
+package refactor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func chdir(t *testing.T, dir string) {
+	oldWd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(oldWd) })
+}
+
+const callsModule = `package app
+
+type Store interface {
+	Save(id int) error
+}
+
+type DB struct{}
+
+func (d *DB) Save(id int) error {
+	return nil
+}
+
+func Handle(s Store, id int) error {
+	return s.Save(id)
+}
+
+func Direct(d *DB) error {
+	f := d.Save
+	_ = f
+	return d.Save(42)
+}
+
+func Run(fn func()) {
+	fn()
+	Direct(&DB{})
+}
+
+func Main() {
+	Run(func() {})
+	Handle(&DB{}, 1)
+}
+`
+
+func TestCallers(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":   "module example.com/app\n\ngo 1.21\n",
+		"app.go":   callsModule,
+		"chain.go": "package app\n\nfunc Chain(d *DB) error {\n\treturn d.\n\t\tSave(7)\n}\n",
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Callers("DB.Save", 1)
+	if err != nil {
+		t.Fatalf("Callers error: %v", err)
+	}
+	if result.Count != 3 {
+		t.Fatalf("expected 3 call sites (method value excluded), got %d: %+v", result.Count, result.Calls)
+	}
+
+	dispatch := map[string]string{}
+	for _, c := range result.Calls {
+		dispatch[c.Caller] = c.Dispatch
+	}
+	if dispatch["Handle"] != refactor.DispatchInterface {
+		t.Errorf("Handle should call via interface, got %q", dispatch["Handle"])
+	}
+	if dispatch["Direct"] != refactor.DispatchStatic {
+		t.Errorf("Direct should call statically, got %q", dispatch["Direct"])
+	}
+
+	for _, c := range result.Calls {
+		if c.Caller == "Direct" && (len(c.Args) != 1 || c.Args[0] != "42") {
+			t.Errorf("expected args [42], got %v", c.Args)
+		}
+		if c.Caller == "Chain" && (c.Line != 5 || c.Column != 3) {
+			t.Errorf("expected the call at Save, 5:3, got %d:%d", c.Line, c.Column)
+		}
+	}
+}
+
+func TestCallersDepth(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": callsModule,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Callers("Direct", 3)
+	if err != nil {
+		t.Fatalf("Callers error: %v", err)
+	}
+	if len(result.Calls) != 1 || result.Calls[0].Caller != "Run" {
+		t.Fatalf("expected Run to call Direct, got %+v", result.Calls)
+	}
+	if len(result.Calls[0].Calls) != 1 || result.Calls[0].Calls[0].Caller != "Main" {
+		t.Errorf("expected Main to call Run, got %+v", result.Calls[0].Calls)
+	}
+}
+
+func TestCallees(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": callsModule,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Callees("Run", 2)
+	if err != nil {
+		t.Fatalf("Callees error: %v", err)
+	}
+	if len(result.Calls) != 2 {
+		t.Fatalf("expected 2 calls, got %+v", result.Calls)
+	}
+	if result.Calls[0].Dispatch != refactor.DispatchDynamic || result.Calls[0].Callee != "fn" {
+		t.Errorf("expected dynamic call of fn, got %+v", result.Calls[0])
+	}
+	direct := result.Calls[1]
+	if direct.Callee != "Direct" || len(direct.Calls) != 1 || direct.Calls[0].Callee != "*DB.Save" {
+		t.Errorf("expected Direct -> *DB.Save, got %+v", direct)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": callsModule,
+		"mem.go": `package app
+
+import "strings"
+
+// Mem implements Store but is never created.
+type Mem struct{}
+
+func (m *Mem) Save(id int) error { return nil }
+
+func Upper(s string) string { return strings.ToUpper(s) }
+`,
+	})
+	chdir(t, dir)
+
+	hasEdge := func(r *refactor.CallGraphResult, from, to string) bool {
+		for _, e := range r.Edges {
+			if e.From == from && e.To == to {
+				return true
+			}
+		}
+		return false
+	}
+
+	static, err := refactor.CallGraph("", &refactor.CallGraphOptions{Algo: "static"})
+	if err != nil {
+		t.Fatalf("CallGraph error: %v", err)
+	}
+	if !hasEdge(static, "example.com/app.Main", "example.com/app.Run") {
+		t.Errorf("expected Main -> Run edge, got %+v", static.Edges)
+	}
+	if hasEdge(static, "example.com/app.Handle", "example.com/app.*DB.Save") {
+		t.Error("static graph should not resolve interface calls")
+	}
+
+	cha, err := refactor.CallGraph("", &refactor.CallGraphOptions{Algo: "cha"})
+	if err != nil {
+		t.Fatalf("CallGraph error: %v", err)
+	}
+	if !hasEdge(cha, "example.com/app.Handle", "example.com/app.*DB.Save") {
+		t.Errorf("CHA should resolve Store.Save to *DB.Save, got %+v", cha.Edges)
+	}
+
+	if !hasEdge(cha, "example.com/app.Handle", "example.com/app.*Mem.Save") {
+		t.Errorf("CHA should resolve Store.Save to *Mem.Save, got %+v", cha.Edges)
+	}
+
+	rta, err := refactor.CallGraph("", &refactor.CallGraphOptions{Algo: "rta"})
+	if err != nil {
+		t.Fatalf("CallGraph error: %v", err)
+	}
+	if !hasEdge(rta, "example.com/app.Handle", "example.com/app.*DB.Save") || hasEdge(rta, "example.com/app.Handle", "example.com/app.*Mem.Save") {
+		t.Errorf("RTA should keep *DB.Save and drop the never created *Mem, got %+v", rta.Edges)
+	}
+
+	for mode, to := range map[string]string{"include": "strings.ToUpper", "collapse": "strings", "exclude": ""} {
+		r, err := refactor.CallGraph("", &refactor.CallGraphOptions{Stdlib: mode})
+		if err != nil {
+			t.Fatalf("CallGraph --stdlib %s error: %v", mode, err)
+		}
+		var callees []string
+		for _, e := range r.Edges {
+			if e.From == "example.com/app.Upper" {
+				callees = append(callees, e.To)
+			}
+		}
+		if strings.Join(callees, ",") != to {
+			t.Errorf("--stdlib %s: expected Upper to call %q, got %v", mode, to, callees)
+		}
+	}
+	if _, err := refactor.CallGraph("", &refactor.CallGraphOptions{Stdlib: "none"}); err == nil {
+		t.Error("expected an unknown stdlib mode to be refused")
+	}
+
+	mermaid, err := refactor.CallGraph("", &refactor.CallGraphOptions{Format: "mermaid", Algo: "cha"})
+	if err != nil {
+		t.Fatalf("CallGraph error: %v", err)
+	}
+	if !strings.HasPrefix(mermaid.Graph, "graph LR\n") || !strings.Contains(mermaid.Graph, `["*DB.Save"]`) || !strings.Contains(mermaid.Graph, " -.-> ") {
+		t.Errorf("unexpected mermaid graph:\n%s", mermaid.Graph)
+	}
+
+	dot, err := refactor.CallGraph("", &refactor.CallGraphOptions{Format: "dot", Focus: "Run"})
+	if err != nil {
+		t.Fatalf("CallGraph error: %v", err)
+	}
+	if !strings.HasPrefix(dot.Graph, "digraph") || strings.Contains(dot.Graph, "Handle") {
+		t.Errorf("unexpected focused dot graph:\n%s", dot.Graph)
+	}
+}
@@ -0,0 +1,155 @@
+package refactor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BuildOptions selects the build configuration: the files of other
+// platforms or tags are left out of analysis like the go command leaves
+// them out of a build.
+type BuildOptions struct {
+	Tags   []string
+	GOOS   string
+	GOARCH string
+}
+
+// SetBuildOptions configures buildContext and the environment of the go
+// and gopls commands run on behalf of commands.
+func SetBuildOptions(opts *BuildOptions) {
+	if opts.GOOS != "" {
+		buildContext.GOOS = opts.GOOS
+		os.Setenv("GOOS", opts.GOOS)
+	}
+	if opts.GOARCH != "" {
+		buildContext.GOARCH = opts.GOARCH
+		os.Setenv("GOARCH", opts.GOARCH)
+	}
+	// Like the go command, cgo is off by default when cross-compiling.
+	if buildContext.GOOS != runtime.GOOS || buildContext.GOARCH != runtime.GOARCH {
+		buildContext.CgoEnabled = os.Getenv("CGO_ENABLED") == "1"
+	}
+	if len(opts.Tags) > 0 {
+		buildContext.BuildTags = append(buildContext.BuildTags, opts.Tags...)
+		flags := strings.TrimSpace(os.Getenv("GOFLAGS") + " -tags=" + strings.Join(opts.Tags, ","))
+		os.Setenv("GOFLAGS", flags)
+	}
+}
+
+// Operating systems and architectures a file name suffix can select, as
+// listed by go/build.
+var (
+	knownOS   = "aix android darwin dragonfly freebsd hurd illumos ios js linux nacl netbsd openbsd plan9 solaris wasip1 windows zos"
+	knownArch = "386 amd64 amd64p32 arm armbe arm64 arm64be loong64 mips mipsle mips64 mips64le mips64p32 mips64p32le ppc ppc64 ppc64le riscv riscv64 s390 s390x sparc sparc64 wasm"
+)
+
+// fileConstraint returns the build constraint of a Go file: its //go:build
+// line and the GOOS/GOARCH of its name, joined into one expression such as
+// "linux && amd64". It is empty for files every build includes. With src
+// nil the file is read.
+func fileConstraint(path string, src []byte) string {
+	parts := nameConstraint(filepath.Base(path))
+	if expr := goBuildLine(path, src); expr != nil {
+		s := expr.String()
+		if _, ok := expr.(*constraint.OrExpr); ok && len(parts) > 0 {
+			s = "(" + s + ")"
+		}
+		parts = append([]string{s}, parts...)
+	}
+	return strings.Join(parts, " && ")
+}
+
+// goBuildLine parses the //go:build line from the header of a file, the
+// part before the package clause.
+func goBuildLine(path string, src []byte) constraint.Expr {
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(path); err != nil {
+			return nil
+		}
+	}
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if constraint.IsGoBuild(line) {
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil
+			}
+			return expr
+		}
+	}
+	return nil
+}
+
+// nameConstraint returns the GOOS and GOARCH a file name selects with
+// _GOOS, _GOARCH or _GOOS_GOARCH before .go or _test.go.
+func nameConstraint(name string) []string {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".go"), "_test")
+	elems := strings.Split(name, "_")
+	n := len(elems)
+	if n >= 3 && isKnown(knownOS, elems[n-2]) && isKnown(knownArch, elems[n-1]) {
+		return []string{elems[n-2], elems[n-1]}
+	}
+	if n >= 2 && (isKnown(knownOS, elems[n-1]) || isKnown(knownArch, elems[n-1])) {
+		return []string{elems[n-1]}
+	}
+	return nil
+}
+
+func isKnown(list, s string) bool {
+	for _, item := range strings.Fields(list) {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMatches reports whether the current build configuration includes
+// a file.
+func buildMatches(path string) bool {
+	ok, err := buildContext.MatchFile(filepath.Dir(path), filepath.Base(path))
+	return err == nil && ok
+}
+
+// excludedSiblings returns definitions of the symbol at loc in files of
+// the same directory the build configuration leaves out: per-platform or
+// tagged variants that probably need the same edit.
+func excludedSiblings(loc *SymbolLocation) []SymbolLocation {
+	var siblings []SymbolLocation
+	matches, err := scanSymbols(loc.Name, filepath.Dir(loc.File), "", false)
+	if err != nil {
+		return nil
+	}
+	for _, m := range matches {
+		sameName := strings.TrimPrefix(m.Name, "*") == strings.TrimPrefix(loc.Name, "*")
+		if sameName && filepath.Dir(m.File) == filepath.Dir(loc.File) && !buildMatches(m.File) {
+			siblings = append(siblings, m)
+		}
+	}
+	return siblings
+}
+
+// siblingWarnings describes the excluded siblings of the symbol a
+// modifying command is about to edit.
+func siblingWarnings(loc *SymbolLocation) []string {
+	var warnings []string
+	for _, s := range excludedSiblings(loc) {
+		c := s.Constraint
+		if c == "" {
+			c = "excluded from this build"
+		}
+		warnings = append(warnings, fmt.Sprintf("%s is also defined in %s:%d (%s) and may need the same change", s.Name, s.File, s.Line, c))
+	}
+	return warnings
+}
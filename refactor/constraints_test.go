@@ -0,0 +1,37 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestBuildConstraints(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":    "module example.com/app\n\ngo 1.21\n",
+		"plain.go":  "//go:build !custom\n\npackage app\n\nfunc Mode() string { return \"plain\" }\n",
+		"custom.go": "//go:build custom\n\npackage app\n\nfunc Mode() string { return \"custom\" }\n",
+		"use.go":    "package app\n\nfunc Use() string { return Mode() }\n",
+	})
+	chdir(t, dir)
+
+	found, err := refactor.FindFunc("Mode", ".")
+	if err != nil {
+		t.Fatalf("FindFunc error: %v", err)
+	}
+	if found.Count != 1 || !strings.HasSuffix(found.Matches[0].File, "plain.go") || found.Matches[0].Constraint != "!custom" {
+		t.Fatalf("expected only the !custom definition, got %+v", found.Matches)
+	}
+
+	result, err := refactor.Replace("Mode", "", strings.NewReader(`func Mode() string { return "default" }`))
+	if err != nil {
+		t.Fatalf("Replace error: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "custom.go:5 (custom)") {
+		t.Errorf("expected a warning about custom.go, got %v", result.Warnings)
+	}
+	if content := readModuleFile(t, dir, "custom.go"); !strings.Contains(content, `"custom"`) {
+		t.Errorf("custom.go should be untouched:\n%s", content)
+	}
+}
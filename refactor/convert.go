@@ -0,0 +1,555 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+type ConvertResult struct {
+	Success       bool     `json:"success"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Signature     string   `json:"signature"`
+	CallsUpdated  int      `json:"callsUpdated"`
+	ValuesUpdated int      `json:"valuesUpdated"` // method values and expressions, func values
+	FilesChanged  []string `json:"filesChanged"`
+	Message       string   `json:"message"`
+	Warnings      []string `json:"warnings,omitempty"`
+}
+
+// converter rewrites a function declaration and every use of it.
+type converter struct {
+	prog   *program
+	pkg    *loadedPackage
+	decl   *ast.FuncDecl
+	fn     *types.Func
+	called map[*ast.Ident]bool
+	edits  []textEdit
+	result *ConvertResult
+}
+
+func loadConverter(name string) (*converter, error) {
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	pkg, decl, fn := prog.lookupFunc(name)
+	if decl == nil || fn == nil {
+		return nil, fmt.Errorf("function %s not found", name)
+	}
+	if err := checkWritable(prog.position(decl.Pos()).Filename); err != nil {
+		return nil, err
+	}
+	return &converter{
+		prog:   prog,
+		pkg:    pkg,
+		decl:   decl,
+		fn:     fn,
+		called: prog.calledIdents(),
+		result: &ConvertResult{Success: true, From: funcDeclName(decl)},
+	}, nil
+}
+
+// source returns the source text of an expression.
+func (c *converter) source(e ast.Expr) string {
+	return c.prog.text(e.Pos(), e.End())
+}
+
+// funcIn returns how file f of pkg refers to the package-level function
+// name of the converted function's package, adding the import if needed.
+func (c *converter) funcIn(f *ast.File, pkg *loadedPackage, name string) string {
+	if pkg.Types == c.fn.Pkg() {
+		return name
+	}
+	path := c.fn.Pkg().Path()
+	if qual := importName(f, path); qual != "" {
+		return qual + "." + name
+	}
+	file := c.prog.position(f.Pos()).Filename
+	for _, e := range c.edits {
+		if e.File == file && strings.Contains(e.Text, `"`+path+`"`) {
+			return c.fn.Pkg().Name() + "." + name
+		}
+	}
+	c.edits = append(c.edits, c.prog.addImports(f, path)...)
+	return c.fn.Pkg().Name() + "." + name
+}
+
+// visibleAt checks that ref, how pkg refers to the function funcIn
+// returned, means it at pos: no local declaration or import in scope
+// there shadows its name or package qualifier.
+func (c *converter) visibleAt(pkg *loadedPackage, pos token.Pos, ref string) error {
+	name, _, qualified := strings.Cut(ref, ".")
+	_, obj := pkg.Types.Scope().Innermost(pos).LookupParent(name, pos)
+	if obj == nil {
+		return nil
+	}
+	if pn, ok := obj.(*types.PkgName); ok && qualified && pn.Imported() == c.fn.Pkg() {
+		return nil
+	}
+	return fmt.Errorf("%s: %s would refer to the %s %s declared at %s; rename it first",
+		c.prog.position(pos), ref, objectKind(obj), name, c.prog.position(obj.Pos()))
+}
+
+// header replaces the declaration's signature up to its body.
+func (c *converter) header(text string) {
+	c.edits = append(c.edits, c.prog.edit(c.decl.Pos(), c.decl.Type.End(), text))
+	c.result.Signature = text
+}
+
+// uses visits the uses of the converted function that are not calls, with
+// the expression naming it: an identifier, a qualified identifier or a
+// method selector.
+func (c *converter) uses(visit func(pkg *loadedPackage, f *ast.File, e ast.Expr, sel *types.Selection) error) error {
+	var err error
+	for _, pkg := range c.prog.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				if err != nil {
+					return false
+				}
+				switch n := n.(type) {
+				case *ast.SelectorExpr:
+					if obj, ok := pkg.Info.Uses[n.Sel].(*types.Func); ok && obj.Origin() == c.fn && !c.called[n.Sel] {
+						err = visit(pkg, f, n, pkg.Info.Selections[n])
+						return false
+					}
+				case *ast.Ident:
+					if obj, ok := pkg.Info.Uses[n].(*types.Func); ok && obj.Origin() == c.fn && !c.called[n] {
+						err = visit(pkg, f, n, nil)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return err
+}
+
+func (c *converter) apply() (*ConvertResult, error) {
+	files, err := applyEdits(c.edits)
+	if err != nil {
+		return nil, err
+	}
+	c.result.FilesChanged = files
+	c.result.Message = fmt.Sprintf("%s is now %s: %d call(s) and %d value(s) updated",
+		c.result.From, c.result.To, c.result.CallsUpdated, c.result.ValuesUpdated)
+	return c.result, nil
+}
+
+// ToMethod turns a function into a method on the type of its parameter
+// param, named like the function: ProcessOrder(s *UserService, id int)
+// becomes (s *UserService) ProcessOrder(id int) and the call
+// ProcessOrder(svc, 1) becomes svc.ProcessOrder(1). Uses as a value turn
+// into the method expression (*UserService).ProcessOrder, which is only
+// possible when param comes first. A call whose earlier arguments have
+// side effects is refused, since the receiver would be evaluated before
+// them. Imports left unused by the rewritten calls are removed.
+func ToMethod(funcName, param string) (*ConvertResult, error) {
+	c, err := loadConverter(funcName)
+	if err != nil {
+		return nil, err
+	}
+	decl, fn := c.decl, c.fn
+	if decl.Recv != nil {
+		return nil, fmt.Errorf("%s is already a method", funcName)
+	}
+	if decl.Type.TypeParams != nil {
+		return nil, fmt.Errorf("%s is generic; methods cannot have type parameters", funcName)
+	}
+
+	sig := fn.Type().(*types.Signature)
+	index := -1
+	for i := 0; i < sig.Params().Len(); i++ {
+		if sig.Params().At(i).Name() == param {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%s has no parameter %s", funcName, param)
+	}
+	if sig.Variadic() && index == sig.Params().Len()-1 {
+		return nil, fmt.Errorf("the variadic parameter %s cannot become the receiver", param)
+	}
+	recvType := sig.Params().At(index).Type()
+	base := recvType
+	if ptr, ok := base.(*types.Pointer); ok {
+		base = ptr.Elem()
+	}
+	named, ok := base.(*types.Named)
+	if !ok || named.Obj().Pkg() != fn.Pkg() {
+		return nil, fmt.Errorf("cannot declare methods on %s: not a type of package %s", recvType, fn.Pkg().Name())
+	}
+	switch named.Underlying().(type) {
+	case *types.Interface, *types.Pointer:
+		return nil, fmt.Errorf("cannot declare methods on %s: its underlying type is %s", named.Obj().Name(), named.Underlying())
+	}
+	if named.TypeParams().Len() > 0 {
+		return nil, fmt.Errorf("%s is generic; declare the method by hand", named.Obj().Name())
+	}
+	if obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(named), false, fn.Pkg(), fn.Name()); obj != nil {
+		return nil, fmt.Errorf("%s already has a field or method %s", named.Obj().Name(), fn.Name())
+	}
+
+	// The declaration: the parameter moves to the receiver, keeping its
+	// name so that the body stays valid.
+	var recvText string
+	rest := &ast.FieldList{}
+	for _, field := range decl.Type.Params.List {
+		kept := &ast.Field{Type: field.Type}
+		for _, name := range field.Names {
+			if name.Name == param {
+				recvText = param + " " + c.source(field.Type)
+			} else {
+				kept.Names = append(kept.Names, name)
+			}
+		}
+		if len(kept.Names) > 0 {
+			rest.List = append(rest.List, kept)
+		}
+	}
+	c.header("func (" + recvText + ") " + decl.Name.Name + "(" + strings.Join(formatFields(rest, c.source), ", ") + ")" +
+		formatResults(decl.Type.Results, c.source))
+	c.result.To = recvTypeName(recvType) + "." + fn.Name()
+
+	var callErr error
+	qualified := make(map[*ast.File]int) // pkg.Func calls rewritten per file of another package
+	pkgOf := make(map[*ast.File]*loadedPackage)
+	c.prog.callSites(func(cs callSite) {
+		if callErr != nil || cs.callee == nil || cs.callee.Origin() != fn {
+			return
+		}
+		args := cs.call.Args
+		if len(args) <= index {
+			callErr = fmt.Errorf("%s passes a multi-value call to %s; split it first", c.prog.position(cs.call.Pos()), funcName)
+			return
+		}
+		x := args[index]
+		if tv, ok := cs.pkg.Info.Types[x]; ok && tv.IsNil() {
+			callErr = fmt.Errorf("%s passes nil as %s; it cannot be a receiver", c.prog.position(x.Pos()), param)
+			return
+		}
+		// The receiver is evaluated before the arguments it used to follow.
+		for _, arg := range args[:index] {
+			if !pureExpr(cs.pkg.Info, arg) || !pureExpr(cs.pkg.Info, x) && readsMemory(cs.pkg.Info, arg) {
+				callErr = fmt.Errorf("%s: %s would be evaluated before %s; assign the arguments to variables first",
+					c.prog.position(cs.call.Pos()), c.source(x), c.source(arg))
+				return
+			}
+		}
+		if sel, ok := unparen(cs.call.Fun).(*ast.SelectorExpr); ok && cs.pkg.Types != fn.Pkg() {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				if _, ok := cs.pkg.Info.Uses[id].(*types.PkgName); ok {
+					qualified[cs.file]++
+					pkgOf[cs.file] = cs.pkg
+				}
+			}
+		}
+		// svc.M() takes the address of svc itself.
+		if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.AND && primaryExpr(u.X) {
+			if _, lit := u.X.(*ast.CompositeLit); !lit {
+				x = u.X
+			}
+		}
+		recv := c.source(x)
+		if !primaryExpr(x) {
+			recv = "(" + recv + ")"
+		}
+		c.edits = append(c.edits, c.prog.edit(cs.call.Fun.Pos(), cs.call.Fun.End(), recv+"."+fn.Name()))
+		switch {
+		case len(args) == 1:
+			c.edits = append(c.edits, c.prog.edit(args[index].Pos(), args[index].End(), ""))
+		case index == len(args)-1:
+			c.edits = append(c.edits, c.prog.edit(args[index-1].End(), args[index].End(), ""))
+		default:
+			c.edits = append(c.edits, c.prog.edit(args[index].Pos(), args[index+1].Pos(), ""))
+		}
+		c.result.CallsUpdated++
+	})
+	if callErr != nil {
+		return nil, callErr
+	}
+
+	err = c.uses(func(pkg *loadedPackage, f *ast.File, e ast.Expr, _ *types.Selection) error {
+		if index != 0 {
+			return fmt.Errorf("%s is used as a value at %s; as a method expression its parameters would be reordered", funcName, c.prog.position(e.Pos()))
+		}
+		expr := qualifiedType(f, pkg, recvType)
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		c.edits = append(c.edits, c.prog.edit(e.Pos(), e.End(), expr+"."+fn.Name()))
+		c.result.ValuesUpdated++
+		delete(qualified, f) // the method expression names the receiver type of the package
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for f, n := range qualified {
+		if n == packageUses(pkgOf[f].Info, f, fn.Pkg().Path()) {
+			c.edits = append(c.edits, c.prog.removeImport(f, fn.Pkg().Path())...)
+		}
+	}
+
+	// A new method can make the type satisfy an interface it did not,
+	// changing what fmt prints or which interface conversions succeed.
+	var restVars []*types.Var
+	for i := 0; i < sig.Params().Len(); i++ {
+		if i != index {
+			restVars = append(restVars, sig.Params().At(i))
+		}
+	}
+	method := types.NewSignatureType(nil, nil, nil, types.NewTuple(restVars...), sig.Results(), sig.Variadic())
+	for _, name := range c.prog.completedInterfaces(named, fn.Name(), method) {
+		c.result.Warnings = append(c.result.Warnings, fmt.Sprintf("%s now satisfies %s", named.Obj().Name(), name))
+	}
+	return c.apply()
+}
+
+// ToFunc turns a method into a function of the package named like the
+// method, taking the receiver as its first parameter: (s *UserService)
+// ProcessOrder(id int) becomes ProcessOrder(s *UserService, id int) and
+// svc.ProcessOrder(1) becomes ProcessOrder(svc, 1), with & or * added
+// where the call relied on automatic addressing. Method expressions become
+// the function, method values a closure. Methods that make their type
+// satisfy an interface are refused, as are names that are predeclared or
+// declared in scope at a use.
+func ToFunc(methodName string) (*ConvertResult, error) {
+	c, err := loadConverter(methodName)
+	if err != nil {
+		return nil, err
+	}
+	decl, fn := c.decl, c.fn
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return nil, fmt.Errorf("%s is not a method", methodName)
+	}
+	sig := fn.Type().(*types.Signature)
+	recvType := sig.Recv().Type()
+	base := recvType
+	if ptr, ok := base.(*types.Pointer); ok {
+		base = ptr.Elem()
+	}
+	if named, ok := base.(*types.Named); ok && named.TypeParams().Len() > 0 {
+		return nil, fmt.Errorf("the receiver of %s is generic; convert it by hand", methodName)
+	}
+	if obj := fn.Pkg().Scope().Lookup(fn.Name()); obj != nil {
+		return nil, fmt.Errorf("package %s already declares %s at %s", fn.Pkg().Name(), fn.Name(), c.prog.position(obj.Pos()))
+	}
+	if types.Universe.Lookup(fn.Name()) != nil {
+		return nil, fmt.Errorf("a function %s would shadow the predeclared %s", fn.Name(), fn.Name())
+	}
+	if ifaces := c.prog.satisfiedInterfaces(recvType, fn.Name()); len(ifaces) > 0 {
+		return nil, fmt.Errorf("%s implements %s; as a function it no longer would", methodName, strings.Join(ifaces, ", "))
+	}
+
+	// The declaration: the receiver becomes the first parameter. Names
+	// are all or nothing in a parameter list, so "_" fills in.
+	recvField := decl.Recv.List[0]
+	params := formatFields(decl.Type.Params, c.source)
+	recvNamed := len(recvField.Names) > 0
+	paramsNamed := len(decl.Type.Params.List) > 0 && len(decl.Type.Params.List[0].Names) > 0
+	recvText := c.source(recvField.Type)
+	switch {
+	case recvNamed:
+		recvText = recvField.Names[0].Name + " " + recvText
+		if !paramsNamed {
+			for i := range params {
+				params[i] = "_ " + params[i]
+			}
+		}
+	case paramsNamed:
+		recvText = "_ " + recvText
+	}
+	c.header("func " + decl.Name.Name + "(" + strings.Join(append([]string{recvText}, params...), ", ") + ")" +
+		formatResults(decl.Type.Results, c.source))
+	c.result.To = fn.Name()
+
+	_, recvPtr := recvType.(*types.Pointer)
+	// receiver returns the argument a call passes for x, whose type is
+	// xType, adding the & or * the method call applied implicitly.
+	receiver := func(x ast.Expr, xType types.Type) string {
+		text := c.source(unparen(x))
+		_, xPtr := xType.(*types.Pointer)
+		switch {
+		case recvPtr && !xPtr:
+			if !primaryExpr(x) {
+				text = "(" + text + ")"
+			}
+			return "&" + text
+		case !recvPtr && xPtr:
+			if !primaryExpr(x) {
+				text = "(" + text + ")"
+			}
+			return "*" + text
+		}
+		return text
+	}
+
+	var callErr error
+	c.prog.callSites(func(cs callSite) {
+		if callErr != nil || cs.callee == nil || cs.callee.Origin() != fn {
+			return
+		}
+		sel, ok := unparen(cs.call.Fun).(*ast.SelectorExpr)
+		selection := cs.pkg.Info.Selections[sel]
+		if !ok || selection == nil {
+			return
+		}
+		name := c.funcIn(cs.file, cs.pkg, fn.Name())
+		if err := c.visibleAt(cs.pkg, cs.call.Pos(), name); err != nil {
+			callErr = err
+			return
+		}
+		switch {
+		case cs.dispatch == DispatchInterface:
+			callErr = fmt.Errorf("%s calls %s through an interface", c.prog.position(cs.call.Pos()), methodName)
+		case len(selection.Index()) > 1:
+			callErr = fmt.Errorf("%s calls %s promoted through an embedded field; call it on the field first", c.prog.position(cs.call.Pos()), methodName)
+		case selection.Kind() == types.MethodExpr:
+			if !types.Identical(selection.Recv(), recvType) {
+				callErr = fmt.Errorf("%s uses the method expression with receiver %s", c.prog.position(sel.Pos()), selection.Recv())
+				return
+			}
+			c.edits = append(c.edits, c.prog.edit(cs.call.Fun.Pos(), cs.call.Fun.End(), name))
+			c.result.CallsUpdated++
+		default:
+			arg := receiver(sel.X, selection.Recv())
+			if len(cs.call.Args) > 0 {
+				arg += ", "
+			}
+			c.edits = append(c.edits, c.prog.edit(cs.call.Fun.Pos(), cs.call.Lparen+1, name+"("+arg))
+			c.result.CallsUpdated++
+		}
+	})
+	if callErr != nil {
+		return nil, callErr
+	}
+
+	err = c.uses(func(pkg *loadedPackage, f *ast.File, e ast.Expr, selection *types.Selection) error {
+		sel, ok := e.(*ast.SelectorExpr)
+		if !ok || selection == nil {
+			return fmt.Errorf("unexpected use of %s at %s", methodName, c.prog.position(e.Pos()))
+		}
+		if len(selection.Index()) > 1 {
+			return fmt.Errorf("%s uses %s promoted through an embedded field", c.prog.position(e.Pos()), methodName)
+		}
+		name := c.funcIn(f, pkg, fn.Name())
+		if err := c.visibleAt(pkg, e.Pos(), name); err != nil {
+			return err
+		}
+		if selection.Kind() == types.MethodExpr {
+			if !types.Identical(selection.Recv(), recvType) {
+				return fmt.Errorf("%s uses the method expression with receiver %s", c.prog.position(e.Pos()), selection.Recv())
+			}
+			c.edits = append(c.edits, c.prog.edit(e.Pos(), e.End(), name))
+			c.result.ValuesUpdated++
+			return nil
+		}
+		if _, isIface := selection.Recv().Underlying().(*types.Interface); isIface {
+			return fmt.Errorf("%s takes %s from an interface", c.prog.position(e.Pos()), methodName)
+		}
+
+		// A method value binds its receiver; a closure over the same
+		// expression evaluates it on each call instead. Its parameters
+		// must not hide the receiver or the function.
+		fnHead, _, _ := strings.Cut(name, ".")
+		var params, args []string
+		for i := 0; i < sig.Params().Len(); i++ {
+			p := sig.Params().At(i)
+			name := p.Name()
+			if name == "" || name == "_" || name == fnHead || mentions(sel.X, name) {
+				name = fmt.Sprintf("a%d", i)
+			}
+			typ := qualifiedType(f, pkg, p.Type())
+			if sig.Variadic() && i == sig.Params().Len()-1 {
+				typ = "..." + qualifiedType(f, pkg, p.Type().(*types.Slice).Elem())
+				name += "..."
+				params = append(params, strings.TrimSuffix(name, "...")+" "+typ)
+			} else {
+				params = append(params, name+" "+typ)
+			}
+			args = append(args, name)
+		}
+		var results []string
+		for i := 0; i < sig.Results().Len(); i++ {
+			results = append(results, qualifiedType(f, pkg, sig.Results().At(i).Type()))
+		}
+		body := name + "(" + strings.Join(append([]string{receiver(sel.X, selection.Recv())}, args...), ", ") + ")"
+		closure := "func(" + strings.Join(params, ", ") + ")"
+		switch len(results) {
+		case 0:
+			closure += " { " + body + " }"
+		case 1:
+			closure += " " + results[0] + " { return " + body + " }"
+		default:
+			closure += " (" + strings.Join(results, ", ") + ") { return " + body + " }"
+		}
+		c.edits = append(c.edits, c.prog.edit(e.Pos(), e.End(), closure))
+		c.result.ValuesUpdated++
+		c.result.Warnings = append(c.result.Warnings, fmt.Sprintf("%s: the method value became a closure; %s is now evaluated on each call",
+			c.prog.position(e.Pos()), c.source(sel.X)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.apply()
+}
+
+// mentions reports whether e contains the identifier name.
+func mentions(e ast.Expr, name string) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// completedInterfaces names the interfaces that a method name with
+// signature sig would make T or *T implement, T being named.
+func (p *program) completedInterfaces(named *types.Named, name string, sig *types.Signature) []string {
+	var names []string
+	for _, tn := range p.candidateInterfaces() {
+		iface := tn.Type().Underlying().(*types.Interface)
+		complete := false
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			if m.Name() == name {
+				if !types.Identical(m.Type(), sig) {
+					complete = false
+					break
+				}
+				complete = true
+				continue
+			}
+			if obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(named), false, m.Pkg(), m.Name()); obj == nil || !types.Identical(obj.Type(), m.Type()) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			names = append(names, tn.Pkg().Name()+"."+tn.Name())
+		}
+	}
+	return names
+}
+
+// primaryExpr reports whether e can take a selector or an & without
+// parentheses.
+func primaryExpr(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.ParenExpr, *ast.CompositeLit, *ast.BasicLit:
+		return true
+	}
+	return false
+}
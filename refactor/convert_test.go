@@ -0,0 +1,122 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestToMethodAndBack(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"svc.go": `package app
+
+import "fmt"
+
+type UserService struct{ n int }
+
+func ProcessOrder(s *UserService, id int) int { return s.n + id }
+
+func (s *UserService) String() string { return fmt.Sprint(s.n) }
+
+func Use() int {
+	var svc UserService
+	f := ProcessOrder
+	return ProcessOrder(&svc, 1) + f(&svc, 2)
+}
+`,
+		"cache.go": `package app
+
+type Queue struct{ items []int }
+
+func (q *Queue) len() int { return len(q.items) }
+
+type Cache struct{ m map[string]int }
+
+func (c *Cache) get(k string) int { return c.m[k] }
+
+func Lookup(c *Cache, q *Queue) int {
+	get := 1
+	return c.get("x") + get + q.len()
+}
+`,
+		"cv/cv.go": `package cv
+
+type S struct{ n int }
+
+func Process(s *S, n int) int { return s.n + n }
+
+func Scale(n int, s *S) int { return s.n * n }
+`,
+		"use/c.go": `package use
+
+import "example.com/app/cv"
+
+type C struct{ S *cv.S }
+
+func next() int { return 1 }
+
+func Both(c C) int {
+	return cv.Scale(next(), c.S)
+}
+`,
+		"use/run.go": `package use
+
+import "example.com/app/cv"
+
+func Run(c C) int {
+	return cv.Process(c.S, 1)
+}
+`,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.ToMethod("ProcessOrder", "s")
+	if err != nil {
+		t.Fatalf("ToMethod error: %v", err)
+	}
+	if result.CallsUpdated != 1 || result.ValuesUpdated != 1 {
+		t.Errorf("expected 1 call and 1 value updated, got %+v", result)
+	}
+	content := readModuleFile(t, dir, "svc.go")
+	for _, s := range []string{"func (s *UserService) ProcessOrder(id int) int", "svc.ProcessOrder(1)", "f := (*UserService).ProcessOrder"} {
+		if !strings.Contains(content, s) {
+			t.Errorf("expected %s:\n%s", s, content)
+		}
+	}
+
+	if _, err := refactor.ToFunc("UserService.ProcessOrder"); err != nil {
+		t.Fatalf("ToFunc error: %v", err)
+	}
+	content = readModuleFile(t, dir, "svc.go")
+	for _, s := range []string{"func ProcessOrder(s *UserService, id int) int", "ProcessOrder(&svc, 1)", "f := ProcessOrder"} {
+		if !strings.Contains(content, s) {
+			t.Errorf("expected %s:\n%s", s, content)
+		}
+	}
+
+	if _, err := refactor.ToFunc("UserService.String"); err == nil || !strings.Contains(err.Error(), "fmt.Stringer") {
+		t.Errorf("expected String to be refused for fmt.Stringer, got %v", err)
+	}
+
+	if _, err := refactor.ToFunc("Queue.len"); err == nil {
+		t.Error("expected a function shadowing the builtin len to be refused")
+	}
+	// get(c, "x") would call the local variable get.
+	if _, err := refactor.ToFunc("Cache.get"); err == nil {
+		t.Error("expected a name shadowed at a call site to be refused")
+	}
+
+	// next() would run after c.S is evaluated as the receiver.
+	if _, err := refactor.ToMethod("Scale", "s"); err == nil {
+		t.Error("expected a receiver moving before a call argument to be refused")
+	}
+	if _, err := refactor.ToMethod("Process", "s"); err != nil {
+		t.Fatalf("ToMethod error: %v", err)
+	}
+	content = readModuleFile(t, dir, "use/run.go")
+	if !strings.Contains(content, "c.S.Process(1)") || strings.Contains(content, "import") {
+		t.Errorf("expected the call rewritten and the unused import removed:\n%s", content)
+	}
+}
@@ -0,0 +1,268 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type FuncCoverage struct {
+	SymbolLocation
+	Statements int      `json:"statements"`
+	Covered    int      `json:"covered"`
+	Percent    float64  `json:"percent"`
+	Uncovered  []string `json:"uncovered,omitempty"` // file:N:M ranges for lines
+	Tests      []string `json:"tests,omitempty"`
+}
+
+type CoverageResult struct {
+	Success    bool           `json:"success"`
+	Package    string         `json:"package"`
+	Passed     bool           `json:"passed"`
+	Functions  []FuncCoverage `json:"functions"`
+	Statements int            `json:"statements"`
+	Covered    int            `json:"covered"`
+	Percent    float64        `json:"percent"`
+	Output     string         `json:"output,omitempty"` // go test output when tests fail
+}
+
+type CoverageOptions struct {
+	Func  string // report only this function
+	Tests bool   // run each test alone to find the tests hitting each function
+}
+
+// coverBlock is one line of a coverage profile.
+type coverBlock struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	Stmts               int
+	Count               int
+}
+
+// Coverage runs the tests of pkg with a coverage profile and maps its
+// blocks onto the module's functions: statements covered per function and
+// the line ranges no test reaches.
+func Coverage(pkg string, opts *CoverageOptions) (*CoverageResult, error) {
+	if opts == nil {
+		opts = &CoverageOptions{}
+	}
+	if pkg == "" {
+		pkg = "./..."
+	}
+	profile, output, passed, err := runCoverage(pkg, "")
+	if err != nil {
+		return nil, err
+	}
+	prog, err := loadProgram(".", false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CoverageResult{Success: true, Package: pkg, Passed: passed}
+	if !passed {
+		result.Output = output
+	}
+	// The blocks of each reported function, for matching per-test profiles.
+	var funcBlocks [][]coverBlock
+	for _, lp := range prog.Packages {
+		for _, f := range lp.Files {
+			file := prog.position(f.Pos()).Filename
+			blocks := profile[lp.ImportPath+"/"+filepath.Base(file)]
+			if len(blocks) == 0 {
+				continue
+			}
+			var lines []string
+			if src, err := os.ReadFile(file); err == nil {
+				lines = strings.Split(string(src), "\n")
+			}
+			for _, decl := range f.Decls {
+				d, ok := decl.(*ast.FuncDecl)
+				if !ok || d.Body == nil || (opts.Func != "" && !matchFunc(d, opts.Func)) {
+					continue
+				}
+				fc := FuncCoverage{SymbolLocation: prog.funcLocation(d)}
+				var own []coverBlock
+				start, end := prog.position(d.Pos()), prog.position(d.End())
+				var uncovered [][2]int
+				for _, b := range blocks {
+					if !posWithin(b.StartLine, b.StartCol, start.Line, start.Column, end.Line, end.Column) {
+						continue
+					}
+					own = append(own, b)
+					fc.Statements += b.Stmts
+					if b.Count > 0 {
+						fc.Covered += b.Stmts
+						continue
+					}
+					first := b.StartLine
+					// A block starting after the "{" ending a line begins on the next line.
+					if first-1 < len(lines) && len(lines[first-1]) >= b.StartCol-1 &&
+						strings.TrimSpace(lines[first-1][b.StartCol-1:]) == "" && first < b.EndLine {
+						first++
+					}
+					uncovered = append(uncovered, [2]int{first, b.EndLine})
+				}
+				fc.Percent = percent(fc.Covered, fc.Statements)
+				for _, r := range mergeRanges(uncovered) {
+					fc.Uncovered = append(fc.Uncovered, fmt.Sprintf("%s:%d:%d", file, r[0], r[1]))
+				}
+				result.Statements += fc.Statements
+				result.Covered += fc.Covered
+				result.Functions = append(result.Functions, fc)
+				funcBlocks = append(funcBlocks, own)
+			}
+		}
+	}
+	if opts.Func != "" && len(result.Functions) == 0 {
+		return nil, fmt.Errorf("function %s not found in the coverage of %s", opts.Func, pkg)
+	}
+	result.Percent = percent(result.Covered, result.Statements)
+
+	if opts.Tests {
+		tests, err := listTests(pkg)
+		if err != nil {
+			return nil, err
+		}
+		for _, test := range tests {
+			profile, _, _, err := runCoverage(test[0], "^"+test[1]+"$")
+			if err != nil {
+				return nil, err
+			}
+			for i, own := range funcBlocks {
+				fc := &result.Functions[i]
+				blocks := profile[prog.importPathOf(filepath.Dir(fc.File))+"/"+filepath.Base(fc.File)]
+				if hitsFunc(blocks, own) {
+					fc.Tests = append(fc.Tests, test[1])
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// runCoverage runs go test with a set-mode coverage profile and parses it,
+// keyed by "importpath/file.go". A failing test still yields a profile; a
+// build failure does not and is returned as an error.
+func runCoverage(pkg, run string) (map[string][]coverBlock, string, bool, error) {
+	tmp, err := os.CreateTemp("", "gorefactor-cover-*.out")
+	if err != nil {
+		return nil, "", false, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{"test", "-covermode=set", "-coverprofile=" + tmp.Name()}
+	if run != "" {
+		args = append(args, "-run", run)
+	}
+	out, runErr := exec.Command("go", append(args, pkg)...).CombinedOutput()
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil || len(data) == 0 {
+		return nil, "", false, fmt.Errorf("go test %s produced no coverage profile:\n%s", pkg, out)
+	}
+
+	profile := make(map[string][]coverBlock)
+	for _, line := range strings.Split(string(data), "\n") {
+		// importpath/file.go:startLine.startCol,endLine.endCol stmts count
+		colon := strings.LastIndex(line, ":")
+		if colon < 0 || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		var b coverBlock
+		if _, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.Stmts, &b.Count); err != nil {
+			continue
+		}
+		profile[line[:colon]] = append(profile[line[:colon]], b)
+	}
+	// Profiles of several packages may repeat a block; a hit in any counts.
+	for file, blocks := range profile {
+		sort.Slice(blocks, func(i, j int) bool {
+			if blocks[i].StartLine != blocks[j].StartLine {
+				return blocks[i].StartLine < blocks[j].StartLine
+			}
+			return blocks[i].StartCol < blocks[j].StartCol
+		})
+		var merged []coverBlock
+		for _, b := range blocks {
+			if n := len(merged); n > 0 && merged[n-1].StartLine == b.StartLine && merged[n-1].StartCol == b.StartCol {
+				merged[n-1].Count += b.Count
+				continue
+			}
+			merged = append(merged, b)
+		}
+		profile[file] = merged
+	}
+	return profile, string(out), runErr == nil, nil
+}
+
+// listTests returns the import path and name of every Test function of the
+// packages matching pattern.
+func listTests(pattern string) ([][2]string, error) {
+	out, err := exec.Command("go", "list", pattern).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v", pattern, err)
+	}
+	var tests [][2]string
+	for _, path := range strings.Fields(string(out)) {
+		listed, err := exec.Command("go", "test", "-list", "^Test", path).Output()
+		if err != nil {
+			return nil, fmt.Errorf("go test -list %s: %v", path, err)
+		}
+		for _, name := range strings.Fields(string(listed)) {
+			if strings.HasPrefix(name, "Test") {
+				tests = append(tests, [2]string{path, name})
+			}
+		}
+	}
+	return tests, nil
+}
+
+// hitsFunc reports whether any of the function's blocks has a count in
+// blocks.
+func hitsFunc(blocks, own []coverBlock) bool {
+	for _, fb := range own {
+		for _, b := range blocks {
+			if b.StartLine == fb.StartLine && b.StartCol == fb.StartCol && b.Count > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func posWithin(line, col, startLine, startCol, endLine, endCol int) bool {
+	if line < startLine || (line == startLine && col < startCol) {
+		return false
+	}
+	return line < endLine || (line == endLine && col <= endCol)
+}
+
+// mergeRanges sorts line ranges and joins overlapping or adjacent ones.
+func mergeRanges(ranges [][2]int) [][2]int {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var merged [][2]int
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1]+1 {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// percent returns covered/total as a percentage rounded to one decimal; a
+// function without statements counts as fully covered.
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return math.Round(1000*float64(covered)/float64(total)) / 10
+}
@@ -0,0 +1,56 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestCoverage(t *testing.T) {
+	src := `package app
+
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func Unused() int {
+	return 1
+}
+`
+	test := `package app
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	if Abs(2) != 2 {
+		t.Fatal("Abs(2) != 2")
+	}
+}
+`
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": src, "app_test.go": test})
+	chdir(t, dir)
+
+	result, err := refactor.Coverage("", &refactor.CoverageOptions{Tests: true})
+	if err != nil {
+		t.Fatalf("Coverage error: %v", err)
+	}
+	if !result.Passed || len(result.Functions) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	abs := result.Functions[0]
+	if abs.Name != "Abs" || abs.Statements != 3 || abs.Covered != 2 || len(abs.Uncovered) != 1 ||
+		!strings.HasSuffix(abs.Uncovered[0], "app.go:5:6") || strings.Join(abs.Tests, ",") != "TestAbs" {
+		t.Errorf("unexpected coverage of Abs: %+v", abs)
+	}
+	if unused := result.Functions[1]; unused.Percent != 0 || len(unused.Tests) != 0 {
+		t.Errorf("unexpected coverage of Unused: %+v", unused)
+	}
+
+	if _, err := refactor.Coverage("", &refactor.CoverageOptions{Func: "Missing"}); err == nil {
+		t.Error("expected an error for an unknown function")
+	}
+}
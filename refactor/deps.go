@@ -0,0 +1,512 @@
+package refactor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ImportEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+type ExternalUse struct {
+	Module   string   `json:"module"`
+	Packages []string `json:"packages"`
+}
+
+type PackageDeps struct {
+	Path       string        `json:"path"`
+	Dir        string        `json:"dir"`
+	Imports    []string      `json:"imports,omitempty"`
+	ImportedBy []string      `json:"importedBy,omitempty"`
+	External   []ExternalUse `json:"external,omitempty"`
+	Stdlib     []string      `json:"stdlib,omitempty"`
+	FanIn      int           `json:"fanIn"`
+	FanOut     int           `json:"fanOut"`
+}
+
+type LayerViolation struct {
+	Rule string `json:"rule"`
+	ImportEdge
+}
+
+type DepsResult struct {
+	Success    bool             `json:"success"`
+	Module     string           `json:"module"`
+	Packages   []PackageDeps    `json:"packages,omitempty"`
+	Reverse    string           `json:"reverse,omitempty"`
+	Violations []LayerViolation `json:"violations,omitempty"`
+	Graph      string           `json:"graph,omitempty"`
+}
+
+type DepsOptions struct {
+	Format  string // json, dot
+	Reverse string // only packages depending on this one
+	Rules   string // layering rules file
+}
+
+// importGraph holds the non-test imports of every package in a module.
+type importGraph struct {
+	root     string
+	module   string
+	ws       *workspace
+	requires []string
+	pkgs     map[string]*PackageDeps
+	edges    map[string][]ImportEdge
+	other    map[string][]string
+}
+
+func loadImportGraph(dir string) (*importGraph, error) {
+	ws, err := findWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+	_, modulePath, err := ws.moduleRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := &importGraph{
+		root:     ws.Root,
+		module:   modulePath,
+		ws:       ws,
+		requires: ws.requires(),
+		pkgs:     make(map[string]*PackageDeps),
+		edges:    make(map[string][]ImportEdge),
+		other:    make(map[string][]string),
+	}
+
+	ws.walkPackageDirs(func(_ *wsModule, path string) {
+		g.parseDir(path)
+	})
+
+	for from, edges := range g.edges {
+		for _, e := range edges {
+			if to, ok := g.pkgs[e.To]; ok {
+				to.ImportedBy = appendUnique(to.ImportedBy, from)
+			}
+		}
+	}
+	for _, pkg := range g.pkgs {
+		sort.Strings(pkg.Imports)
+		sort.Strings(pkg.ImportedBy)
+		pkg.FanOut = len(pkg.Imports)
+		pkg.FanIn = len(pkg.ImportedBy)
+	}
+	return g, nil
+}
+
+func (g *importGraph) parseDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	rel, _ := filepath.Rel(g.root, dir)
+	importPath := g.ws.importPath(dir)
+
+	var pkg *PackageDeps
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := buildContext.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		if pkg == nil {
+			pkg = &PackageDeps{Path: importPath, Dir: filepath.ToSlash(rel)}
+		}
+		for _, imp := range f.Imports {
+			target, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || target == "C" {
+				continue
+			}
+			if g.ws.moduleFor(target) != nil {
+				if !contains(pkg.Imports, target) {
+					pkg.Imports = append(pkg.Imports, target)
+				}
+				g.edges[importPath] = append(g.edges[importPath], ImportEdge{
+					From: importPath,
+					To:   target,
+					File: path,
+					Line: fset.Position(imp.Pos()).Line,
+				})
+				continue
+			}
+			g.other[importPath] = appendUnique(g.other[importPath], target)
+		}
+	}
+	if pkg == nil {
+		return
+	}
+
+	for _, target := range g.other[importPath] {
+		if isStdlib(target) {
+			pkg.Stdlib = append(pkg.Stdlib, target)
+			continue
+		}
+		mod := g.moduleOf(target)
+		found := false
+		for i := range pkg.External {
+			if pkg.External[i].Module == mod {
+				pkg.External[i].Packages = append(pkg.External[i].Packages, target)
+				found = true
+			}
+		}
+		if !found {
+			pkg.External = append(pkg.External, ExternalUse{Module: mod, Packages: []string{target}})
+		}
+	}
+	sort.Strings(pkg.Stdlib)
+	g.pkgs[importPath] = pkg
+}
+
+// moduleOf maps an import path to the longest required module prefix.
+func (g *importGraph) moduleOf(importPath string) string {
+	best := ""
+	for _, req := range g.requires {
+		if (importPath == req || strings.HasPrefix(importPath, req+"/")) && len(req) > len(best) {
+			best = req
+		}
+	}
+	if best == "" {
+		return importPath
+	}
+	return best
+}
+
+func (g *importGraph) sortedPaths() []string {
+	var paths []string
+	for p := range g.pkgs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// resolve accepts an import path, a module-relative dir or a package name.
+func (g *importGraph) resolve(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if _, ok := g.pkgs[name]; ok {
+		return name, true
+	}
+	for _, p := range g.sortedPaths() {
+		pkg := g.pkgs[p]
+		if pkg.Dir == name || (name == "." && pkg.Dir == ".") {
+			return p, true
+		}
+	}
+	for _, p := range g.sortedPaths() {
+		if filepath.Base(p) == name {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func isStdlib(importPath string) bool {
+	first := strings.SplitN(importPath, "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// Deps reports the import graph of the module in the current directory.
+func Deps(opts *DepsOptions) (*DepsResult, error) {
+	if opts == nil {
+		opts = &DepsOptions{}
+	}
+	if opts.Format == "" {
+		opts.Format = "json"
+	}
+	if opts.Format != "json" && opts.Format != "dot" {
+		return nil, fmt.Errorf("unknown format %s (want json or dot)", opts.Format)
+	}
+
+	g, err := loadImportGraph(".")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DepsResult{Success: true, Module: g.module}
+
+	include := make(map[string]bool)
+	if opts.Reverse != "" {
+		target, ok := g.resolve(opts.Reverse)
+		if !ok {
+			return nil, fmt.Errorf("package %s not found", opts.Reverse)
+		}
+		result.Reverse = target
+		queue := []string{target}
+		include[target] = true
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, by := range g.pkgs[cur].ImportedBy {
+				if !include[by] {
+					include[by] = true
+					queue = append(queue, by)
+				}
+			}
+		}
+	}
+
+	for _, p := range g.sortedPaths() {
+		if opts.Reverse == "" || include[p] {
+			result.Packages = append(result.Packages, *g.pkgs[p])
+		}
+	}
+
+	if opts.Rules != "" {
+		rules, err := parseLayerRules(opts.Rules)
+		if err != nil {
+			return nil, err
+		}
+		result.Violations = g.checkRules(rules)
+	}
+
+	if opts.Format == "dot" {
+		var buf bytes.Buffer
+		buf.WriteString("digraph deps {\n\tnode [shape=box];\n")
+		for _, pkg := range result.Packages {
+			fmt.Fprintf(&buf, "\t%q;\n", pkg.Path)
+			for _, imp := range pkg.Imports {
+				if opts.Reverse == "" || include[imp] {
+					fmt.Fprintf(&buf, "\t%q -> %q;\n", pkg.Path, imp)
+				}
+			}
+		}
+		buf.WriteString("}\n")
+		result.Graph = buf.String()
+		result.Packages = nil
+	}
+
+	return result, nil
+}
+
+type layerRule struct {
+	text string
+	from string
+	to   string
+}
+
+// parseLayerRules reads lines of the form "<pkg> must not import <pkg>".
+// Packages are module-relative dirs and also match their subpackages.
+// Empty lines and lines starting with # are ignored.
+func parseLayerRules(file string) ([]layerRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []layerRule
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " must not import ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<pkg> must not import <pkg>\"", file, lineNum)
+		}
+		rules = append(rules, layerRule{
+			text: line,
+			from: strings.Trim(strings.TrimSpace(parts[0]), "./"),
+			to:   strings.Trim(strings.TrimSpace(parts[1]), "./"),
+		})
+	}
+	return rules, scanner.Err()
+}
+
+func (g *importGraph) matchesLayer(importPath, layer string) bool {
+	rel := importPath
+	if m := g.ws.moduleFor(importPath); m != nil {
+		rel = strings.TrimPrefix(strings.TrimPrefix(importPath, m.Path), "/")
+	}
+	return rel == layer || strings.HasPrefix(rel, layer+"/") || importPath == layer || strings.HasPrefix(importPath, layer+"/")
+}
+
+func (g *importGraph) checkRules(rules []layerRule) []LayerViolation {
+	var violations []LayerViolation
+	for _, from := range g.sortedPaths() {
+		for _, e := range g.edges[from] {
+			for _, r := range rules {
+				if g.matchesLayer(e.From, r.from) && g.matchesLayer(e.To, r.to) {
+					violations = append(violations, LayerViolation{Rule: r.text, ImportEdge: e})
+				}
+			}
+		}
+		for _, target := range g.other[from] {
+			for _, r := range rules {
+				if g.matchesLayer(from, r.from) && (target == r.to || strings.HasPrefix(target, r.to+"/")) {
+					violations = append(violations, LayerViolation{Rule: r.text, ImportEdge: ImportEdge{From: from, To: target}})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+type ImportCycle struct {
+	Packages []string     `json:"packages"`
+	Edges    []ImportEdge `json:"edges"`
+	Closing  ImportEdge   `json:"closing"`
+}
+
+type CyclesResult struct {
+	Success bool          `json:"success"`
+	Cycles  []ImportCycle `json:"cycles"`
+	Count   int           `json:"count"`
+}
+
+// Cycles reports import cycles between packages of the module. Each cycle
+// lists the imports forming it; Closing is the import that completes it.
+func Cycles() (*CyclesResult, error) {
+	g, err := loadImportGraph(".")
+	if err != nil {
+		return nil, err
+	}
+
+	var cycles []ImportCycle
+	for _, scc := range g.components() {
+		if c := g.cycleIn(scc); c != nil {
+			cycles = append(cycles, *c)
+		}
+	}
+
+	return &CyclesResult{
+		Success: true,
+		Cycles:  cycles,
+		Count:   len(cycles),
+	}, nil
+}
+
+// components returns the strongly connected components with more than one
+// package, or a single package importing itself (Tarjan's algorithm).
+func (g *importGraph) components() [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var result [][]string
+	next := 0
+
+	var strongconnect func(v string)
+	strongconnect = func(v string) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.pkgs[v].Imports {
+			if _, ok := g.pkgs[w]; !ok {
+				continue
+			}
+			if _, seen := index[w]; !seen {
+				strongconnect(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+
+		if low[v] == index[v] {
+			var scc []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			if len(scc) > 1 || contains(g.pkgs[v].Imports, v) {
+				sort.Strings(scc)
+				result = append(result, scc)
+			}
+		}
+	}
+
+	for _, p := range g.sortedPaths() {
+		if _, seen := index[p]; !seen {
+			strongconnect(p)
+		}
+	}
+	return result
+}
+
+// cycleIn finds one concrete cycle through the first package of scc.
+func (g *importGraph) cycleIn(scc []string) *ImportCycle {
+	inSCC := make(map[string]bool)
+	for _, p := range scc {
+		inSCC[p] = true
+	}
+	start := scc[0]
+
+	prev := make(map[string]ImportEdge)
+	queue := []string{start}
+	visited := map[string]bool{start: true}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.edges[cur] {
+			if !inSCC[e.To] {
+				continue
+			}
+			if e.To == start {
+				var path []ImportEdge
+				path = append(path, e)
+				for at := cur; at != start; at = prev[at].From {
+					path = append([]ImportEdge{prev[at]}, path...)
+				}
+				cycle := &ImportCycle{Edges: path, Closing: e}
+				for _, pe := range path {
+					cycle.Packages = append(cycle.Packages, pe.From)
+				}
+				return cycle
+			}
+			if !visited[e.To] {
+				visited[e.To] = true
+				prev[e.To] = e
+				queue = append(queue, e.To)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package refactor_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestDeps(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":                   "module example.com/app\n\ngo 1.21\n\nrequire github.com/pkg/errors v0.9.1\n",
+		"main.go":                  "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/internal/http\"\n)\n\nfunc main() { fmt.Println(http.Serve()) }\n",
+		"internal/http/http.go":    "package http\n\nimport \"example.com/app/internal/domain\"\n\nfunc Serve() string { return domain.Name() }\n",
+		"internal/domain/model.go": "package domain\n\nimport (\n\t\"github.com/pkg/errors\"\n\n\t\"example.com/app/internal/http\"\n)\n\nvar _ = errors.New\n\nfunc Name() string { return \"\" }\n\nvar _ = http.Serve\n",
+		"layers.txt":               "# layering\ninternal/domain must not import internal/http\n",
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Deps(&refactor.DepsOptions{Rules: "layers.txt"})
+	if err != nil {
+		t.Fatalf("Deps error: %v", err)
+	}
+	if len(result.Packages) != 3 {
+		t.Fatalf("expected 3 packages, got %+v", result.Packages)
+	}
+
+	byPath := map[string]refactor.PackageDeps{}
+	for _, p := range result.Packages {
+		byPath[p.Path] = p
+	}
+	domain := byPath["example.com/app/internal/domain"]
+	if domain.FanIn != 1 || domain.FanOut != 1 {
+		t.Errorf("domain fan-in/out: got %d/%d, want 1/1", domain.FanIn, domain.FanOut)
+	}
+	if len(domain.External) != 1 || domain.External[0].Module != "github.com/pkg/errors" {
+		t.Errorf("expected external github.com/pkg/errors, got %+v", domain.External)
+	}
+	if len(result.Violations) != 1 || result.Violations[0].Line != 6 {
+		t.Errorf("expected one violation on line 6, got %+v", result.Violations)
+	}
+
+	reverse, err := refactor.Deps(&refactor.DepsOptions{Reverse: "internal/http"})
+	if err != nil {
+		t.Fatalf("Deps error: %v", err)
+	}
+	if len(reverse.Packages) != 3 {
+		t.Errorf("expected every package to depend on internal/http, got %d", len(reverse.Packages))
+	}
+
+	cycles, err := refactor.Cycles()
+	if err != nil {
+		t.Fatalf("Cycles error: %v", err)
+	}
+	if cycles.Count != 1 {
+		t.Fatalf("expected 1 cycle, got %+v", cycles.Cycles)
+	}
+	closing := cycles.Cycles[0].Closing
+	if filepath.Base(closing.File) != "http.go" && filepath.Base(closing.File) != "model.go" {
+		t.Errorf("unexpected closing import %+v", closing)
+	}
+	if len(cycles.Cycles[0].Edges) != 2 {
+		t.Errorf("expected a 2-edge cycle, got %+v", cycles.Cycles[0].Edges)
+	}
+}
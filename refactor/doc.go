@@ -0,0 +1,365 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc/comment"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type DocBlock struct {
+	Kind  string   `json:"kind"`
+	Text  string   `json:"text,omitempty"`
+	Items []string `json:"items,omitempty"`
+}
+
+type DocLink struct {
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+	Ref  string `json:"ref,omitempty"`
+}
+
+type DocResult struct {
+	Success    bool       `json:"success"`
+	Name       string     `json:"name"`
+	Kind       string     `json:"kind"`
+	File       string     `json:"file"`
+	Line       int        `json:"line"`
+	HasDoc     bool       `json:"hasDoc"`
+	Text       string     `json:"text,omitempty"`
+	Blocks     []DocBlock `json:"blocks,omitempty"`
+	Links      []DocLink  `json:"links,omitempty"`
+	Deprecated string     `json:"deprecated,omitempty"`
+}
+
+// docTarget is a declaration that can carry a doc comment.
+type docTarget struct {
+	kind  string
+	ident string            // bare name the comment should start with
+	node  ast.Node          // where a new comment is inserted
+	doc   *ast.CommentGroup // existing comment, if any
+}
+
+// findDocTarget locates name in f. Names follow SymbolLocation.Name:
+// "Func", "T.Method", "*T.Method", "Type", "Type.Field", "Const", "Var".
+func findDocTarget(f *ast.File, name string) *docTarget {
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if matchFunc(d, name) {
+				return &docTarget{kind: "func", ident: d.Name.Name, node: d, doc: d.Doc}
+			}
+		case *ast.GenDecl:
+			single := d.Lparen == token.NoPos
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.Name == name {
+						t := &docTarget{kind: "type", ident: s.Name.Name, node: s, doc: s.Doc}
+						if single {
+							t.node, t.doc = d, d.Doc
+						}
+						return t
+					}
+					st, ok := s.Type.(*ast.StructType)
+					if !ok || st.Fields == nil || !strings.HasPrefix(name, s.Name.Name+".") {
+						continue
+					}
+					fieldName := strings.TrimPrefix(name, s.Name.Name+".")
+					for _, field := range st.Fields.List {
+						for _, ident := range field.Names {
+							if ident.Name == fieldName {
+								return &docTarget{kind: "field", ident: ident.Name, node: field, doc: field.Doc}
+							}
+						}
+					}
+				case *ast.ValueSpec:
+					for _, ident := range s.Names {
+						if ident.Name != name {
+							continue
+						}
+						kind := "var"
+						if d.Tok == token.CONST {
+							kind = "const"
+						}
+						t := &docTarget{kind: kind, ident: ident.Name, node: s, doc: s.Doc}
+						if single {
+							t.node, t.doc = d, d.Doc
+						}
+						return t
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func locateDocFile(name, file string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+	loc, err := locateSymbol(name, ".")
+	if err != nil {
+		return "", err
+	}
+	if loc == nil {
+		return "", fmt.Errorf("symbol %s not found", name)
+	}
+	return loc.File, nil
+}
+
+// Doc returns the doc comment of a symbol parsed into go/doc/comment blocks.
+func Doc(name, file string) (*DocResult, error) {
+	file, err := locateDocFile(name, file)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	target := findDocTarget(f, name)
+	if target == nil {
+		return nil, fmt.Errorf("symbol %s not found in %s", name, file)
+	}
+
+	result := &DocResult{
+		Success: true,
+		Name:    name,
+		Kind:    target.kind,
+		File:    file,
+		Line:    fset.Position(target.node.Pos()).Line,
+	}
+	if target.doc == nil {
+		return result, nil
+	}
+
+	result.HasDoc = true
+	result.Text = target.doc.Text()
+	d := docParser(filepath.Dir(file)).Parse(result.Text)
+	result.Blocks, result.Links = docBlocks(d)
+	for _, b := range result.Blocks {
+		if b.Kind == "paragraph" && strings.HasPrefix(b.Text, "Deprecated: ") {
+			result.Deprecated = strings.TrimPrefix(b.Text, "Deprecated: ")
+		}
+	}
+	return result, nil
+}
+
+// docParser returns a comment parser that resolves [Name] and [Type.Method]
+// doc links against the symbols of the package in dir.
+func docParser(dir string) *comment.Parser {
+	known := make(map[string]bool)
+	if syms, err := packageSymbols(dir); err == nil {
+		for _, sym := range syms.Symbols {
+			known[strings.TrimPrefix(sym.Name, "*")] = true
+		}
+	}
+	return &comment.Parser{
+		LookupSym: func(recv, name string) bool {
+			if recv != "" {
+				return known[recv+"."+name]
+			}
+			return known[name]
+		},
+	}
+}
+
+func docBlocks(d *comment.Doc) ([]DocBlock, []DocLink) {
+	var blocks []DocBlock
+	var links []DocLink
+	for _, b := range d.Content {
+		switch b := b.(type) {
+		case *comment.Paragraph:
+			blocks = append(blocks, DocBlock{Kind: "paragraph", Text: inlineText(b.Text, &links)})
+		case *comment.Heading:
+			blocks = append(blocks, DocBlock{Kind: "heading", Text: inlineText(b.Text, &links)})
+		case *comment.Code:
+			blocks = append(blocks, DocBlock{Kind: "code", Text: b.Text})
+		case *comment.List:
+			block := DocBlock{Kind: "list"}
+			for _, item := range b.Items {
+				var parts []string
+				for _, c := range item.Content {
+					if p, ok := c.(*comment.Paragraph); ok {
+						parts = append(parts, inlineText(p.Text, &links))
+					}
+				}
+				block.Items = append(block.Items, strings.Join(parts, "\n"))
+			}
+			blocks = append(blocks, block)
+		}
+	}
+	for _, def := range d.Links {
+		links = append(links, DocLink{Text: def.Text, URL: def.URL})
+	}
+	return blocks, links
+}
+
+func inlineText(text []comment.Text, links *[]DocLink) string {
+	var buf strings.Builder
+	for _, t := range text {
+		switch t := t.(type) {
+		case comment.Plain:
+			buf.WriteString(string(t))
+		case comment.Italic:
+			buf.WriteString(string(t))
+		case *comment.Link:
+			s := inlineText(t.Text, links)
+			buf.WriteString(s)
+			if !t.Auto {
+				*links = append(*links, DocLink{Text: s, URL: t.URL})
+			} else {
+				*links = append(*links, DocLink{Text: s, URL: s})
+			}
+		case *comment.DocLink:
+			s := inlineText(t.Text, links)
+			buf.WriteString(s)
+			ref := t.Name
+			if t.Recv != "" {
+				ref = t.Recv + "." + ref
+			}
+			if t.ImportPath != "" {
+				ref = t.ImportPath + "." + ref
+			}
+			*links = append(*links, DocLink{Text: s, Ref: ref})
+		}
+	}
+	return buf.String()
+}
+
+// formatDocComment turns plain text into "//" comment lines. The text is
+// made to start with ident, as Go doc comments should, unless it already
+// does, possibly after "A", "An" or "The", or is a deprecation notice. It
+// returns "" if nothing is left once comment markers are stripped.
+func formatDocComment(ident, text string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "//") {
+			line = strings.TrimPrefix(strings.TrimPrefix(trimmed, "//"), " ")
+		}
+		lines = append(lines, line)
+	}
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+	if text == "" {
+		return ""
+	}
+
+	words := strings.Fields(text)
+	if len(words) > 1 && (words[0] == "A" || words[0] == "An" || words[0] == "The") {
+		words = words[1:]
+	}
+	if words[0] != ident && !strings.HasPrefix(text, "Deprecated:") {
+		r, size := utf8.DecodeRuneInString(text)
+		next, _ := utf8.DecodeRuneInString(text[size:])
+		if unicode.IsUpper(r) && !unicode.IsUpper(next) {
+			text = string(unicode.ToLower(r)) + text[size:]
+		}
+		text = ident + " " + text
+	}
+
+	var p comment.Parser
+	var pr comment.Printer
+	formatted := strings.TrimSuffix(string(pr.Comment(p.Parse(text))), "\n")
+	lines = strings.Split(formatted, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = "//"
+		} else {
+			lines[i] = "// " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SetDoc inserts or replaces the doc comment of a symbol with text read from
+// newDoc. Empty text, or only comment markers, removes the comment.
+func SetDoc(name, file string, newDoc io.Reader) (*ModifyResult, error) {
+	file, err := locateDocFile(name, file)
+	if err != nil {
+		return nil, err
+	}
+
+	textBytes, err := io.ReadAll(newDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	target := findDocTarget(f, name)
+	if target == nil {
+		return nil, fmt.Errorf("symbol %s not found in %s", name, file)
+	}
+
+	nodeStart := fset.Position(target.node.Pos()).Offset
+	lineStart := nodeStart
+	for lineStart > 0 && src[lineStart-1] != '\n' {
+		lineStart--
+	}
+	indent := string(src[lineStart:nodeStart])
+
+	var commentText string
+	if text := formatDocComment(target.ident, string(textBytes)); text != "" {
+		commentText = indent + strings.ReplaceAll(text, "\n", "\n"+indent) + "\n"
+	}
+
+	var start, end int
+	if target.doc != nil {
+		start = fset.Position(target.doc.Pos()).Offset
+		for start > 0 && src[start-1] != '\n' {
+			start--
+		}
+		end = lineStart
+	} else {
+		start, end = lineStart, lineStart
+	}
+
+	var result []byte
+	result = append(result, src[:start]...)
+	result = append(result, commentText...)
+	result = append(result, src[end:]...)
+
+	formatted, err := formatSource(result)
+	if err != nil {
+		formatted = result
+	}
+
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, formatted, 0644); err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("set doc comment of %s", name)
+	if commentText == "" {
+		message = fmt.Sprintf("removed doc comment of %s", name)
+	}
+	return &ModifyResult{
+		Success:  true,
+		File:     file,
+		Message:  message,
+		Warnings: siblingWarnings(&SymbolLocation{Name: name, File: file}),
+	}, nil
+}
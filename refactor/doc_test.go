@@ -0,0 +1,206 @@
+package refactor_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const docSource = `package doc
+
+// Fetch downloads a page. See [Client] and https://example.com.
+//
+//	Fetch("https://go.dev")
+//
+// Deprecated: use Get instead.
+func Fetch(url string) {}
+
+type Client struct {
+	Timeout int
+}
+
+const (
+	A = 1
+	B = 2
+)
+
+func (c *Client) Do() {}
+`
+
+func TestDoc(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "doc.go")
+	os.WriteFile(file, []byte(docSource), 0644)
+
+	result, err := refactor.Doc("Fetch", file)
+	if err != nil {
+		t.Fatalf("Doc error: %v", err)
+	}
+	if !result.HasDoc || result.Deprecated != "use Get instead." {
+		t.Errorf("unexpected doc: %+v", result)
+	}
+	if len(result.Blocks) != 3 || result.Blocks[1].Kind != "code" {
+		t.Errorf("expected paragraph, code, paragraph, got %+v", result.Blocks)
+	}
+	if len(result.Links) != 2 || result.Links[0].Ref != "Client" || result.Links[1].URL != "https://example.com" {
+		t.Errorf("unexpected links: %+v", result.Links)
+	}
+
+	empty, err := refactor.Doc("Client.Timeout", file)
+	if err != nil {
+		t.Fatalf("Doc error: %v", err)
+	}
+	if empty.HasDoc || empty.Kind != "field" {
+		t.Errorf("expected undocumented field, got %+v", empty)
+	}
+}
+
+func TestSetDoc(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "doc.go")
+	os.WriteFile(file, []byte(docSource), 0644)
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"Fetch", "Fetch retrieves a page.", "// Fetch retrieves a page.\nfunc Fetch"},
+		{"Client", "Is an HTTP client.", "// Client is an HTTP client.\ntype Client struct"},
+		{"Client.Timeout", "in seconds.", "\t// Timeout in seconds.\n\tTimeout int"},
+		{"B", "is the second.", "\t// B is the second.\n\tB = 2"},
+		{"*Client.Do", "// Do sends.", "// Do sends.\nfunc (c *Client) Do()"},
+		{"Client", "A Client sends requests.", "// A Client sends requests.\ntype Client struct"},
+	}
+	for _, tt := range tests {
+		if _, err := refactor.SetDoc(tt.name, file, strings.NewReader(tt.text)); err != nil {
+			t.Fatalf("SetDoc(%s) error: %v", tt.name, err)
+		}
+		content, _ := os.ReadFile(file)
+		if !strings.Contains(string(content), tt.want) {
+			t.Errorf("SetDoc(%s): %q not found in:\n%s", tt.name, tt.want, content)
+		}
+	}
+
+	content, _ := os.ReadFile(file)
+	if strings.Contains(string(content), "Deprecated") {
+		t.Error("old doc comment of Fetch should be replaced")
+	}
+
+	if _, err := refactor.SetDoc("Fetch", file, strings.NewReader("")); err != nil {
+		t.Fatalf("SetDoc error: %v", err)
+	}
+	content, _ = os.ReadFile(file)
+	if strings.Contains(string(content), "// Fetch") {
+		t.Error("empty text should remove the doc comment")
+	}
+	if _, err := refactor.SetDoc("Client", file, strings.NewReader("//\n")); err != nil {
+		t.Fatalf("SetDoc error: %v", err)
+	}
+	content, _ = os.ReadFile(file)
+	if strings.Contains(string(content), "// A Client") {
+		t.Error("text of only comment markers should remove the doc comment")
+	}
+}
+
+func TestDocReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "api.go"), []byte(`// Package api is documented in the wrong file.
+package api
+
+// Fetch downloads url, retrying maxRetries times.
+func Fetch(url string) {}
+
+// returns the client.
+func Get() {}
+
+func Put() {}
+
+// Limits of the client.
+const (
+	MaxA = 1
+	MaxB = 2
+)
+
+type client struct{}
+
+func (client) Exported() {}
+
+// Head checks `+"`url`"+` like Fetch, retryCount times.
+func Head(u string) {}
+
+// Sync mirrors bucket on iOS and iPadOS, see github.com/acme/blobStore and
+// Client.flushAll(). The cache_dir setting is ignored.
+func Sync(bucket string) {}
+
+// Options configure Fetch.
+type Options struct {
+	// Timeout is in seconds.
+	Timeout int
+	Retries int // per host
+	Proxy   string
+	debug   bool
+}
+`), 0644)
+
+	result, err := refactor.DocReport(tmpDir)
+	if err != nil {
+		t.Fatalf("DocReport error: %v", err)
+	}
+
+	issues := make(map[string]string)
+	for _, issue := range result.Issues {
+		issues[issue.Name] = issue.Issue
+	}
+	want := map[string]string{
+		"Fetch":         refactor.DocStaleParam,
+		"Get":           refactor.DocWrongPrefix,
+		"Put":           refactor.DocMissing,
+		"api":           refactor.DocPackageNotInGo,
+		"Options.Proxy": refactor.DocMissing,
+		"Head":          refactor.DocStaleParam,
+	}
+	for name, issue := range want {
+		if issues[name] != issue {
+			t.Errorf("expected %s for %s, got %q", issue, name, issues[name])
+		}
+	}
+	if len(result.Issues) != len(want) {
+		t.Errorf("unexpected issues: %+v", result.Issues)
+	}
+	if result.Exported != 11 || result.Documented != 9 {
+		t.Errorf("expected 9 of 11 documented, got %d of %d", result.Documented, result.Exported)
+	}
+	for _, issue := range result.Issues {
+		if issue.Name == "Head" && !strings.Contains(issue.Message, "mentions url, retryCount,") {
+			t.Errorf("expected url and retryCount reported for Head, got %q", issue.Message)
+		}
+	}
+}
+
+func TestDocReportRenamedParam(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := writeModule(t, map[string]string{
+		"go.mod":   "module example.com/store\n\ngo 1.21\n",
+		"doc.go":   "// Package store keeps things.\npackage store\n",
+		"store.go": "package store\n\n// Load reads the entry stored under name.\nfunc Load(name string) string { return name }\n",
+	})
+	git(t, dir, "init", "-q")
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "store")
+	os.WriteFile(filepath.Join(dir, "store.go"), []byte("package store\n\n// Load reads the entry stored under name.\nfunc Load(key string) string { return key }\n"), 0644)
+
+	result, err := refactor.DocReport(dir)
+	if err != nil {
+		t.Fatalf("DocReport error: %v", err)
+	}
+	if result.Count != 1 || result.Issues[0].Issue != refactor.DocStaleParam || !strings.Contains(result.Issues[0].Message, "mentions name,") {
+		t.Errorf("expected name reported as stale, got %+v", result.Issues)
+	}
+}
@@ -0,0 +1,392 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Doc report issue codes.
+const (
+	DocMissing        = "missing"
+	DocWrongPrefix    = "wrong-prefix"
+	DocStaleParam     = "stale-param"
+	DocNoPackageDoc   = "missing-package-doc"
+	DocPackageNotInGo = "package-doc-not-in-doc-go"
+)
+
+type DocIssue struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Issue   string `json:"issue"`
+	Message string `json:"message"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Doc     string `json:"doc,omitempty"`
+	Fix     string `json:"fix"`
+}
+
+type DocReportResult struct {
+	Success    bool       `json:"success"`
+	Package    string     `json:"package"`
+	Path       string     `json:"path"`
+	Issues     []DocIssue `json:"issues"`
+	Count      int        `json:"count"`
+	Documented int        `json:"documented"`
+	Exported   int        `json:"exported"`
+}
+
+// lowerWord matches identifier-like words of doc text starting with a
+// lowercase letter; words with underscores are left out.
+var lowerWord = regexp.MustCompile(`\b[a-z][A-Za-z0-9]*\b`)
+
+// paramLike matches words written in mixedCaps with a lowercase head of two
+// letters or more, the way Go parameter names are, unlike iPhone or eBay.
+var paramLike = regexp.MustCompile(`^[a-z]{2}[a-z0-9]*[A-Z][A-Za-z0-9]*$`)
+
+// DocReport lints the doc comments of the exported API of a package:
+// undocumented symbols and struct fields, comments not starting with the
+// symbol name, mentions of parameters the function no longer has and a
+// missing package comment in doc.go.
+func DocReport(pkgPath string) (*DocReportResult, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DocReportResult{Success: true, Path: pkgPath}
+	var files []*ast.File
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(pkgPath, e.Name()), nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		if result.Package == "" {
+			result.Package = f.Name.Name
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", pkgPath)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range files {
+		for _, obj := range f.Scope.Objects {
+			known[obj.Name] = true
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncDecl:
+				known[n.Name.Name] = true
+			case *ast.FuncType:
+				return false // parameters of other functions are no excuse
+			case *ast.Field:
+				for _, name := range n.Names {
+					known[name.Name] = true
+				}
+				return false
+			}
+			return true
+		})
+	}
+
+	for _, f := range files {
+		file := fset.Position(f.Pos()).Filename
+		previous := previousParams(file)
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !ast.IsExported(d.Name.Name) || !exportedRecv(d) {
+					continue
+				}
+				result.Exported++
+				name := funcDeclName(d)
+				issue := DocIssue{Name: name, Kind: "func", File: file, Line: fset.Position(d.Pos()).Line}
+				if result.checkDoc(issue, d.Name.Name, d.Doc) {
+					result.Documented++
+					result.checkParams(issue, d, known, previous[name])
+				}
+
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if !ast.IsExported(s.Name.Name) {
+							continue
+						}
+						result.Exported++
+						doc := s.Doc
+						if doc == nil && len(d.Specs) == 1 {
+							doc = d.Doc
+						}
+						issue := DocIssue{Name: s.Name.Name, Kind: "type", File: file, Line: fset.Position(s.Pos()).Line}
+						if result.checkDoc(issue, s.Name.Name, doc) {
+							result.Documented++
+						}
+						if st, ok := s.Type.(*ast.StructType); ok {
+							result.checkFields(fset, file, s.Name.Name, st)
+						}
+
+					case *ast.ValueSpec:
+						kind := "var"
+						if d.Tok == token.CONST {
+							kind = "const"
+						}
+						for _, ident := range s.Names {
+							if !ast.IsExported(ident.Name) {
+								continue
+							}
+							result.Exported++
+							// A documented group covers its members, as in godoc.
+							if s.Doc == nil && d.Doc != nil && len(d.Specs) > 1 {
+								result.Documented++
+								continue
+							}
+							doc := s.Doc
+							if doc == nil {
+								doc = d.Doc
+							}
+							issue := DocIssue{Name: ident.Name, Kind: kind, File: file, Line: fset.Position(ident.Pos()).Line}
+							if len(s.Names) > 1 && doc != nil {
+								result.Documented++
+								continue
+							}
+							if result.checkDoc(issue, ident.Name, doc) {
+								result.Documented++
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	result.checkPackageDoc(fset, files)
+
+	sort.SliceStable(result.Issues, func(i, j int) bool {
+		a, b := result.Issues[i], result.Issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	result.Count = len(result.Issues)
+	return result, nil
+}
+
+func exportedRecv(d *ast.FuncDecl) bool {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return true
+	}
+	recv := strings.TrimPrefix(formatExpr(d.Recv.List[0].Type), "*")
+	return ast.IsExported(recv)
+}
+
+// checkDoc reports a missing comment or one not starting with ident and
+// returns whether the symbol has a doc comment.
+func (r *DocReportResult) checkDoc(issue DocIssue, ident string, doc *ast.CommentGroup) bool {
+	issue.Fix = fmt.Sprintf("echo '%s ...' | gorefactor set-doc %s", ident, issue.Name)
+	if doc == nil {
+		issue.Issue = DocMissing
+		issue.Message = fmt.Sprintf("exported %s %s has no doc comment", issue.Kind, issue.Name)
+		r.Issues = append(r.Issues, issue)
+		return false
+	}
+
+	text := strings.TrimSpace(doc.Text())
+	issue.Doc = text
+	words := strings.Fields(text)
+	if len(words) > 0 && (words[0] == "A" || words[0] == "An" || words[0] == "The") {
+		words = words[1:]
+	}
+	if len(words) == 0 || (words[0] != ident && !strings.HasPrefix(text, "Deprecated:")) {
+		issue.Issue = DocWrongPrefix
+		issue.Message = fmt.Sprintf("comment on exported %s %s should be of the form \"%s ...\"", issue.Kind, issue.Name, ident)
+		r.Issues = append(r.Issues, issue)
+	}
+	return true
+}
+
+// checkFields reports exported fields of a struct without a doc or line
+// comment. Line comments rarely start with the field name, so unlike
+// checkDoc the wording is not checked.
+func (r *DocReportResult) checkFields(fset *token.FileSet, file, typeName string, st *ast.StructType) {
+	for _, field := range st.Fields.List {
+		for _, ident := range field.Names {
+			if !ast.IsExported(ident.Name) {
+				continue
+			}
+			r.Exported++
+			if field.Doc != nil || field.Comment != nil {
+				r.Documented++
+				continue
+			}
+			name := typeName + "." + ident.Name
+			r.Issues = append(r.Issues, DocIssue{
+				Name:    name,
+				Kind:    "field",
+				Issue:   DocMissing,
+				Message: fmt.Sprintf("exported field %s has no doc comment", name),
+				File:    file,
+				Line:    fset.Position(ident.Pos()).Line,
+				Fix:     fmt.Sprintf("echo '%s ...' | gorefactor set-doc %s", ident.Name, name),
+			})
+		}
+	}
+}
+
+// checkParams flags words in the doc that name no parameter, result or
+// receiver of fn, nor a field or package symbol, but look like a parameter
+// name: written in mixedCaps, in a `code span`, or a parameter of fn as
+// committed at HEAD. These are usually left over from a renamed or removed
+// parameter; plain prose words are not, so other lowercase words are not
+// reported.
+func (r *DocReportResult) checkParams(issue DocIssue, fn *ast.FuncDecl, known map[string]bool, previous []string) {
+	names := make(map[string]bool)
+	for _, n := range paramNames(fn.Recv, fn.Type.Params, fn.Type.Results) {
+		names[n] = true
+	}
+
+	text := fn.Doc.Text()
+	var stale []string
+	for _, loc := range lowerWord.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		if names[word] || known[word] || contains(stale, word) || !standalone(text, loc[0], loc[1]) {
+			continue
+		}
+		if paramLike.MatchString(word) || contains(previous, word) ||
+			inCodeSpan(text, loc[0]) && !token.IsKeyword(word) && types.Universe.Lookup(word) == nil {
+			stale = append(stale, word)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	var current []string
+	for n := range names {
+		current = append(current, n)
+	}
+	sort.Strings(current)
+	issue.Issue = DocStaleParam
+	issue.Doc = strings.TrimSpace(text)
+	issue.Message = fmt.Sprintf("doc of %s mentions %s, which is not a parameter (parameters: %s)",
+		issue.Name, strings.Join(stale, ", "), strings.Join(current, ", "))
+	r.Issues = append(r.Issues, issue)
+}
+
+// paramNames lists the names declared by parameter lists, which may be nil.
+func paramNames(lists ...*ast.FieldList) []string {
+	var names []string
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			for _, n := range field.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	return names
+}
+
+// previousParams returns the parameter, result and receiver names of the
+// functions of file as committed at HEAD, keyed like funcDeclName. It is
+// empty outside a git repository or for a new file.
+func previousParams(file string) map[string][]string {
+	dir, base := filepath.Split(file)
+	src, err := gitOutput(dir, "show", "HEAD:./"+base)
+	if err != nil {
+		return nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), base, src, 0)
+	if err != nil {
+		return nil
+	}
+	result := make(map[string][]string)
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			result[funcDeclName(fn)] = paramNames(fn.Recv, fn.Type.Params, fn.Type.Results)
+		}
+	}
+	return result
+}
+
+// inCodeSpan reports whether offset i of text is between backquotes on
+// its line.
+func inCodeSpan(text string, i int) bool {
+	start := strings.LastIndexByte(text[:i], '\n') + 1
+	return strings.Count(text[start:i], "`")%2 == 1
+}
+
+// standalone reports whether text[start:end] is a bare name rather than a
+// selector, a call or part of a path or URL.
+func standalone(text string, start, end int) bool {
+	if start > 0 && strings.ContainsRune("./-@", rune(text[start-1])) {
+		return false
+	}
+	if end < len(text) {
+		switch text[end] {
+		case '(', '/':
+			return false
+		case '.':
+			return end+1 == len(text) || !unicode.IsLetter(rune(text[end+1]))
+		}
+	}
+	return true
+}
+
+func (r *DocReportResult) checkPackageDoc(fset *token.FileSet, files []*ast.File) {
+	var docFile *ast.File
+	var withDoc []string
+	for _, f := range files {
+		name := fset.Position(f.Pos()).Filename
+		if filepath.Base(name) == "doc.go" {
+			docFile = f
+		}
+		if f.Doc != nil {
+			withDoc = append(withDoc, name)
+		}
+	}
+	if docFile != nil && docFile.Doc != nil {
+		return
+	}
+	if r.Package == "main" && len(withDoc) > 0 {
+		return
+	}
+
+	issue := DocIssue{
+		Name: r.Package,
+		Kind: "package",
+		File: filepath.Join(r.Path, "doc.go"),
+		Line: 1,
+		Fix:  fmt.Sprintf("create %s with \"// Package %s ...\" above the package clause", filepath.Join(r.Path, "doc.go"), r.Package),
+	}
+	if docFile != nil {
+		issue.Fix = fmt.Sprintf("add \"// Package %s ...\" above the package clause of %s", r.Package, issue.File)
+	}
+	if len(withDoc) == 0 {
+		issue.Issue = DocNoPackageDoc
+		issue.Message = fmt.Sprintf("package %s has no package comment", r.Package)
+	} else {
+		issue.Issue = DocPackageNotInGo
+		issue.Message = fmt.Sprintf("package comment of %s is in %s instead of doc.go", r.Package, strings.Join(withDoc, ", "))
+		issue.File = withDoc[0]
+		issue.Fix = fmt.Sprintf("move the package comment from %s to %s", withDoc[0], filepath.Join(r.Path, "doc.go"))
+	}
+	r.Issues = append(r.Issues, issue)
+}
@@ -0,0 +1,310 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DupeFragment struct {
+	Range   string `json:"range"` // file:N:M
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	EndLine int    `json:"endLine"`
+	Func    string `json:"func,omitempty"`
+}
+
+type DupeGroup struct {
+	Tokens    int            `json:"tokens"`
+	Lines     int            `json:"lines"`
+	Fragments []DupeFragment `json:"fragments"`
+	Suggested string         `json:"suggested"` // name for an extracted function
+}
+
+type DupesResult struct {
+	Success bool        `json:"success"`
+	Groups  []DupeGroup `json:"groups"`
+	Count   int         `json:"count"`
+	Message string      `json:"message"`
+}
+
+type DupesOptions struct {
+	MinTokens int // smallest clone reported, 40 by default
+}
+
+// fragment is a run of consecutive statements of one block.
+type fragment struct {
+	pkg    *loadedPackage
+	file   *ast.File
+	stmts  []ast.Stmt
+	tokens int
+}
+
+func (f *fragment) pos() token.Pos { return f.stmts[0].Pos() }
+func (f *fragment) end() token.Pos { return f.stmts[len(f.stmts)-1].End() }
+
+// Dupes finds clones under dir: runs of statements whose syntax trees are
+// equal once identifiers and literals are abstracted away. Statements are
+// hashed one by one and every run of consecutive statements in a block of
+// at least opts.MinTokens tokens is a candidate; groups contained in a
+// larger clone are dropped. Test and generated files are skipped.
+func Dupes(dir string, opts *DupesOptions) (*DupesResult, error) {
+	if opts == nil {
+		opts = &DupesOptions{}
+	}
+	if opts.MinTokens <= 0 {
+		opts.MinTokens = 40
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := loadProgram(dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	byHash := make(map[uint64][]*fragment)
+	for _, pkg := range prog.Packages {
+		for _, f := range pkg.Files {
+			file := prog.position(f.Pos()).Filename
+			if !withinDir(filepath.Dir(file), absDir, true) || prog.fileMeta(file).generated {
+				continue
+			}
+			src, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				var list []ast.Stmt
+				switch n := n.(type) {
+				case *ast.BlockStmt:
+					list = n.List
+				case *ast.CaseClause:
+					list = n.Body
+				case *ast.CommClause:
+					list = n.Body
+				default:
+					return true
+				}
+				shapes := make([]string, len(list))
+				tokens := make([]int, len(list))
+				for i, stmt := range list {
+					shapes[i] = shapeOf(stmt)
+					tokens[i] = countTokens(src, prog.Fset.File(f.Pos()), stmt)
+				}
+				for i := range list {
+					h := fnv.New64a()
+					sum := 0
+					for j := i; j < len(list); j++ {
+						h.Write([]byte(shapes[j]))
+						h.Write([]byte{';'})
+						sum += tokens[j]
+						if sum >= opts.MinTokens {
+							key := h.Sum64()
+							byHash[key] = append(byHash[key], &fragment{pkg: pkg, file: f, stmts: list[i : j+1], tokens: sum})
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	var groups [][]*fragment
+	for _, frags := range byHash {
+		if frags = nonOverlapping(frags); len(frags) >= 2 {
+			groups = append(groups, frags)
+		}
+	}
+	// Largest clones first, so that their parts can be recognized and dropped.
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a[0].tokens != b[0].tokens {
+			return a[0].tokens > b[0].tokens
+		}
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return prog.position(a[0].pos()).String() < prog.position(b[0].pos()).String()
+	})
+
+	result := &DupesResult{Success: true, Groups: []DupeGroup{}}
+	var reported []*fragment
+	names := make(map[string]bool)
+	for _, frags := range groups {
+		if allWithin(frags, reported) {
+			continue
+		}
+		reported = append(reported, frags...)
+
+		g := DupeGroup{Tokens: frags[0].tokens}
+		for _, fr := range frags {
+			start, end := prog.position(fr.pos()), prog.position(fr.end())
+			df := DupeFragment{
+				Range:   fmt.Sprintf("%s:%d:%d", start.Filename, start.Line, end.Line),
+				File:    start.Filename,
+				Line:    start.Line,
+				EndLine: end.Line,
+			}
+			if fn := enclosingFunc(fr.file, fr.pos()); fn != nil {
+				df.Func = funcDeclName(fn)
+			}
+			if lines := end.Line - start.Line + 1; lines > g.Lines {
+				g.Lines = lines
+			}
+			g.Fragments = append(g.Fragments, df)
+		}
+		g.Suggested = suggestExtractName(frags[0], names)
+		result.Groups = append(result.Groups, g)
+	}
+	result.Count = len(result.Groups)
+	result.Message = fmt.Sprintf("%d clone group(s) of at least %d tokens", result.Count, opts.MinTokens)
+	return result, nil
+}
+
+// shapeOf serializes a statement's syntax tree with every identifier and
+// literal replaced by a placeholder, so that clones differing only in
+// names and constants serialize alike.
+func shapeOf(n ast.Node) string {
+	var b strings.Builder
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == nil {
+			b.WriteByte(')')
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.Ident:
+			b.WriteString("(id")
+		case *ast.BasicLit:
+			b.WriteString("(lit")
+		case *ast.BinaryExpr:
+			b.WriteString("(" + n.Op.String())
+		case *ast.UnaryExpr:
+			b.WriteString("(u" + n.Op.String())
+		case *ast.AssignStmt:
+			b.WriteString("(" + n.Tok.String())
+		case *ast.IncDecStmt:
+			b.WriteString("(" + n.Tok.String())
+		case *ast.BranchStmt:
+			b.WriteString("(" + n.Tok.String())
+		default:
+			b.WriteString("(" + reflect.TypeOf(n).Elem().Name())
+		}
+		return true
+	})
+	return b.String()
+}
+
+// countTokens counts the Go tokens of a node's source, comments excluded.
+func countTokens(src []byte, tf *token.File, n ast.Node) int {
+	start, end := tf.Offset(n.Pos()), tf.Offset(n.End())
+	if start < 0 || end > len(src) || start >= end {
+		return 0
+	}
+	var s scanner.Scanner
+	fs := token.NewFileSet()
+	s.Init(fs.AddFile("", -1, end-start), src[start:end], nil, 0)
+	count := 0
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return count
+		}
+		// Skip the semicolons the scanner inserts at line ends.
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		count++
+	}
+}
+
+// nonOverlapping drops fragments overlapping an earlier one of the same
+// file, as in repetitive code a run matches itself shifted by a statement.
+func nonOverlapping(frags []*fragment) []*fragment {
+	sort.Slice(frags, func(i, j int) bool { return frags[i].pos() < frags[j].pos() })
+	var kept []*fragment
+	for _, fr := range frags {
+		if n := len(kept); n > 0 && kept[n-1].file == fr.file && fr.pos() < kept[n-1].end() {
+			continue
+		}
+		kept = append(kept, fr)
+	}
+	return kept
+}
+
+// allWithin reports whether every fragment lies inside a reported one.
+func allWithin(frags, reported []*fragment) bool {
+	for _, fr := range frags {
+		inside := false
+		for _, r := range reported {
+			if r.file == fr.file && r.pos() <= fr.pos() && fr.end() <= r.end() {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
+
+// suggestExtractName names a function extracted from a clone after the
+// first call it makes and the first variable it assigns, as in loadUser
+// for "user, err := store.Load(id)", avoiding names already in use.
+func suggestExtractName(fr *fragment, used map[string]bool) string {
+	var call, variable string
+	for _, stmt := range fr.stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if call == "" {
+					switch fun := unparen(n.Fun).(type) {
+					case *ast.Ident:
+						call = fun.Name
+					case *ast.SelectorExpr:
+						// Error and formatting helpers say little about the code.
+						if pkg, ok := fun.X.(*ast.Ident); !ok || (pkg.Name != "fmt" && pkg.Name != "errors") {
+							call = fun.Sel.Name
+						}
+					}
+					if call == "append" || call == "len" || call == "make" || call == "new" {
+						call = ""
+					}
+				}
+			case *ast.AssignStmt:
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && variable == "" && len(id.Name) > 1 && id.Name != "err" && id.Name != "ok" {
+						variable = id.Name
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	name := "extracted"
+	switch {
+	case call != "" && variable != "" && !strings.EqualFold(call, variable):
+		name = strings.ToLower(call[:1]) + call[1:] + exportedName(variable)
+	case call != "":
+		name = "do" + exportedName(call)
+	case variable != "":
+		name = "compute" + exportedName(variable)
+	}
+	candidate := name
+	for i := 2; used[candidate] || (fr.pkg.Types != nil && fr.pkg.Types.Scope().Lookup(candidate) != nil); i++ {
+		candidate = name + strconv.Itoa(i)
+	}
+	used[candidate] = true
+	return candidate
+}
@@ -0,0 +1,75 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const dupesModule = `package app
+
+import "strings"
+
+type Store struct{ data map[string]string }
+
+func (s *Store) Load(key string) (string, bool) {
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func Greet(s *Store, id string) string {
+	name, ok := s.Load(id)
+	if !ok {
+		name = "stranger"
+	}
+	name = strings.TrimSpace(name)
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	return "hello " + name
+}
+
+func Farewell(s *Store, key string) string {
+	who, found := s.Load(key)
+	if !found {
+		who = "friend"
+	}
+	who = strings.TrimSpace(who)
+	if len(who) > 30 {
+		who = who[:30]
+	}
+	return "bye " + who
+}
+`
+
+func TestDupes(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": dupesModule})
+	chdir(t, dir)
+
+	result, err := refactor.Dupes(".", &refactor.DupesOptions{MinTokens: 30})
+	if err != nil {
+		t.Fatalf("Dupes error: %v", err)
+	}
+	if result.Count != 1 {
+		t.Fatalf("expected 1 clone group, got %+v", result.Groups)
+	}
+	g := result.Groups[0]
+	if len(g.Fragments) != 2 || g.Fragments[0].Func != "Greet" || g.Fragments[1].Func != "Farewell" {
+		t.Fatalf("expected the bodies of Greet and Farewell, got %+v", g.Fragments)
+	}
+	if !strings.HasSuffix(g.Fragments[0].Range, "app.go:13:21") || g.Lines != 9 {
+		t.Errorf("unexpected range %s (%d lines)", g.Fragments[0].Range, g.Lines)
+	}
+	if g.Suggested != "loadName" {
+		t.Errorf("expected suggested name loadName, got %s", g.Suggested)
+	}
+
+	result, err = refactor.Dupes(".", &refactor.DupesOptions{MinTokens: 100})
+	if err != nil {
+		t.Fatalf("Dupes error: %v", err)
+	}
+	if result.Count != 0 {
+		t.Errorf("expected no clones of 100 tokens, got %+v", result.Groups)
+	}
+}
@@ -0,0 +1,384 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// textEdit replaces the bytes [Start, End) of File with Text. Start == End
+// inserts.
+type textEdit struct {
+	File  string
+	Start int
+	End   int
+	Text  string
+}
+
+func (p *program) edit(start, end token.Pos, text string) textEdit {
+	s, e := p.position(start), p.position(end)
+	return textEdit{File: s.Filename, Start: s.Offset, End: e.Offset, Text: text}
+}
+
+// lineStart and lineEnd return the start of the line holding pos and the
+// position of its terminating newline.
+func (p *program) lineStart(pos token.Pos) token.Pos {
+	tf := p.Fset.File(pos)
+	return tf.LineStart(tf.Line(pos))
+}
+
+func (p *program) lineEnd(pos token.Pos) token.Pos {
+	tf := p.Fset.File(pos)
+	if l := tf.Line(pos); l < tf.LineCount() {
+		return tf.LineStart(l+1) - 1
+	}
+	return token.Pos(tf.Base() + tf.Size())
+}
+
+// text returns the source between two positions of the same file.
+func (p *program) text(start, end token.Pos) string {
+	s, e := p.position(start), p.position(end)
+	src, err := os.ReadFile(s.Filename)
+	if err != nil || e.Offset > len(src) {
+		return ""
+	}
+	return string(src[s.Offset:e.Offset])
+}
+
+// applyEdits writes all edits, file by file, and gofmts the result. Edits
+// nested inside a larger replaced range are dropped; partially overlapping
+// edits are an error. Either every file is written or, on failure, every
+// file is restored. It returns the changed files, sorted.
+func applyEdits(edits []textEdit) ([]string, error) {
+	r, err := renderEdits(edits)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.write(); err != nil {
+		return nil, err
+	}
+	return r.files, nil
+}
+
+// renderedEdits holds the contents of the edited files before and after a
+// set of edits, for writing them or showing them as a diff.
+type renderedEdits struct {
+	files  []string
+	before map[string][]byte
+	after  map[string][]byte
+}
+
+// renderEdits applies edits in memory; see applyEdits.
+func renderEdits(edits []textEdit) (*renderedEdits, error) {
+	byFile := make(map[string][]textEdit)
+	for _, e := range edits {
+		byFile[e.File] = append(byFile[e.File], e)
+	}
+
+	r := &renderedEdits{before: make(map[string][]byte), after: make(map[string][]byte)}
+	for file := range byFile {
+		r.files = append(r.files, file)
+	}
+	sort.Strings(r.files)
+	for _, file := range r.files {
+		if err := checkWritable(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range r.files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		r.before[file] = src
+
+		fileEdits := byFile[file]
+		sort.SliceStable(fileEdits, func(i, j int) bool {
+			if fileEdits[i].Start != fileEdits[j].Start {
+				return fileEdits[i].Start < fileEdits[j].Start
+			}
+			return fileEdits[i].End > fileEdits[j].End
+		})
+		var kept []textEdit
+		for _, e := range fileEdits {
+			if n := len(kept); n > 0 && e.Start < kept[n-1].End {
+				if e.End <= kept[n-1].End {
+					continue
+				}
+				return nil, fmt.Errorf("overlapping edits in %s at offset %d", file, e.Start)
+			}
+			kept = append(kept, e)
+		}
+
+		var out []byte
+		last := 0
+		for _, e := range kept {
+			out = append(out, src[last:e.Start]...)
+			out = append(out, e.Text...)
+			last = e.End
+		}
+		out = append(out, src[last:]...)
+
+		if formatted, err := formatSource(out); err == nil {
+			out = formatted
+		}
+		r.after[file] = out
+	}
+	return r, nil
+}
+
+// write writes every rendered file, restoring those already written if one
+// fails.
+func (r *renderedEdits) write() error {
+	for i, file := range r.files {
+		if err := os.WriteFile(file, r.after[file], 0644); err != nil {
+			for _, done := range r.files[:i] {
+				os.WriteFile(done, r.before[done], 0644)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// diff returns a unified diff of the rendered files, with paths relative
+// to root.
+func (r *renderedEdits) diff(root string) string {
+	var b strings.Builder
+	for _, file := range r.files {
+		name := file
+		if rel, err := filepath.Rel(root, file); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+		b.WriteString(unifiedDiff(name, string(r.before[file]), string(r.after[file])))
+	}
+	return b.String()
+}
+
+// unifiedDiff compares two versions of a file line by line and formats the
+// differences as "diff -u" does, with three lines of context.
+func unifiedDiff(name, before, after string) string {
+	if before == after {
+		return ""
+	}
+	a := strings.SplitAfter(before, "\n")
+	b := strings.SplitAfter(after, "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	if b[len(b)-1] == "" {
+		b = b[:len(b)-1]
+	}
+
+	// Longest common subsequence of the lines between the common prefix
+	// and suffix; lcs[i][j] covers a[pre+i:], b[pre+j:].
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	n, m := len(a)-pre-suf, len(b)-pre-suf
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[pre+i] == b[pre+j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// ops lists every line of both versions: ' ' kept, '-' removed, '+' added.
+	type op struct {
+		kind byte
+		line string
+	}
+	var ops []op
+	for _, line := range a[:pre] {
+		ops = append(ops, op{' ', line})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[pre+i] == b[pre+j]:
+			ops = append(ops, op{' ', a[pre+i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{'+', b[pre+j]})
+			j++
+		default:
+			ops = append(ops, op{'-', a[pre+i]})
+			i++
+		}
+	}
+	for _, line := range a[len(a)-suf:] {
+		ops = append(ops, op{' ', line})
+	}
+
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		// A hunk runs from context lines before the first change to
+		// context lines after the last change closer than 2*context.
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		oldStart, newStart := 1, 1
+		for _, o := range ops[:start] {
+			if o.kind != '+' {
+				oldStart++
+			}
+			if o.kind != '-' {
+				newStart++
+			}
+		}
+		oldLines, newLines := 0, 0
+		for _, o := range ops[start:stop] {
+			if o.kind != '+' {
+				oldLines++
+			}
+			if o.kind != '-' {
+				newLines++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLines, newStart, newLines)
+		for _, o := range ops[start:stop] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = stop
+	}
+	return out.String()
+}
+
+// importName returns the name a file refers to an imported package by, or
+// "" if the file does not import it.
+func importName(f *ast.File, path string) string {
+	for _, imp := range f.Imports {
+		if strings.Trim(imp.Path.Value, `"`) != path {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
+
+// qualifiedType formats t as file f of pkg refers to it.
+func qualifiedType(f *ast.File, pkg *loadedPackage, t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		if name := importName(f, p.Path()); name != "" {
+			return name
+		}
+		return p.Name()
+	})
+}
+
+// addImports returns edits adding the given import paths to f, skipping
+// those it already imports. gofmt sorts the block afterwards.
+func (p *program) addImports(f *ast.File, paths ...string) []textEdit {
+	var missing []string
+	for _, path := range paths {
+		if importName(f, path) == "" && !contains(missing, path) {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, path := range missing {
+		lines = append(lines, strconv.Quote(path))
+	}
+
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		if gd.Lparen.IsValid() {
+			return []textEdit{p.edit(gd.Lparen+1, gd.Lparen+1, "\n\t"+strings.Join(lines, "\n\t"))}
+		}
+		spec := p.text(gd.Specs[0].Pos(), gd.Specs[0].End())
+		return []textEdit{p.edit(gd.Pos(), gd.End(), "import (\n\t"+spec+"\n\t"+strings.Join(lines, "\n\t")+"\n)")}
+	}
+
+	pos := p.lineEnd(f.Name.End())
+	if len(lines) == 1 {
+		return []textEdit{p.edit(pos, pos, "\n\nimport "+lines[0])}
+	}
+	return []textEdit{p.edit(pos, pos, "\n\nimport (\n\t"+strings.Join(lines, "\n\t")+"\n)")}
+}
+
+// removeImport returns an edit deleting the import of path from f, with
+// its declaration when it is the only spec there.
+func (p *program) removeImport(f *ast.File, path string) []textEdit {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			if strings.Trim(spec.(*ast.ImportSpec).Path.Value, `"`) != path {
+				continue
+			}
+			var node ast.Node = spec
+			if len(gd.Specs) == 1 {
+				node = gd
+			}
+			return []textEdit{p.edit(p.lineStart(node.Pos()), p.lineEnd(node.End())+1, "")}
+		}
+	}
+	return nil
+}
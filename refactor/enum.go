@@ -0,0 +1,242 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+type EnumOptions struct {
+	Text bool // also generate MarshalText and UnmarshalText
+}
+
+type EnumResult struct {
+	GenResult
+	Values  []string `json:"values"`
+	Updated []string `json:"updated,omitempty"`
+}
+
+// enumFunc is one generated function: name as found in the package, the
+// first line of its doc comment, which marks it as generated, and its code.
+type enumFunc struct {
+	name   string
+	method bool
+	doc    string
+	code   string
+}
+
+// GenEnum generates String, Parse<Type>, <Type>Values and IsValid (and with
+// opts.Text, MarshalText and UnmarshalText) for a type with a group of
+// typed constants. String and Parse<Type> use the constant names for an
+// integer type and the values for a string type, so that MarshalText and
+// UnmarshalText round-trip. On re-run, functions still carrying the
+// generated doc comment are regenerated from the current constants;
+// hand-written ones are left alone.
+func GenEnum(typeName string, opts *EnumOptions) (*EnumResult, error) {
+	if opts == nil {
+		opts = &EnumOptions{}
+	}
+	g, err := loadGenTarget(typeName)
+	if err != nil {
+		return nil, err
+	}
+	basic, ok := g.tn.Type().Underlying().(*types.Basic)
+	if !ok || basic.Info()&(types.IsInteger|types.IsString) == 0 {
+		return nil, fmt.Errorf("%s is not an integer or string type", typeName)
+	}
+
+	consts, lastDecl, lastFile := enumConsts(g)
+	if len(consts) == 0 {
+		return nil, fmt.Errorf("no constants of type %s found", typeName)
+	}
+
+	result := &EnumResult{GenResult: *g.result}
+	// Constants sharing a value are aliases: the first name wins.
+	var unique []*types.Const
+	seen := make(map[string]bool)
+	for _, c := range consts {
+		result.Values = append(result.Values, c.Name())
+		if key := c.Val().ExactString(); !seen[key] {
+			seen[key] = true
+			unique = append(unique, c)
+		}
+	}
+
+	t, r := g.tn.Name(), g.recv
+	isString := basic.Info()&types.IsString != 0
+	zero, _ := zeroValue(g.tn.Type(), t)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "func (%s %s) String() string {\n", r, t)
+	if isString {
+		fmt.Fprintf(&b, "\treturn string(%s)\n}", r)
+	} else {
+		fmt.Fprintf(&b, "\tswitch %s {\n", r)
+		for _, c := range unique {
+			fmt.Fprintf(&b, "\tcase %s:\n\t\treturn %q\n", c.Name(), c.Name())
+		}
+		if basic.Info()&types.IsUnsigned != 0 {
+			fmt.Fprintf(&b, "\t}\n\treturn \"%s(\" + strconv.FormatUint(uint64(%s), 10) + \")\"\n}", t, r)
+		} else {
+			fmt.Fprintf(&b, "\t}\n\treturn \"%s(\" + strconv.FormatInt(int64(%s), 10) + \")\"\n}", t, r)
+		}
+	}
+	// String and Parse<T> agree: a string type is written as its value,
+	// an integer type as the constant's name.
+	what := "name"
+	if isString {
+		what = "value"
+	}
+	funcs := []enumFunc{{name: "String", method: true, doc: fmt.Sprintf("String returns the %s of the %s constant.", what, t), code: b.String()}}
+
+	b.Reset()
+	fmt.Fprintf(&b, "func Parse%s(s string) (%s, error) {\n\tswitch s {\n", exportedName(t), t)
+	for _, c := range unique {
+		if isString {
+			fmt.Fprintf(&b, "\tcase string(%s):\n\t\treturn %s, nil\n", c.Name(), c.Name())
+		} else {
+			fmt.Fprintf(&b, "\tcase %q:\n\t\treturn %s, nil\n", c.Name(), c.Name())
+		}
+	}
+	fmt.Fprintf(&b, "\t}\n\treturn %s, fmt.Errorf(\"invalid %s %%q\", s)\n}", zero, t)
+	funcs = append(funcs, enumFunc{name: "Parse" + exportedName(t), doc: fmt.Sprintf("Parse%s returns the %s constant with the given %s.", exportedName(t), t, what), code: b.String()})
+
+	var names []string
+	for _, c := range unique {
+		names = append(names, c.Name())
+	}
+	funcs = append(funcs, enumFunc{
+		name: exportedName(t) + "Values",
+		doc:  fmt.Sprintf("%sValues returns all %s constants in declaration order.", exportedName(t), t),
+		code: fmt.Sprintf("func %sValues() []%s {\n\treturn []%s{%s}\n}", exportedName(t), t, t, strings.Join(names, ", ")),
+	})
+	funcs = append(funcs, enumFunc{
+		name:   "IsValid",
+		method: true,
+		doc:    fmt.Sprintf("IsValid reports whether %s is one of the declared %s constants.", r, t),
+		code:   fmt.Sprintf("func (%s %s) IsValid() bool {\n\tswitch %s {\n\tcase %s:\n\t\treturn true\n\t}\n\treturn false\n}", r, t, r, strings.Join(names, ", ")),
+	})
+
+	text := []enumFunc{{
+		name:   "MarshalText",
+		method: true,
+		doc:    "MarshalText implements encoding.TextMarshaler.",
+		code:   fmt.Sprintf("func (%s %s) MarshalText() ([]byte, error) {\n\treturn []byte(%s.String()), nil\n}", r, t, r),
+	}, {
+		name:   "UnmarshalText",
+		method: true,
+		doc:    "UnmarshalText implements encoding.TextUnmarshaler.",
+		code:   fmt.Sprintf("func (%s *%s) UnmarshalText(text []byte) error {\n\tv, err := Parse%s(string(text))\n\tif err != nil {\n\t\treturn err\n\t}\n\t*%s = v\n\treturn nil\n}", r, t, exportedName(t), r),
+	}}
+	for _, fn := range text {
+		if decl, _ := g.funcDecl(fn.name, fn.method); opts.Text || decl != nil {
+			funcs = append(funcs, fn)
+		}
+	}
+
+	var edits []textEdit
+	var inserted []string
+	imports := make(map[*ast.File][]string)
+	for _, fn := range funcs {
+		code := "// " + fn.doc + "\n" + fn.code
+		needs := enumImports(code)
+		if decl, f := g.funcDecl(fn.name, fn.method); decl != nil {
+			if decl.Doc == nil || strings.TrimSpace(strings.SplitN(decl.Doc.Text(), "\n", 2)[0]) != fn.doc {
+				result.Skipped = append(result.Skipped, fn.name)
+				continue
+			}
+			edits = append(edits, g.prog.edit(decl.Doc.Pos(), decl.End(), code))
+			result.Updated = append(result.Updated, fn.name)
+			imports[f] = append(imports[f], needs...)
+			continue
+		}
+		inserted = append(inserted, code)
+		result.Generated = append(result.Generated, fn.name)
+		imports[lastFile] = append(imports[lastFile], needs...)
+	}
+	if len(inserted) > 0 {
+		pos := g.prog.lineEnd(lastDecl.End())
+		edits = append(edits, g.prog.edit(pos, pos, "\n\n"+strings.Join(inserted, "\n\n")+"\n"))
+		result.Code = strings.Join(inserted, "\n\n")
+	}
+	for f, paths := range imports {
+		edits = append(edits, g.prog.addImports(f, paths...)...)
+	}
+
+	if len(edits) == 0 {
+		result.Message = "nothing to generate: " + strings.Join(result.Skipped, ", ") + " are hand-written"
+		return result, nil
+	}
+	if result.FilesChanged, err = applyEdits(edits); err != nil {
+		return nil, err
+	}
+	result.Message = fmt.Sprintf("generated enum helpers for %s with %d values", t, len(unique))
+	if len(result.Skipped) > 0 {
+		result.Message += "; kept hand-written " + strings.Join(result.Skipped, ", ")
+	}
+	return result, nil
+}
+
+// enumConsts walks the const declarations of the package, as ReadVarConst
+// does, and returns the constants of the target type in declaration order
+// along with the last declaration holding any of them.
+func enumConsts(g *genTarget) ([]*types.Const, *ast.GenDecl, *ast.File) {
+	var consts []*types.Const
+	var lastDecl *ast.GenDecl
+	var lastFile *ast.File
+	for _, f := range g.pkg.Files {
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				valueSpec, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for _, ident := range valueSpec.Names {
+					c, ok := g.pkg.Info.Defs[ident].(*types.Const)
+					if !ok || ident.Name == "_" || !types.Identical(c.Type(), g.tn.Type()) {
+						continue
+					}
+					consts = append(consts, c)
+					lastDecl, lastFile = genDecl, f
+				}
+			}
+		}
+	}
+	return consts, lastDecl, lastFile
+}
+
+// funcDecl returns the declaration of a method of the target type or of a
+// package-level function, with its file.
+func (g *genTarget) funcDecl(name string, method bool) (*ast.FuncDecl, *ast.File) {
+	for _, f := range g.pkg.Files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Name.Name != name {
+				continue
+			}
+			isMethod := fn.Recv != nil && len(fn.Recv.List) > 0
+			if isMethod != method || (method && recvBaseName(fn.Recv.List[0].Type) != g.tn.Name()) {
+				continue
+			}
+			return fn, f
+		}
+	}
+	return nil, nil
+}
+
+func enumImports(code string) []string {
+	var paths []string
+	if strings.Contains(code, "strconv.") {
+		paths = append(paths, "strconv")
+	}
+	if strings.Contains(code, "fmt.") {
+		paths = append(paths, "fmt")
+	}
+	return paths
+}
@@ -0,0 +1,463 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ErrorFinding struct {
+	Kind    string `json:"kind"` // unchecked, unwrapped, errorf-verb, new-sprintf
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Func    string `json:"func,omitempty"`
+	Message string `json:"message"`
+	Fixed   bool   `json:"fixed,omitempty"`
+}
+
+type ErrorsResult struct {
+	Success      bool           `json:"success"`
+	Findings     []ErrorFinding `json:"findings"`
+	Count        int            `json:"count"`
+	FilesChanged []string       `json:"filesChanged,omitempty"`
+	Message      string         `json:"message"`
+}
+
+type ErrorsOptions struct {
+	Fix bool // rewrite %v of errors to %w and errors.New(fmt.Sprintf(...)) to fmt.Errorf
+}
+
+var errorIface = types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+
+// Errors audits error handling in the package in pkgPath, or in the whole
+// module when pkgPath is empty or "./...": calls whose error result is
+// dropped, bare or assigned to _; errors from another package returned
+// as is, without context; fmt.Errorf formatting an error with %v or %s
+// instead of wrapping it with %w; and errors.New(fmt.Sprintf(...)). With
+// opts.Fix the last two are rewritten.
+func Errors(pkgPath string, opts *ErrorsOptions) (*ErrorsResult, error) {
+	if opts == nil {
+		opts = &ErrorsOptions{}
+	}
+	prog, err := loadProgram(".", false)
+	if err != nil {
+		return nil, err
+	}
+	var scope string
+	if pkgPath != "" && pkgPath != "./..." {
+		scope, err = filepath.Abs(strings.TrimSuffix(pkgPath, "/..."))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &errorAudit{prog: prog, fix: opts.Fix}
+	for _, pkg := range prog.Packages {
+		if pkg.Info == nil || (scope != "" && !withinDir(pkg.Dir, scope, strings.HasSuffix(pkgPath, "/..."))) {
+			continue
+		}
+		for _, f := range pkg.Files {
+			a.file(pkg, f)
+		}
+	}
+
+	result := &ErrorsResult{Success: true, Findings: a.findings}
+	if result.Findings == nil {
+		result.Findings = []ErrorFinding{}
+	}
+	sort.SliceStable(result.Findings, func(i, j int) bool {
+		x, y := result.Findings[i], result.Findings[j]
+		if x.File != y.File {
+			return x.File < y.File
+		}
+		if x.Line != y.Line {
+			return x.Line < y.Line
+		}
+		return x.Column < y.Column
+	})
+	result.Count = len(result.Findings)
+
+	fixed := 0
+	if len(a.edits) > 0 {
+		if result.FilesChanged, err = applyEdits(a.edits); err != nil {
+			return nil, err
+		}
+		for _, f := range result.Findings {
+			if f.Fixed {
+				fixed++
+			}
+		}
+	}
+	result.Message = fmt.Sprintf("%d finding(s)", result.Count)
+	if opts.Fix {
+		result.Message += fmt.Sprintf(", %d fixed", fixed)
+	}
+	return result, nil
+}
+
+type errorAudit struct {
+	prog     *program
+	fix      bool
+	findings []ErrorFinding
+	edits    []textEdit
+}
+
+func (a *errorAudit) report(kind string, f *ast.File, pos token.Pos, fixed bool, format string, args ...any) {
+	p := a.prog.position(pos)
+	finding := ErrorFinding{
+		Kind:    kind,
+		File:    p.Filename,
+		Line:    p.Line,
+		Column:  p.Column,
+		Message: fmt.Sprintf(format, args...),
+		Fixed:   fixed,
+	}
+	if fn := enclosingFunc(f, pos); fn != nil {
+		finding.Func = funcDeclName(fn)
+	}
+	a.findings = append(a.findings, finding)
+}
+
+func (a *errorAudit) file(pkg *loadedPackage, f *ast.File) {
+	// Generated files are reported but only rewritten when forced.
+	fix := a.fix && (!a.prog.fileMeta(a.prog.position(f.Pos()).Filename).generated || forceGenerated)
+	removed := 0 // errors.New calls rewritten away
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Body != nil {
+				a.unwrapped(pkg, f, n)
+			}
+		case *ast.ExprStmt:
+			if call, ok := unparen(n.X).(*ast.CallExpr); ok && returnsError(pkg.Info, call) && !ignoredError(pkg.Info, call) {
+				a.report("unchecked", f, call.Pos(), false, "error returned by %s is not checked", types.ExprString(call.Fun))
+			}
+		case *ast.AssignStmt:
+			a.discarded(pkg, f, n)
+		case *ast.CallExpr:
+			callee, _, _ := resolveCall(pkg.Info, n)
+			if callee == nil || callee.Pkg() == nil {
+				return true
+			}
+			switch callee.Pkg().Path() + "." + callee.Name() {
+			case "fmt.Errorf":
+				a.errorfVerbs(pkg, f, n, fix)
+			case "errors.New":
+				if a.newSprintf(pkg, f, n, fix) {
+					removed++
+				}
+			}
+		}
+		return true
+	})
+
+	if removed > 0 && removed == packageUses(pkg.Info, f, "errors") {
+		a.edits = append(a.edits, a.prog.removeImport(f, "errors")...)
+	}
+}
+
+// returnsError reports whether a call has an error among its results.
+func returnsError(info *types.Info, call *ast.CallExpr) bool {
+	for _, t := range resultTypes(info, call) {
+		if isErrorType(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func resultTypes(info *types.Info, call *ast.CallExpr) []types.Type {
+	if tv, ok := info.Types[call.Fun]; !ok || tv.IsType() || tv.IsBuiltin() {
+		return nil
+	}
+	switch t := info.TypeOf(call).(type) {
+	case nil:
+		return nil
+	case *types.Tuple:
+		var results []types.Type
+		for i := 0; i < t.Len(); i++ {
+			results = append(results, t.At(i).Type())
+		}
+		return results
+	default:
+		return []types.Type{t}
+	}
+}
+
+func isErrorType(t types.Type) bool {
+	return t != nil && types.Implements(t, errorIface)
+}
+
+// ignoredError reports calls whose error is conventionally ignored: the
+// fmt.Print family and writes to in-memory buffers, which cannot fail.
+func ignoredError(info *types.Info, call *ast.CallExpr) bool {
+	callee, _, _ := resolveCall(info, call)
+	if callee == nil || callee.Pkg() == nil {
+		return false
+	}
+	switch callee.Pkg().Path() + "." + callee.Name() {
+	case "fmt.Print", "fmt.Printf", "fmt.Println":
+		return true
+	}
+	if sig, ok := callee.Type().(*types.Signature); ok && sig.Recv() != nil {
+		switch recvTypeName(sig.Recv().Type()) {
+		case "*Buffer", "*Builder":
+			return callee.Pkg().Path() == "bytes" || callee.Pkg().Path() == "strings"
+		}
+	}
+	return false
+}
+
+// discarded reports errors assigned to the blank identifier.
+func (a *errorAudit) discarded(pkg *loadedPackage, f *ast.File, as *ast.AssignStmt) {
+	blank := func(e ast.Expr) bool {
+		id, ok := e.(*ast.Ident)
+		return ok && id.Name == "_"
+	}
+	if len(as.Rhs) == 1 && len(as.Lhs) > 1 {
+		call, ok := unparen(as.Rhs[0]).(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		results := resultTypes(pkg.Info, call)
+		for i, lhs := range as.Lhs {
+			if i < len(results) && blank(lhs) && isErrorType(results[i]) {
+				a.report("unchecked", f, lhs.Pos(), false, "error returned by %s is discarded", types.ExprString(call.Fun))
+			}
+		}
+		return
+	}
+	for i, lhs := range as.Lhs {
+		if i >= len(as.Rhs) || !blank(lhs) {
+			continue
+		}
+		if call, ok := unparen(as.Rhs[i]).(*ast.CallExpr); ok && returnsError(pkg.Info, call) {
+			a.report("unchecked", f, lhs.Pos(), false, "error returned by %s is discarded", types.ExprString(call.Fun))
+		}
+	}
+}
+
+// errAssign records the call an error variable was last set from.
+type errAssign struct {
+	pos    token.Pos
+	obj    types.Object
+	callee *types.Func // nil when not set from a call, or from one creating or wrapping it
+}
+
+// unwrapped reports "return err" where err comes straight from a function
+// of another package: the error crosses the package boundary without the
+// context of what this function was doing.
+func (a *errorAudit) unwrapped(pkg *loadedPackage, f *ast.File, fn *ast.FuncDecl) {
+	var assigns []errAssign
+	record := func(lhs ast.Expr, callee *types.Func) {
+		id, ok := lhs.(*ast.Ident)
+		if !ok {
+			return
+		}
+		obj := pkg.Info.ObjectOf(id)
+		if obj == nil || !isErrorType(obj.Type()) {
+			return
+		}
+		assigns = append(assigns, errAssign{pos: id.Pos(), obj: obj, callee: callee})
+	}
+	// calleeOf returns the function an error comes from, or nil if the
+	// call creates or wraps it rather than passing one on.
+	calleeOf := func(e ast.Expr) *types.Func {
+		call, ok := unparen(e).(*ast.CallExpr)
+		if !ok {
+			return nil
+		}
+		callee, _, _ := resolveCall(pkg.Info, call)
+		if callee == nil || callee.Pkg() == nil {
+			return nil
+		}
+		switch callee.Pkg().Path() + "." + callee.Name() {
+		case "fmt.Errorf", "errors.New", "errors.Join":
+			return nil
+		}
+		for _, arg := range call.Args {
+			if t := pkg.Info.TypeOf(arg); t != nil && isErrorType(t) {
+				return nil
+			}
+		}
+		return callee
+	}
+	assign := func(lhs, rhs []ast.Expr) {
+		if len(rhs) == 1 && len(lhs) > 1 {
+			callee := calleeOf(rhs[0])
+			for _, l := range lhs {
+				record(l, callee)
+			}
+			return
+		}
+		for i, l := range lhs {
+			if i < len(rhs) {
+				record(l, calleeOf(rhs[i]))
+			}
+		}
+	}
+
+	var returns []*ast.ReturnStmt
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			assign(n.Lhs, n.Rhs)
+		case *ast.ValueSpec:
+			var lhs []ast.Expr
+			for _, name := range n.Names {
+				lhs = append(lhs, name)
+			}
+			assign(lhs, n.Values)
+		case *ast.ReturnStmt:
+			returns = append(returns, n)
+		}
+		return true
+	})
+
+	for _, ret := range returns {
+		for _, res := range ret.Results {
+			id, ok := unparen(res).(*ast.Ident)
+			if !ok {
+				continue
+			}
+			obj := pkg.Info.Uses[id]
+			if obj == nil || !isErrorType(obj.Type()) {
+				continue
+			}
+			var last *errAssign
+			for i := range assigns {
+				if assigns[i].obj == obj && assigns[i].pos < ret.Pos() {
+					last = &assigns[i]
+				}
+			}
+			if last == nil || last.callee == nil || last.callee.Pkg() == nil || last.callee.Pkg() == pkg.Types {
+				continue
+			}
+			a.report("unwrapped", f, id.Pos(), false, "%s from %s is returned without context; wrap it with fmt.Errorf(\"...: %%w\", %s)",
+				id.Name, a.prog.funcName(last.callee), id.Name)
+		}
+	}
+}
+
+// errorfVerbs reports fmt.Errorf arguments of error type formatted with
+// %v or %s, and with fix rewrites the verb to %w.
+func (a *errorAudit) errorfVerbs(pkg *loadedPackage, f *ast.File, call *ast.CallExpr, fix bool) {
+	if len(call.Args) == 0 || call.Ellipsis.IsValid() {
+		return
+	}
+	lit, ok := unparen(call.Args[0]).(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	format, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+	// Offsets in format match the literal when nothing is escaped.
+	exact := lit.Value[0] == '`' || !strings.Contains(lit.Value, `\`)
+	for _, v := range formatVerbs(format) {
+		if v.arg+1 >= len(call.Args) || (v.verb != 'v' && v.verb != 's') {
+			continue
+		}
+		arg := call.Args[v.arg+1]
+		if !isErrorType(pkg.Info.TypeOf(arg)) {
+			continue
+		}
+		fixed := fix && exact && v.plain
+		if fixed {
+			pos := lit.Pos() + token.Pos(1+v.offset)
+			a.edits = append(a.edits, a.prog.edit(pos, pos+1, "w"))
+		}
+		a.report("errorf-verb", f, arg.Pos(), fixed, "%s is formatted with %%%c; use %%w to keep it unwrappable", types.ExprString(arg), v.verb)
+	}
+}
+
+// newSprintf reports errors.New(fmt.Sprintf(...)) and with fix rewrites
+// it to fmt.Errorf(...). It reports whether it did.
+func (a *errorAudit) newSprintf(pkg *loadedPackage, f *ast.File, call *ast.CallExpr, fix bool) bool {
+	if len(call.Args) != 1 {
+		return false
+	}
+	inner, ok := unparen(call.Args[0]).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	callee, _, _ := resolveCall(pkg.Info, inner)
+	if callee == nil || callee.Pkg() == nil || callee.Pkg().Path() != "fmt" || callee.Name() != "Sprintf" {
+		return false
+	}
+	sel, ok := unparen(inner.Fun).(*ast.SelectorExpr)
+	fixed := fix && ok
+	if fixed {
+		args := a.prog.text(inner.Lparen+1, inner.Rparen)
+		a.edits = append(a.edits, a.prog.edit(call.Pos(), call.End(), types.ExprString(sel.X)+".Errorf("+args+")"))
+	}
+	a.report("new-sprintf", f, call.Pos(), fixed, "errors.New(fmt.Sprintf(...)) is fmt.Errorf(...)")
+	return fixed
+}
+
+// packageUses counts the references in f to the package imported as path.
+func packageUses(info *types.Info, f *ast.File, path string) int {
+	n := 0
+	ast.Inspect(f, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Ident); ok {
+			if pn, ok := info.Uses[id].(*types.PkgName); ok && pn.Imported().Path() == path {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
+
+// formatVerb is a verb of a printf format and the argument it formats.
+type formatVerb struct {
+	verb   rune
+	arg    int  // index among the arguments after the format
+	offset int  // byte offset of the verb letter in the format
+	plain  bool // no flags, width or precision
+}
+
+// formatVerbs parses the verbs of a printf format. Explicit argument
+// indexes make the mapping ambiguous; such formats yield nothing.
+func formatVerbs(format string) []formatVerb {
+	var verbs []formatVerb
+	arg := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		start := i
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (format[i] >= '0' && format[i] <= '9' || format[i] == '.' || format[i] == '*') {
+			if format[i] == '*' {
+				arg++
+			}
+			i++
+		}
+		if i >= len(format) {
+			break
+		}
+		if format[i] == '[' {
+			return nil
+		}
+		verbs = append(verbs, formatVerb{verb: rune(format[i]), arg: arg, offset: i, plain: i == start})
+		arg++
+	}
+	return verbs
+}
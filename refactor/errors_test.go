@@ -0,0 +1,80 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestErrors(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"a.go": `package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func Clean(x string) {
+	os.Remove(x)
+}
+
+func Read(p string) ([]byte, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func Wrap(err error) error {
+	return fmt.Errorf("x: %v", err)
+}
+
+func Bad(n int) error {
+	return errors.New(fmt.Sprintf("bad %d", n))
+}
+
+func Open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		err = fmt.Errorf("open %s: %w", name, err)
+		return err
+	}
+	return f.Close()
+}
+`,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Errors("", &refactor.ErrorsOptions{Fix: true})
+	if err != nil {
+		t.Fatalf("Errors error: %v", err)
+	}
+	kinds := make(map[string]string)
+	for _, f := range result.Findings {
+		kinds[f.Func] = f.Kind
+	}
+	want := map[string]string{"Clean": "unchecked", "Read": "unwrapped", "Wrap": "errorf-verb", "Bad": "new-sprintf"}
+	for fn, kind := range want {
+		if kinds[fn] != kind {
+			t.Errorf("%s: expected a %s finding, got %q", fn, kind, kinds[fn])
+		}
+	}
+	if kind, ok := kinds["Open"]; ok {
+		t.Errorf("Open wraps the error before returning it, got a %s finding", kind)
+	}
+
+	content := readModuleFile(t, dir, "a.go")
+	for _, s := range []string{`fmt.Errorf("x: %w", err)`, `fmt.Errorf("bad %d", n)`} {
+		if !strings.Contains(content, s) {
+			t.Errorf("expected %s after --fix:\n%s", s, content)
+		}
+	}
+	if strings.Contains(content, `"errors"`) {
+		t.Errorf("unused errors import should be removed:\n%s", content)
+	}
+}
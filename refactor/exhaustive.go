@@ -0,0 +1,359 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type ExhaustiveSwitch struct {
+	File       string   `json:"file"`
+	Line       int      `json:"line"`
+	Column     int      `json:"column"`
+	Func       string   `json:"func,omitempty"`
+	Kind       string   `json:"kind"` // "enum" or "type"
+	Type       string   `json:"type"`
+	Missing    []string `json:"missing"`
+	HasDefault bool     `json:"hasDefault"`
+	Filled     bool     `json:"filled,omitempty"`
+}
+
+type ExhaustiveResult struct {
+	Success      bool               `json:"success"`
+	Switches     []ExhaustiveSwitch `json:"switches"`
+	Count        int                `json:"count"`
+	FilesChanged []string           `json:"filesChanged,omitempty"`
+	Message      string             `json:"message,omitempty"`
+}
+
+type ExhaustiveOptions struct {
+	Fill bool // insert case stubs for the missing values
+	Todo bool // stub bodies are a TODO comment instead of panic("unhandled")
+}
+
+// Exhaustive reports switches under dir that miss cases: expression
+// switches on a module type with declared constants, and type switches on
+// a sealed module interface, one with an unexported method or itself
+// unexported, so that outside code is not expected to implement it. Its
+// implementers are taken to be the types of the workspace implementing
+// it; those the switch cannot name, being
+// unexported, in a main, test or internal package or in one importing the
+// switch's, are left out. With opts.Fill, a case stub per missing value is
+// inserted before default, or at the end of the switch.
+func Exhaustive(dir string, opts *ExhaustiveOptions) (*ExhaustiveResult, error) {
+	if opts == nil {
+		opts = &ExhaustiveOptions{}
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := loadProgram(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExhaustiveResult{Success: true}
+	var edits []textEdit
+	imports := make(map[*ast.File][]string) // added once per file for all its switches
+	for _, pkg := range prog.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		for _, f := range pkg.Files {
+			if !withinDir(filepath.Dir(prog.position(f.Pos()).Filename), absDir, true) {
+				continue
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				var sw ExhaustiveSwitch
+				var body *ast.BlockStmt
+				var missing []exhaustiveCase
+				switch s := n.(type) {
+				case *ast.SwitchStmt:
+					if s.Tag == nil {
+						return true
+					}
+					sw.Kind, body = "enum", s.Body
+					sw.Type, missing = prog.missingConsts(pkg, f, s)
+				case *ast.TypeSwitchStmt:
+					sw.Kind, body = "type", s.Body
+					sw.Type, missing = prog.missingTypes(pkg, f, s)
+				default:
+					return true
+				}
+				if len(missing) == 0 {
+					return true
+				}
+
+				pos := prog.position(n.Pos())
+				sw.File, sw.Line, sw.Column = pos.Filename, pos.Line, pos.Column
+				if fn := enclosingFunc(f, n.Pos()); fn != nil {
+					sw.Func = funcDeclName(fn)
+				}
+				var dflt *ast.CaseClause
+				for _, stmt := range body.List {
+					if cc := stmt.(*ast.CaseClause); cc.List == nil {
+						dflt = cc
+					}
+				}
+				sw.HasDefault = dflt != nil
+				for _, c := range missing {
+					sw.Missing = append(sw.Missing, c.expr)
+				}
+
+				if opts.Fill {
+					stub := "\tpanic(\"unhandled\")\n"
+					if opts.Todo {
+						stub = "\t// TODO: handle\n"
+					}
+					var b strings.Builder
+					for _, c := range missing {
+						b.WriteString("case " + c.expr + ":\n" + stub)
+					}
+					at := body.Rbrace
+					if dflt != nil {
+						at = dflt.Pos()
+					}
+					edits = append(edits, prog.edit(at, at, b.String()))
+					for _, c := range missing {
+						if c.importPath != "" && !contains(imports[f], c.importPath) {
+							imports[f] = append(imports[f], c.importPath)
+						}
+					}
+					sw.Filled = true
+				}
+				result.Switches = append(result.Switches, sw)
+				return true
+			})
+		}
+	}
+	for f, paths := range imports {
+		edits = append(edits, prog.addImports(f, paths...)...)
+	}
+	sort.Slice(result.Switches, func(i, j int) bool {
+		a, b := result.Switches[i], result.Switches[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	result.Count = len(result.Switches)
+
+	if len(edits) > 0 {
+		if result.FilesChanged, err = applyEdits(edits); err != nil {
+			return nil, err
+		}
+		result.Message = fmt.Sprintf("filled %d switch(es)", result.Count)
+	} else {
+		result.Message = fmt.Sprintf("%d non-exhaustive switch(es)", result.Count)
+	}
+	return result, nil
+}
+
+// exhaustiveCase is a missing case as written in the switch's file, with
+// the import it needs, if any.
+type exhaustiveCase struct {
+	expr       string
+	importPath string
+}
+
+// qualifier returns how file f of pkg refers to names of other, and the
+// import path to add if f does not import it yet.
+func qualifier(pkg *loadedPackage, f *ast.File, other *types.Package) (string, string) {
+	if other == pkg.Types {
+		return "", ""
+	}
+	if name := importName(f, other.Path()); name != "" {
+		return name + ".", ""
+	}
+	return other.Name() + ".", other.Path()
+}
+
+// missingConsts returns the constants of the tag's type no case covers.
+// Constants sharing a value count once, under the first name; unexported
+// constants of another package cannot be named and are left out.
+func (p *program) missingConsts(pkg *loadedPackage, f *ast.File, s *ast.SwitchStmt) (string, []exhaustiveCase) {
+	named, ok := pkg.Info.TypeOf(s.Tag).(*types.Named)
+	if !ok || p.pkgOf(named.Obj()) == nil {
+		return "", nil
+	}
+	if _, ok := named.Underlying().(*types.Basic); !ok {
+		return "", nil
+	}
+
+	covered := make(map[string]bool)
+	for _, stmt := range s.Body.List {
+		for _, e := range stmt.(*ast.CaseClause).List {
+			if tv, ok := pkg.Info.Types[e]; ok && tv.Value != nil {
+				covered[tv.Value.ExactString()] = true
+			}
+		}
+	}
+
+	tpkg := named.Obj().Pkg()
+	var consts []*types.Const
+	scope := tpkg.Scope()
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(c.Type(), named) {
+			consts = append(consts, c)
+		}
+	}
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Pos() < consts[j].Pos() })
+
+	prefix, path := qualifier(pkg, f, tpkg)
+	var missing []exhaustiveCase
+	for _, c := range consts {
+		if covered[c.Val().ExactString()] {
+			continue
+		}
+		covered[c.Val().ExactString()] = true
+		if prefix != "" && !c.Exported() {
+			continue
+		}
+		missing = append(missing, exhaustiveCase{expr: prefix + c.Name(), importPath: path})
+	}
+	return typeString(pkg, named), missing
+}
+
+// missingTypes returns the implementers of a sealed module interface no
+// case covers. A case on an interface covers every implementer of it.
+func (p *program) missingTypes(pkg *loadedPackage, f *ast.File, s *ast.TypeSwitchStmt) (string, []exhaustiveCase) {
+	var x ast.Expr
+	switch a := s.Assign.(type) {
+	case *ast.AssignStmt:
+		x = a.Rhs[0]
+	case *ast.ExprStmt:
+		x = a.X
+	}
+	ta, ok := unparen(x).(*ast.TypeAssertExpr)
+	if !ok {
+		return "", nil
+	}
+	named, ok := pkg.Info.TypeOf(ta.X).(*types.Named)
+	if !ok || p.pkgOf(named.Obj()) == nil {
+		return "", nil
+	}
+	iface, ok := named.Underlying().(*types.Interface)
+	if !ok || iface.NumMethods() == 0 || !sealed(named.Obj(), iface) {
+		return "", nil
+	}
+
+	var cases []types.Type
+	for _, stmt := range s.Body.List {
+		for _, e := range stmt.(*ast.CaseClause).List {
+			if tv, ok := pkg.Info.Types[e]; ok && tv.IsType() {
+				cases = append(cases, tv.Type)
+			}
+		}
+	}
+
+	inTest := strings.HasSuffix(p.position(f.Pos()).Filename, "_test.go")
+	var missing []exhaustiveCase
+	for _, tn := range p.namedTypes() {
+		if types.IsInterface(tn.Type()) {
+			continue
+		}
+		t := tn.Type()
+		if !types.Implements(t, iface) {
+			t = types.NewPointer(t)
+			if !types.Implements(t, iface) {
+				continue
+			}
+		}
+		if coveredType(cases, tn.Type()) {
+			continue
+		}
+		if tn.Pkg() != pkg.Types && (!tn.Exported() || !importable(pkg.Types, tn.Pkg())) {
+			continue
+		}
+		if !inTest && strings.HasSuffix(p.position(tn.Pos()).Filename, "_test.go") {
+			continue
+		}
+		prefix, path := qualifier(pkg, f, tn.Pkg())
+		expr := prefix + tn.Name()
+		if _, ok := t.(*types.Pointer); ok {
+			expr = "*" + expr
+		}
+		missing = append(missing, exhaustiveCase{expr: expr, importPath: path})
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return strings.TrimPrefix(missing[i].expr, "*") < strings.TrimPrefix(missing[j].expr, "*")
+	})
+	return typeString(pkg, named), missing
+}
+
+// sealed reports whether the interface tn names is closed to outside
+// implementations: it has an unexported method, or is unexported itself.
+// Switches on other interfaces are usually fast paths with a default.
+func sealed(tn *types.TypeName, iface *types.Interface) bool {
+	if !tn.Exported() {
+		return true
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		if !iface.Method(i).Exported() {
+			return true
+		}
+	}
+	return false
+}
+
+// importable reports whether from may import other: other is no main or
+// external test package, not internal to another tree, and does not
+// itself depend on from.
+func importable(from, other *types.Package) bool {
+	if other.Name() == "main" || strings.HasSuffix(other.Path(), "_test") {
+		return false
+	}
+	if i := strings.LastIndex(other.Path()+"/", "/internal/"); i >= 0 {
+		parent := other.Path()[:i]
+		if from.Path() != parent && !strings.HasPrefix(from.Path(), parent+"/") {
+			return false
+		}
+	}
+	seen := make(map[*types.Package]bool)
+	var dependsOn func(pkg *types.Package) bool
+	dependsOn = func(pkg *types.Package) bool {
+		if pkg.Path() == from.Path() {
+			return true
+		}
+		if seen[pkg] {
+			return false
+		}
+		seen[pkg] = true
+		for _, imp := range pkg.Imports() {
+			if dependsOn(imp) {
+				return true
+			}
+		}
+		return false
+	}
+	return !dependsOn(other)
+}
+
+// coveredType reports whether a case of a type switch matches values of
+// type t: a case on t, on *t, or on an interface t or *t implements.
+func coveredType(cases []types.Type, t types.Type) bool {
+	ptr := types.NewPointer(t)
+	for _, c := range cases {
+		if types.Identical(c, t) || types.Identical(c, ptr) {
+			return true
+		}
+		if ci, ok := c.Underlying().(*types.Interface); ok && (types.Implements(t, ci) || types.Implements(ptr, ci)) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeString names t relative to pkg.
+func typeString(pkg *loadedPackage, t types.Type) string {
+	return types.TypeString(t, func(other *types.Package) string {
+		if other == pkg.Types {
+			return ""
+		}
+		return other.Name()
+	})
+}
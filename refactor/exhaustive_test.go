@@ -0,0 +1,153 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const exhaustiveModule = `package app
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+	Crimson = Red
+)
+
+type Shape interface{ area() float64 }
+
+type Circle struct{}
+type Square struct{}
+
+func (Circle) area() float64  { return 1 }
+func (*Square) area() float64 { return 1 }
+
+func name(c Color) string {
+	switch c {
+	case Red:
+		return "red"
+	default:
+		return "?"
+	}
+}
+
+func kind(s Shape) int {
+	switch s.(type) {
+	case Circle:
+		return 1
+	}
+	return 0
+}
+
+func full(c Color) {
+	switch c {
+	case Red, Green, Blue:
+	}
+}
+`
+
+func TestExhaustive(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": exhaustiveModule})
+	chdir(t, dir)
+
+	result, err := refactor.Exhaustive(".", nil)
+	if err != nil {
+		t.Fatalf("Exhaustive error: %v", err)
+	}
+	if result.Count != 2 {
+		t.Fatalf("expected 2 switches, got %+v", result.Switches)
+	}
+	if sw := result.Switches[0]; sw.Func != "name" || strings.Join(sw.Missing, ",") != "Green,Blue" || !sw.HasDefault {
+		t.Errorf("unexpected enum switch: %+v", sw)
+	}
+	if sw := result.Switches[1]; sw.Kind != "type" || strings.Join(sw.Missing, ",") != "*Square" {
+		t.Errorf("unexpected type switch: %+v", sw)
+	}
+
+	if _, err := refactor.Exhaustive(".", &refactor.ExhaustiveOptions{Fill: true}); err != nil {
+		t.Fatalf("Exhaustive --fill error: %v", err)
+	}
+	src := readModuleFile(t, dir, "app.go")
+	if !strings.Contains(src, "case Blue:\n\t\tpanic(\"unhandled\")\n\tdefault:") || !strings.Contains(src, "case *Square:\n\t\tpanic(\"unhandled\")\n\t}") {
+		t.Errorf("stubs not inserted:\n%s", src)
+	}
+	if again, err := refactor.Exhaustive(".", nil); err != nil || again.Count != 0 {
+		t.Errorf("switches still incomplete after fill: %+v, %v", again, err)
+	}
+}
+
+func TestExhaustiveAcrossPackages(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"shape/shape.go": `package shape
+
+type Shape interface {
+	Area() float64
+	shape()
+}
+
+// Sized is open: any package may implement it.
+type Sized interface{ Area() float64 }
+
+type Circle struct{}
+
+func (Circle) Area() float64 { return 1 }
+func (Circle) shape()        {}
+`,
+		"poly/poly.go": `package poly
+
+import "example.com/app/shape"
+
+type Square struct{ shape.Circle }
+`,
+		"draw/draw.go": `package draw
+
+import "example.com/app/shape"
+
+func Kind(s shape.Shape) int {
+	switch s.(type) {
+	case shape.Circle:
+		return 1
+	}
+	return 0
+}
+
+func Name(s shape.Shape) string {
+	switch s.(type) {
+	case shape.Circle:
+		return "circle"
+	}
+	return ""
+}
+
+func Size(s shape.Sized) float64 {
+	switch s := s.(type) {
+	case shape.Circle:
+		return 1
+	default:
+		return s.Area()
+	}
+}
+`,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Exhaustive(".", &refactor.ExhaustiveOptions{Fill: true})
+	if err != nil {
+		t.Fatalf("Exhaustive error: %v", err)
+	}
+	if result.Count != 2 || strings.Join(result.Switches[0].Missing, ",") != "poly.Square" {
+		t.Fatalf("expected poly.Square missing from both sealed switches, got %+v", result.Switches)
+	}
+	src := readModuleFile(t, dir, "draw/draw.go")
+	if strings.Count(src, `"example.com/app/poly"`) != 1 || strings.Count(src, "case poly.Square:") != 2 {
+		t.Errorf("expected one import for both filled switches:\n%s", src)
+	}
+	if again, err := refactor.Exhaustive(".", nil); err != nil || again.Count != 0 {
+		t.Errorf("switches still incomplete after fill: %+v, %v", again, err)
+	}
+}
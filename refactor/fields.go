@@ -0,0 +1,651 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+type FieldUse struct {
+	Kind   string `json:"kind"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Func   string `json:"func,omitempty"`
+	Code   string `json:"code"`
+	Note   string `json:"note,omitempty"`
+}
+
+type FieldResult struct {
+	Success      bool       `json:"success"`
+	Type         string     `json:"type"`
+	Field        string     `json:"field"`
+	File         string     `json:"file"`
+	Message      string     `json:"message"`
+	FilesChanged []string   `json:"filesChanged"`
+	Updated      []FieldUse `json:"updated,omitempty"`
+	NeedsReview  []FieldUse `json:"needsReview,omitempty"`
+	Warnings     []string   `json:"warnings,omitempty"`
+}
+
+type FieldOptions struct {
+	Tag    string
+	After  string
+	Before string
+}
+
+// fieldTarget is a struct type being edited together with the program it
+// was found in.
+type fieldTarget struct {
+	prog   *program
+	pkg    *loadedPackage
+	file   *ast.File
+	tn     *types.TypeName
+	spec   *ast.TypeSpec
+	st     *ast.StructType
+	stType *types.Struct
+	edits  []textEdit
+	result *FieldResult
+}
+
+func loadFieldTarget(typeName, fieldName string) (*fieldTarget, error) {
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	tn := prog.lookupType(typeName)
+	if tn == nil {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+	pkg, file, spec := prog.typeSpecOf(tn)
+	if spec == nil {
+		return nil, fmt.Errorf("%s is not a struct type", typeName)
+	}
+	st, ok := spec.Type.(*ast.StructType)
+	stType, ok2 := tn.Type().Underlying().(*types.Struct)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("%s is not a struct type", typeName)
+	}
+	return &fieldTarget{
+		prog:   prog,
+		pkg:    pkg,
+		file:   file,
+		tn:     tn,
+		spec:   spec,
+		st:     st,
+		stType: stType,
+		result: &FieldResult{
+			Success: true,
+			Type:    tn.Name(),
+			Field:   fieldName,
+			File:    prog.position(spec.Pos()).Filename,
+			Warnings: siblingWarnings(&SymbolLocation{
+				Name: tn.Name(),
+				File: prog.position(spec.Pos()).Filename,
+			}),
+		},
+	}, nil
+}
+
+// fieldNames returns the names a struct field declares; embedded fields are
+// named after their type.
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) > 0 {
+		var names []string
+		for _, n := range field.Names {
+			names = append(names, n.Name)
+		}
+		return names
+	}
+	t := field.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		t = x.X
+	case *ast.IndexListExpr:
+		t = x.X
+	}
+	if sel, ok := t.(*ast.SelectorExpr); ok {
+		return []string{sel.Sel.Name}
+	}
+	return []string{types.ExprString(t)}
+}
+
+// field finds name in the struct and returns its AST field, the position of
+// name within that field and its index among all struct fields.
+func (t *fieldTarget) field(name string) (*ast.Field, int, int, error) {
+	index := 0
+	for _, field := range t.st.Fields.List {
+		for i, n := range fieldNames(field) {
+			if n == name {
+				return field, i, index, nil
+			}
+			index++
+		}
+	}
+	return nil, 0, 0, fmt.Errorf("%s has no field %s", t.tn.Name(), name)
+}
+
+func (t *fieldTarget) line(pos token.Pos) int {
+	return t.prog.position(pos).Line
+}
+
+// fieldRange is the source of a field on its own lines: doc comment, the
+// field and its trailing comment, through the final newline.
+func (t *fieldTarget) fieldRange(field *ast.Field) (token.Pos, token.Pos, error) {
+	if t.line(field.Pos()) == t.line(t.st.Fields.Opening) || t.line(field.End()) == t.line(t.st.Fields.Closing) {
+		return 0, 0, fmt.Errorf("%s is declared on one line; expand the struct first", t.tn.Name())
+	}
+	start := field.Pos()
+	if field.Doc != nil {
+		start = field.Doc.Pos()
+	}
+	end := field.End()
+	if field.Comment != nil {
+		end = field.Comment.End()
+	}
+	return t.prog.lineStart(start), t.prog.lineEnd(end) + 1, nil
+}
+
+// removeName drops one name from a field declaring several ("A, B int").
+func (t *fieldTarget) removeName(field *ast.Field, i int) {
+	if i < len(field.Names)-1 {
+		t.edits = append(t.edits, t.prog.edit(field.Names[i].Pos(), field.Names[i+1].Pos(), ""))
+	} else {
+		t.edits = append(t.edits, t.prog.edit(field.Names[i-1].End(), field.Names[i].End(), ""))
+	}
+}
+
+// insertLine adds a field line after the field named after, before the one
+// named before, or at the end of the struct.
+func (t *fieldTarget) insertLine(text, after, before string) error {
+	fields := t.st.Fields
+	switch {
+	case after != "":
+		field, _, _, err := t.field(after)
+		if err != nil {
+			return err
+		}
+		end := field.End()
+		if field.Comment != nil {
+			end = field.Comment.End()
+		}
+		t.edits = append(t.edits, t.prog.edit(t.prog.lineEnd(end), t.prog.lineEnd(end), "\n"+text))
+	case before != "":
+		field, _, _, err := t.field(before)
+		if err != nil {
+			return err
+		}
+		start := field.Pos()
+		if field.Doc != nil {
+			start = field.Doc.Pos()
+		}
+		t.edits = append(t.edits, t.prog.edit(t.prog.lineStart(start), t.prog.lineStart(start), text+"\n"))
+	case t.line(fields.Opening) == t.line(fields.Closing):
+		t.edits = append(t.edits, t.prog.edit(fields.Closing, fields.Closing, "\n"+text+"\n"))
+	default:
+		pos := t.prog.lineStart(fields.Closing)
+		t.edits = append(t.edits, t.prog.edit(pos, pos, text+"\n"))
+	}
+	return nil
+}
+
+// insertIndex returns the index among all struct fields that a field
+// inserted by insertLine takes. A line goes after or before the whole
+// field of the name given, which may declare several ("A, B int").
+func (t *fieldTarget) insertIndex(after, before string) (int, error) {
+	if after == "" && before == "" {
+		return t.stType.NumFields(), nil
+	}
+	field, i, index, err := t.field(after + before)
+	if err != nil {
+		return 0, err
+	}
+	first := index - i
+	if after != "" {
+		return first + len(fieldNames(field)), nil
+	}
+	return first, nil
+}
+
+// literals calls visit for every composite literal of the target type.
+func (t *fieldTarget) literals(visit func(pkg *loadedPackage, f *ast.File, lit *ast.CompositeLit)) {
+	for _, pkg := range t.prog.Packages {
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				lit, ok := n.(*ast.CompositeLit)
+				if !ok {
+					return true
+				}
+				if named, ok := pkg.Info.TypeOf(lit).(*types.Named); ok && named.Origin().Obj() == t.tn {
+					visit(pkg, f, lit)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// mismatched reports a positional literal whose length does not match the
+// struct; its values cannot be mapped to fields and are left for review.
+func (t *fieldTarget) mismatched(f *ast.File, lit *ast.CompositeLit) bool {
+	if len(lit.Elts) == t.stType.NumFields() {
+		return false
+	}
+	t.result.NeedsReview = append(t.result.NeedsReview, t.use("positional", f, lit,
+		fmt.Sprintf("literal has %d values, %s has %d fields", len(lit.Elts), t.tn.Name(), t.stType.NumFields())))
+	return true
+}
+
+func positional(lit *ast.CompositeLit) bool {
+	if len(lit.Elts) == 0 {
+		return false
+	}
+	_, keyed := lit.Elts[0].(*ast.KeyValueExpr)
+	return !keyed
+}
+
+// keyedElt returns the index of the element keyed by name, or -1.
+func keyedElt(lit *ast.CompositeLit, name string) int {
+	for i, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// deleteElt removes element i of a composite literal with its comma.
+func (t *fieldTarget) deleteElt(lit *ast.CompositeLit, i int) {
+	var edit textEdit
+	switch {
+	case len(lit.Elts) == 1:
+		edit = t.prog.edit(lit.Lbrace+1, lit.Rbrace, "")
+	case i < len(lit.Elts)-1:
+		edit = t.prog.edit(lit.Elts[i].Pos(), lit.Elts[i+1].Pos(), "")
+	default:
+		edit = t.prog.edit(lit.Elts[i-1].End(), lit.Elts[i].End(), "")
+	}
+	t.edits = append(t.edits, edit)
+}
+
+func (t *fieldTarget) use(kind string, f *ast.File, node ast.Node, note string) FieldUse {
+	pos := t.prog.position(node.Pos())
+	use := FieldUse{
+		Kind:   kind,
+		File:   pos.Filename,
+		Line:   pos.Line,
+		Column: pos.Column,
+		Code:   formatNode(t.prog.Fset, node),
+		Note:   note,
+	}
+	if fn := enclosingFunc(f, node.Pos()); fn != nil {
+		use.Func = funcDeclName(fn)
+	}
+	return use
+}
+
+// selectors calls visit for every selector expression that reads or writes
+// field, including through embedding and generic instances.
+func (t *fieldTarget) selectors(field *types.Var, visit func(f *ast.File, sel *ast.SelectorExpr)) {
+	for _, pkg := range t.prog.Packages {
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				sel, ok := n.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				if s := pkg.Info.Selections[sel]; s != nil && s.Kind() == types.FieldVal {
+					if v, ok := s.Obj().(*types.Var); ok && v.Origin() == field {
+						visit(f, sel)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+func (t *fieldTarget) apply(message string) (*FieldResult, error) {
+	files, err := applyEdits(t.edits)
+	if err != nil {
+		return nil, err
+	}
+	t.result.FilesChanged = files
+	t.result.Message = message
+	if len(t.result.NeedsReview) > 0 {
+		t.result.Message += fmt.Sprintf("; %d use(s) need review", len(t.result.NeedsReview))
+	}
+	return t.result, nil
+}
+
+// zeroValue returns the zero value of t as source text. Struct and array
+// zero values need typeExpr, which is only valid where it was written;
+// named reports that case.
+func zeroValue(t types.Type, typeExpr string) (value string, named bool) {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false", false
+		case u.Info()&types.IsString != 0:
+			return `""`, false
+		case u.Info()&types.IsNumeric != 0:
+			return "0", false
+		}
+		return "nil", false
+	case *types.Struct, *types.Array:
+		return typeExpr + "{}", true
+	}
+	return "nil", false
+}
+
+func fieldLine(name, typ, tag string) string {
+	line := name + " " + typ
+	if tag != "" {
+		if !strings.HasPrefix(tag, "`") {
+			tag = "`" + tag + "`"
+		}
+		line += " " + tag
+	}
+	return line
+}
+
+// AddField adds a field to a struct. Positional composite literals of the
+// type get the zero value of the new field inserted.
+func AddField(typeName, name, typ string, opts *FieldOptions) (*FieldResult, error) {
+	if opts == nil {
+		opts = &FieldOptions{}
+	}
+	t, err := loadFieldTarget(typeName, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := t.field(name); err == nil {
+		return nil, fmt.Errorf("%s already has a field %s", typeName, name)
+	}
+	tv, err := types.Eval(t.prog.Fset, t.pkg.Types, t.spec.Pos(), typ)
+	if err != nil || !tv.IsType() {
+		return nil, fmt.Errorf("invalid type %s: %v", typ, err)
+	}
+
+	if err := t.insertLine(fieldLine(name, typ, opts.Tag), opts.After, opts.Before); err != nil {
+		return nil, err
+	}
+
+	index, err := t.insertIndex(opts.After, opts.Before)
+	if err != nil {
+		return nil, err
+	}
+
+	zero, needsType := zeroValue(tv.Type, typ)
+	declFile := t.prog.position(t.spec.Pos()).Filename
+	t.literals(func(pkg *loadedPackage, f *ast.File, lit *ast.CompositeLit) {
+		if !positional(lit) || t.mismatched(f, lit) {
+			return
+		}
+		if needsType && t.prog.position(lit.Pos()).Filename != declFile {
+			t.result.NeedsReview = append(t.result.NeedsReview, t.use("positional", f, lit,
+				fmt.Sprintf("add a value for %s at position %d", name, index+1)))
+			return
+		}
+		if index < len(lit.Elts) {
+			t.edits = append(t.edits, t.prog.edit(lit.Elts[index].Pos(), lit.Elts[index].Pos(), zero+", "))
+		} else {
+			last := lit.Elts[len(lit.Elts)-1]
+			t.edits = append(t.edits, t.prog.edit(last.End(), last.End(), ", "+zero))
+		}
+		t.result.Updated = append(t.result.Updated, t.use("positional", f, lit, "inserted "+zero))
+	})
+
+	return t.apply(fmt.Sprintf("added field %s %s to %s", name, typ, typeName))
+}
+
+// RemoveField deletes a field from a struct and its values from keyed and
+// positional composite literals. Selector uses cannot be fixed
+// mechanically and are reported.
+func RemoveField(typeName, name string) (*FieldResult, error) {
+	t, err := loadFieldTarget(typeName, name)
+	if err != nil {
+		return nil, err
+	}
+	field, nameIndex, index, err := t.field(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(field.Names) > 1 {
+		t.removeName(field, nameIndex)
+	} else {
+		start, end, err := t.fieldRange(field)
+		if err != nil {
+			return nil, err
+		}
+		t.edits = append(t.edits, t.prog.edit(start, end, ""))
+	}
+
+	t.literals(func(pkg *loadedPackage, f *ast.File, lit *ast.CompositeLit) {
+		i := keyedElt(lit, name)
+		kind := "keyed"
+		if positional(lit) {
+			if t.mismatched(f, lit) {
+				return
+			}
+			i, kind = index, "positional"
+		}
+		if i < 0 || i >= len(lit.Elts) {
+			return
+		}
+		elt := lit.Elts[i]
+		note := ""
+		if hasCall([]ast.Expr{elt}) {
+			note = "removed value contained a call; check for lost side effects"
+		}
+		t.deleteElt(lit, i)
+		t.result.Updated = append(t.result.Updated, t.use(kind, f, elt, note))
+	})
+
+	fieldVar := t.stType.Field(index)
+	t.selectors(fieldVar, func(f *ast.File, sel *ast.SelectorExpr) {
+		t.result.NeedsReview = append(t.result.NeedsReview, t.use("selector", f, sel,
+			fmt.Sprintf("%s.%s no longer exists", typeName, name)))
+	})
+
+	return t.apply(fmt.Sprintf("removed field %s from %s", name, typeName))
+}
+
+// MoveField moves a field after or before another one. Positional
+// composite literals are reordered to match.
+func MoveField(typeName, name string, opts *FieldOptions) (*FieldResult, error) {
+	if opts == nil || (opts.After == "") == (opts.Before == "") {
+		return nil, fmt.Errorf("field move needs exactly one of --after or --before")
+	}
+	t, err := loadFieldTarget(typeName, name)
+	if err != nil {
+		return nil, err
+	}
+	field, nameIndex, from, err := t.field(name)
+	if err != nil {
+		return nil, err
+	}
+	ref := opts.After + opts.Before
+	if ref == name {
+		return nil, fmt.Errorf("cannot move %s relative to itself", name)
+	}
+	to, err := t.insertIndex(opts.After, opts.Before)
+	if err != nil {
+		return nil, err
+	}
+
+	var text string
+	if len(field.Names) > 1 {
+		tag := ""
+		if field.Tag != nil {
+			tag = field.Tag.Value
+		}
+		text = fieldLine(name, formatNode(t.prog.Fset, field.Type), tag)
+		t.removeName(field, nameIndex)
+	} else {
+		start, end, err := t.fieldRange(field)
+		if err != nil {
+			return nil, err
+		}
+		text = strings.TrimSuffix(t.prog.text(start, end), "\n")
+		t.edits = append(t.edits, t.prog.edit(start, end, ""))
+	}
+	if err := t.insertLine(text, opts.After, opts.Before); err != nil {
+		return nil, err
+	}
+
+	// order[i] is the old index of the field that ends up at position i.
+	n := t.stType.NumFields()
+	var order []int
+	for i := 0; i <= n; i++ {
+		if i == to {
+			order = append(order, from)
+		}
+		if i < n && i != from {
+			order = append(order, i)
+		}
+	}
+
+	t.literals(func(pkg *loadedPackage, f *ast.File, lit *ast.CompositeLit) {
+		if !positional(lit) || t.mismatched(f, lit) {
+			return
+		}
+		sep := ", "
+		if t.line(lit.Lbrace) != t.line(lit.Rbrace) && t.line(lit.Elts[0].Pos()) != t.line(lit.Lbrace) {
+			sep = ",\n"
+		}
+		var parts []string
+		for _, i := range order {
+			parts = append(parts, t.prog.text(lit.Elts[i].Pos(), lit.Elts[i].End()))
+		}
+		t.edits = append(t.edits, t.prog.edit(lit.Elts[0].Pos(), lit.Elts[n-1].End(), strings.Join(parts, sep)))
+		t.result.Updated = append(t.result.Updated, t.use("positional", f, lit, "reordered"))
+	})
+
+	where := "after " + opts.After
+	if opts.Before != "" {
+		where = "before " + opts.Before
+	}
+	return t.apply(fmt.Sprintf("moved field %s of %s %s", name, typeName, where))
+}
+
+// RetypeField changes the type of a field. Literal values that are not
+// assignable to the new type and all selector uses are reported.
+func RetypeField(typeName, name, typ string) (*FieldResult, error) {
+	t, err := loadFieldTarget(typeName, name)
+	if err != nil {
+		return nil, err
+	}
+	field, nameIndex, index, err := t.field(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(field.Names) == 0 {
+		return nil, fmt.Errorf("%s is an embedded field; replace it with field add/remove", name)
+	}
+	tv, err := types.Eval(t.prog.Fset, t.pkg.Types, t.spec.Pos(), typ)
+	if err != nil || !tv.IsType() {
+		return nil, fmt.Errorf("invalid type %s: %v", typ, err)
+	}
+	fieldVar := t.stType.Field(index)
+	oldType := types.TypeString(fieldVar.Type(), types.RelativeTo(t.pkg.Types))
+	if types.Identical(fieldVar.Type(), tv.Type) {
+		return nil, fmt.Errorf("%s.%s already has type %s", typeName, name, typ)
+	}
+
+	if len(field.Names) > 1 {
+		tag := ""
+		if field.Tag != nil {
+			tag = field.Tag.Value
+		}
+		// The field keeps its place: first or last of the names.
+		var after, before string
+		switch nameIndex {
+		case 0:
+			before = name
+		case len(field.Names) - 1:
+			after = name
+		default:
+			return nil, fmt.Errorf("%s is declared between %s and %s; split the field first", name, field.Names[nameIndex-1].Name, field.Names[nameIndex+1].Name)
+		}
+		t.removeName(field, nameIndex)
+		if err := t.insertLine(fieldLine(name, typ, tag), after, before); err != nil {
+			return nil, err
+		}
+	} else {
+		t.edits = append(t.edits, t.prog.edit(field.Type.Pos(), field.Type.End(), typ))
+	}
+
+	t.literals(func(pkg *loadedPackage, f *ast.File, lit *ast.CompositeLit) {
+		var value ast.Expr
+		kind := "keyed"
+		if positional(lit) {
+			if t.mismatched(f, lit) {
+				return
+			}
+			value, kind = lit.Elts[index], "positional"
+		} else if i := keyedElt(lit, name); i >= 0 {
+			value = lit.Elts[i].(*ast.KeyValueExpr).Value
+		}
+		if value == nil {
+			return
+		}
+		if assignableValue(pkg.Info, value, tv.Type) {
+			return
+		}
+		t.result.NeedsReview = append(t.result.NeedsReview, t.use(kind, f, value,
+			fmt.Sprintf("value is not assignable to %s", typ)))
+	})
+
+	t.selectors(fieldVar, func(f *ast.File, sel *ast.SelectorExpr) {
+		t.result.NeedsReview = append(t.result.NeedsReview, t.use("selector", f, sel,
+			fmt.Sprintf("type changed from %s to %s", oldType, typ)))
+	})
+
+	return t.apply(fmt.Sprintf("changed type of %s.%s from %s to %s", typeName, name, oldType, typ))
+}
+
+// assignableValue reports whether value may be assigned to t. Literal
+// constants are recorded with the type of the old field, so they are
+// checked by kind instead.
+func assignableValue(info *types.Info, value ast.Expr, t types.Type) bool {
+	tv, ok := info.Types[value]
+	if !ok {
+		return false
+	}
+	lit := unparen(value)
+	if u, ok := lit.(*ast.UnaryExpr); ok {
+		lit = u.X
+	}
+	if _, ok := lit.(*ast.BasicLit); !ok || tv.Value == nil {
+		return types.AssignableTo(tv.Type, t)
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	switch tv.Value.Kind() {
+	case constant.Bool:
+		return b.Info()&types.IsBoolean != 0
+	case constant.String:
+		return b.Info()&types.IsString != 0
+	case constant.Int:
+		return b.Info()&types.IsNumeric != 0
+	case constant.Float:
+		return b.Info()&(types.IsFloat|types.IsComplex) != 0 ||
+			b.Info()&types.IsInteger != 0 && constant.ToInt(tv.Value).Kind() == constant.Int
+	case constant.Complex:
+		return b.Info()&types.IsComplex != 0
+	}
+	return false
+}
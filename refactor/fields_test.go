@@ -0,0 +1,128 @@
+package refactor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const fieldsModule = `package app
+
+type User struct {
+	ID   int
+	Name string
+}
+
+var admin = User{1, "admin"}
+
+var guest = &User{
+	ID:   2,
+	Name: "guest",
+}
+
+func name(u User) string { return u.Name }
+`
+
+func readModuleFile(t *testing.T, dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddField(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": fieldsModule})
+	chdir(t, dir)
+
+	result, err := refactor.AddField("User", "Active", "bool", &refactor.FieldOptions{After: "ID", Tag: `json:"active"`})
+	if err != nil {
+		t.Fatalf("AddField error: %v", err)
+	}
+	if len(result.Updated) != 1 {
+		t.Errorf("expected one positional literal updated, got %+v", result.Updated)
+	}
+	src := readModuleFile(t, dir, "app.go")
+	for _, want := range []string{"Active bool `json:\"active\"`", `User{1, false, "admin"}`} {
+		if !strings.Contains(src, want) {
+			t.Errorf("expected %q in:\n%s", want, src)
+		}
+	}
+}
+
+func TestRemoveField(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": fieldsModule})
+	chdir(t, dir)
+
+	result, err := refactor.RemoveField("User", "Name")
+	if err != nil {
+		t.Fatalf("RemoveField error: %v", err)
+	}
+	if len(result.Updated) != 2 {
+		t.Errorf("expected keyed and positional literals updated, got %+v", result.Updated)
+	}
+	if len(result.NeedsReview) != 1 || result.NeedsReview[0].Kind != "selector" || result.NeedsReview[0].Func != "name" {
+		t.Errorf("expected u.Name reported, got %+v", result.NeedsReview)
+	}
+	src := readModuleFile(t, dir, "app.go")
+	if strings.Contains(src, `"admin"`) || strings.Contains(src, `"guest"`) || strings.Contains(src, "Name string") {
+		t.Errorf("field not fully removed:\n%s", src)
+	}
+}
+
+func TestMoveAndRetypeField(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": fieldsModule})
+	chdir(t, dir)
+
+	if _, err := refactor.MoveField("User", "Name", &refactor.FieldOptions{Before: "ID"}); err != nil {
+		t.Fatalf("MoveField error: %v", err)
+	}
+	src := readModuleFile(t, dir, "app.go")
+	if !strings.Contains(src, `User{"admin", 1}`) || strings.Index(src, "Name string") > strings.Index(src, "ID   int") {
+		t.Errorf("field not moved:\n%s", src)
+	}
+
+	result, err := refactor.RetypeField("User", "ID", "int64")
+	if err != nil {
+		t.Fatalf("RetypeField error: %v", err)
+	}
+	if len(result.NeedsReview) != 0 {
+		t.Errorf("constants stay assignable, got %+v", result.NeedsReview)
+	}
+	result, err = refactor.RetypeField("User", "ID", "string")
+	if err != nil {
+		t.Fatalf("RetypeField error: %v", err)
+	}
+	if len(result.NeedsReview) != 2 {
+		t.Errorf("expected both literal values reported, got %+v", result.NeedsReview)
+	}
+}
+
+func TestMultiNameField(t *testing.T) {
+	src := "package app\n\ntype Point struct {\n\tX, Y int\n\tZ    int\n}\n\nvar origin = Point{1, 2, 3}\n"
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": src})
+	chdir(t, dir)
+
+	// The line goes after "X, Y int", so the value goes after Y's.
+	if _, err := refactor.AddField("Point", "W", "int", &refactor.FieldOptions{After: "X"}); err != nil {
+		t.Fatalf("AddField error: %v", err)
+	}
+	if src := readModuleFile(t, dir, "app.go"); !strings.Contains(src, "Point{1, 2, 0, 3}") {
+		t.Errorf("expected the zero value after Y's:\n%s", src)
+	}
+	if _, err := refactor.MoveField("Point", "Z", &refactor.FieldOptions{Before: "Y"}); err != nil {
+		t.Fatalf("MoveField error: %v", err)
+	}
+	if src := readModuleFile(t, dir, "app.go"); !strings.Contains(src, "Point{3, 1, 2, 0}") {
+		t.Errorf("expected Z's value before X's:\n%s", src)
+	}
+	if _, err := refactor.RetypeField("Point", "X", "int64"); err != nil {
+		t.Fatalf("RetypeField error: %v", err)
+	}
+	if src := readModuleFile(t, dir, "app.go"); !strings.Contains(src, "X int64\n\tY int\n") {
+		t.Errorf("expected X to keep its place before Y:\n%s", src)
+	}
+}
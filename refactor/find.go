@@ -22,6 +22,13 @@ type SymbolLocation struct {
 	Value     string `json:"value,omitempty"`
 	Type      string `json:"type,omitempty"`
 	Parent    string `json:"parent,omitempty"`
+	// Constraint is the build constraint of the file, from its //go:build
+	// line and GOOS/GOARCH name suffix.
+	Constraint string `json:"constraint,omitempty"`
+	// Generated is set for files marked "Code generated ... DO NOT EDIT.",
+	// with the //go:generate directive producing them, if found.
+	Generated bool   `json:"generated,omitempty"`
+	Generator string `json:"generator,omitempty"`
 }
 
 type FindResult struct {
@@ -109,7 +116,15 @@ func FindField(name, dir string) (*FindResult, error) {
 	}, nil
 }
 
+// searchSymbols finds the symbols matching name in the files under dir
+// that the build configuration includes.
 func searchSymbols(name, dir, kindFilter string) ([]SymbolLocation, error) {
+	return scanSymbols(name, dir, kindFilter, true)
+}
+
+// scanSymbols is searchSymbols that, with matchBuild unset, also searches
+// files the build configuration excludes.
+func scanSymbols(name, dir, kindFilter string, matchBuild bool) ([]SymbolLocation, error) {
 	var matches []SymbolLocation
 
 	absDir, err := filepath.Abs(dir)
@@ -129,15 +144,20 @@ func searchSymbols(name, dir, kindFilter string) ([]SymbolLocation, error) {
 			}
 			return nil
 		}
-		if !strings.HasSuffix(path, ".go") {
+		if !strings.HasSuffix(path, ".go") || (matchBuild && !buildMatches(path)) {
 			return nil
 		}
 
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
 		fset := token.NewFileSet()
-		file, err := parser.ParseFile(fset, path, nil, 0)
+		file, err := parser.ParseFile(fset, path, src, 0)
 		if err != nil {
 			return nil
 		}
+		start := len(matches)
 
 		for _, decl := range file.Decls {
 			switch d := decl.(type) {
@@ -247,6 +267,12 @@ func searchSymbols(name, dir, kindFilter string) ([]SymbolLocation, error) {
 				}
 			}
 		}
+		if len(matches) > start {
+			meta := fileMetaOf(path, src)
+			for i := start; i < len(matches); i++ {
+				meta.annotate(&matches[i])
+			}
+		}
 		return nil
 	})
 
@@ -24,46 +24,43 @@ func formatFuncSignature(fn *ast.FuncDecl) string {
 	}
 	buf.WriteString(fn.Name.Name)
 	buf.WriteString("(")
-
-	var params []string
-	if fn.Type.Params != nil {
-		for _, p := range fn.Type.Params.List {
-			ptype := formatExpr(p.Type)
-			if len(p.Names) == 0 {
-				params = append(params, ptype)
-			} else {
-				for _, n := range p.Names {
-					params = append(params, n.Name+" "+ptype)
-				}
-			}
-		}
-	}
-	buf.WriteString(strings.Join(params, ", "))
+	buf.WriteString(strings.Join(formatFields(fn.Type.Params, formatExpr), ", "))
 	buf.WriteString(")")
+	buf.WriteString(formatResults(fn.Type.Results, formatExpr))
+	return buf.String()
+}
 
-	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
-		buf.WriteString(" ")
-		if len(fn.Type.Results.List) == 1 && len(fn.Type.Results.List[0].Names) == 0 {
-			buf.WriteString(formatExpr(fn.Type.Results.List[0].Type))
-		} else {
-			buf.WriteString("(")
-			var results []string
-			for _, r := range fn.Type.Results.List {
-				rtype := formatExpr(r.Type)
-				if len(r.Names) == 0 {
-					results = append(results, rtype)
-				} else {
-					for _, n := range r.Names {
-						results = append(results, n.Name+" "+rtype)
-					}
-				}
-			}
-			buf.WriteString(strings.Join(results, ", "))
-			buf.WriteString(")")
+// formatFields lists a parameter or result list one name at a time, as
+// "id int", or just the type for unnamed entries. typeText formats the
+// types: formatExpr for summaries, the source text to rewrite code.
+func formatFields(fields *ast.FieldList, typeText func(ast.Expr) string) []string {
+	var list []string
+	if fields == nil {
+		return nil
+	}
+	for _, f := range fields.List {
+		ftype := typeText(f.Type)
+		if len(f.Names) == 0 {
+			list = append(list, ftype)
+			continue
+		}
+		for _, n := range f.Names {
+			list = append(list, n.Name+" "+ftype)
 		}
 	}
+	return list
+}
 
-	return buf.String()
+// formatResults formats a result list as it follows the parameters of a
+// signature, with its leading space: " error", " (int, error)" or "".
+func formatResults(results *ast.FieldList, typeText func(ast.Expr) string) string {
+	if results == nil || len(results.List) == 0 {
+		return ""
+	}
+	if len(results.List) == 1 && len(results.List[0].Names) == 0 {
+		return " " + typeText(results.List[0].Type)
+	}
+	return " (" + strings.Join(formatFields(results, typeText), ", ") + ")"
 }
 
 func formatSource(src []byte) ([]byte, error) {
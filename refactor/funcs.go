@@ -68,9 +68,10 @@ func ReadFunc(name, file string) (*ReadFuncResult, error) {
 }
 
 type ModifyResult struct {
-	Success bool   `json:"success"`
-	File    string `json:"file"`
-	Message string `json:"message"`
+	Success  bool     `json:"success"`
+	File     string   `json:"file"`
+	Message  string   `json:"message"`
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 func ReplaceFunc(name, file string, newCode io.Reader) (*ModifyResult, error) {
@@ -126,6 +127,9 @@ func ReplaceFunc(name, file string, newCode io.Reader) (*ModifyResult, error) {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -188,6 +192,9 @@ func DeleteFunc(name, file string) (*ModifyResult, error) {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -221,6 +228,9 @@ func AddFunc(file string, newCode io.Reader) (*ModifyResult, error) {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -249,6 +259,9 @@ func MoveFunc(name, dstFile, srcFile string) (*ModifyResult, error) {
 		return nil, err
 	}
 
+	if err := checkWritable(dstFile); err != nil {
+		return nil, err
+	}
 	if _, err := DeleteFunc(name, srcFile); err != nil {
 		return nil, err
 	}
@@ -497,6 +510,9 @@ func ReplaceVarConst(name, file string, newCode io.Reader) (*ModifyResult, error
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -532,6 +548,8 @@ func DeleteVarConst(name, file string) (*ModifyResult, error) {
 	}
 
 	var targetDecl *ast.GenDecl
+	var targetSpec *ast.ValueSpec
+	var targetIdent *ast.Ident
 	for _, decl := range f.Decls {
 		genDecl, ok := decl.(*ast.GenDecl)
 		if !ok || (genDecl.Tok != token.VAR && genDecl.Tok != token.CONST) {
@@ -543,6 +561,8 @@ func DeleteVarConst(name, file string) (*ModifyResult, error) {
 				for _, ident := range valueSpec.Names {
 					if ident.Name == name {
 						targetDecl = genDecl
+						targetSpec = valueSpec
+						targetIdent = ident
 						break
 					}
 				}
@@ -554,22 +574,51 @@ func DeleteVarConst(name, file string) (*ModifyResult, error) {
 		return nil, fmt.Errorf("var/const %s not found in %s", name, file)
 	}
 
-	startPos := fset.Position(targetDecl.Pos()).Offset
-	endPos := fset.Position(targetDecl.End()).Offset
-
-	for endPos < len(src) && (src[endPos] == '\n' || src[endPos] == '\r') {
-		endPos++
+	// Inside a group only the spec goes away. Where removing it would shift
+	// iota or break implicit repetition of later specs, or where the spec
+	// declares several names, the name is blanked instead.
+	var start, end token.Pos
+	blank := len(targetSpec.Names) > 1
+	if len(targetDecl.Specs) == 1 && !blank {
+		start, end = targetDecl.Pos(), targetDecl.End()
+	} else {
+		start, end = targetSpec.Pos(), targetSpec.End()
+		if targetSpec.Doc != nil {
+			start = targetSpec.Doc.Pos()
+		}
+		if targetDecl.Tok == token.CONST {
+			for _, spec := range targetDecl.Specs {
+				if vs := spec.(*ast.ValueSpec); len(vs.Values) == 0 {
+					blank = true
+				}
+			}
+		}
 	}
 
 	var result []byte
-	result = append(result, src[:startPos]...)
-	result = append(result, src[endPos:]...)
+	if blank {
+		offset := fset.Position(targetIdent.Pos()).Offset
+		result = append(result, src[:offset]...)
+		result = append(result, '_')
+		result = append(result, src[offset+len(name):]...)
+	} else {
+		startPos := fset.Position(start).Offset
+		endPos := fset.Position(end).Offset
+		for endPos < len(src) && (src[endPos] == '\n' || src[endPos] == '\r') {
+			endPos++
+		}
+		result = append(result, src[:startPos]...)
+		result = append(result, src[endPos:]...)
+	}
 
 	formatted, err := formatSource(result)
 	if err != nil {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -598,6 +647,9 @@ func MoveVarConst(name, dstFile, srcFile string) (*ModifyResult, error) {
 		return nil, err
 	}
 
+	if err := checkWritable(dstFile); err != nil {
+		return nil, err
+	}
 	if _, err := DeleteVarConst(name, srcFile); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,456 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+)
+
+type GenResult struct {
+	Success      bool     `json:"success"`
+	Type         string   `json:"type"`
+	File         string   `json:"file"`
+	Message      string   `json:"message"`
+	Generated    []string `json:"generated"`
+	Skipped      []string `json:"skipped,omitempty"`
+	Code         string   `json:"code,omitempty"`
+	FilesChanged []string `json:"filesChanged,omitempty"`
+}
+
+// genTarget is a named type that code is generated for. Generated
+// declarations are collected in code and inserted right after the type.
+type genTarget struct {
+	prog    *program
+	pkg     *loadedPackage
+	file    *ast.File
+	tn      *types.TypeName
+	decl    *ast.GenDecl
+	spec    *ast.TypeSpec
+	recv    string // receiver name used by existing methods
+	tparams string // "[K comparable, V any]", empty for non-generic types
+	targs   string // "[K, V]"
+	code    []string
+	result  *GenResult
+}
+
+func loadGenTarget(typeName string) (*genTarget, error) {
+	prog, err := loadProgram(".", false)
+	if err != nil {
+		return nil, err
+	}
+	tn := prog.lookupType(typeName)
+	if tn == nil {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+	pkg, file, spec := prog.typeSpecOf(tn)
+	if spec == nil {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+
+	g := &genTarget{
+		prog: prog,
+		pkg:  pkg,
+		file: file,
+		tn:   tn,
+		spec: spec,
+		result: &GenResult{
+			Success: true,
+			Type:    tn.Name(),
+			File:    prog.position(spec.Pos()).Filename,
+		},
+	}
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Pos() <= spec.Pos() && spec.End() <= gd.End() {
+			g.decl = gd
+		}
+	}
+	if spec.TypeParams != nil {
+		g.tparams = "[" + prog.text(spec.TypeParams.Opening+1, spec.TypeParams.Closing) + "]"
+		var names []string
+		for _, field := range spec.TypeParams.List {
+			for _, n := range field.Names {
+				names = append(names, n.Name)
+			}
+		}
+		g.targs = "[" + strings.Join(names, ", ") + "]"
+	}
+
+	// Reuse the receiver name of existing methods.
+	prog.funcDecls(func(p *loadedPackage, _ *ast.File, fn *ast.FuncDecl) {
+		if g.recv != "" || p != pkg || fn.Recv == nil || len(fn.Recv.List) == 0 || len(fn.Recv.List[0].Names) == 0 {
+			return
+		}
+		if recvBaseName(fn.Recv.List[0].Type) == tn.Name() && fn.Recv.List[0].Names[0].Name != "_" {
+			g.recv = fn.Recv.List[0].Names[0].Name
+		}
+	})
+	if g.recv == "" {
+		g.recv = strings.ToLower(string([]rune(tn.Name())[0]))
+	}
+	return g, nil
+}
+
+func recvBaseName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		expr = x.X
+	case *ast.IndexListExpr:
+		expr = x.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// typeRef is the type as written in a receiver or result: "T" or "T[K, V]".
+func (g *genTarget) typeRef() string {
+	return g.tn.Name() + g.targs
+}
+
+// hasMethod reports whether T or *T already has a method or field name.
+func (g *genTarget) hasMethod(name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(g.tn.Type()), false, g.pkg.Types, name)
+	return obj != nil
+}
+
+// hasFunc reports whether the package already declares name.
+func (g *genTarget) hasFunc(name string) bool {
+	return g.pkg.Types.Scope().Lookup(name) != nil
+}
+
+// emit queues generated code unless name already exists, in which case it
+// is reported as skipped.
+func (g *genTarget) emit(name string, exists bool, code string) {
+	if exists {
+		g.result.Skipped = append(g.result.Skipped, name)
+		return
+	}
+	g.result.Generated = append(g.result.Generated, name)
+	g.code = append(g.code, code)
+}
+
+// structFields returns the named fields of the struct, optionally limited
+// to names.
+func (g *genTarget) structFields(names []string) ([]*genField, error) {
+	st, ok := g.spec.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct type", g.tn.Name())
+	}
+	stType := g.tn.Type().Underlying().(*types.Struct)
+
+	var fields []*genField
+	index := 0
+	for _, field := range st.Fields.List {
+		for _, name := range fieldNames(field) {
+			f := &genField{
+				Name:     name,
+				Type:     g.prog.text(field.Type.Pos(), field.Type.End()),
+				Var:      stType.Field(index),
+				Embedded: len(field.Names) == 0,
+			}
+			index++
+			if f.Embedded || name == "_" {
+				continue
+			}
+			if len(names) == 0 || contains(names, name) {
+				fields = append(fields, f)
+			}
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, f := range fields {
+			found = found || f.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("%s has no field %s", g.tn.Name(), name)
+		}
+	}
+	return fields, nil
+}
+
+// genField is a struct field with its type as written in the source.
+type genField struct {
+	Name     string
+	Type     string
+	Var      *types.Var
+	Embedded bool
+}
+
+// isDependency reports whether a field holds something a constructor must
+// be given: pointers, interfaces, funcs and channels have no usable zero
+// value.
+func (f *genField) isDependency() bool {
+	switch f.Var.Type().Underlying().(type) {
+	case *types.Pointer, *types.Interface, *types.Signature, *types.Chan:
+		return true
+	}
+	return false
+}
+
+// commonInitialisms are kept upper case in generated names, as golint does.
+var commonInitialisms = map[string]bool{
+	"API": true, "ASCII": true, "CPU": true, "CSS": true, "DB": true, "DNS": true, "EOF": true,
+	"GUID": true, "HTML": true, "HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true,
+	"LHS": true, "QPS": true, "RAM": true, "RHS": true, "RPC": true, "SQL": true, "SSH": true,
+	"TCP": true, "TLS": true, "TTL": true, "UDP": true, "UI": true, "UID": true, "URI": true,
+	"URL": true, "UTF8": true, "UUID": true, "XML": true,
+}
+
+// exportedName turns a field name into an exported identifier: "db" -> "DB",
+// "userID" -> "UserID".
+func exportedName(name string) string {
+	words := splitWords(name)
+	for i, w := range words {
+		if up := strings.ToUpper(w); commonInitialisms[up] {
+			words[i] = up
+		} else {
+			r := []rune(w)
+			r[0] = unicode.ToUpper(r[0])
+			words[i] = string(r)
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// paramName turns a field name into a parameter name that is not a keyword.
+func paramName(name string) string {
+	words := splitWords(name)
+	words[0] = strings.ToLower(words[0])
+	n := strings.Join(words, "")
+	if token.IsKeyword(n) {
+		n += "_"
+	}
+	return n
+}
+
+// anchor returns where generated code goes: right after the type, or with
+// afterRelated set, after the declarations that directly follow the type and
+// belong to it (methods, constructors, option types and funcs).
+func (g *genTarget) anchor(afterRelated bool) token.Pos {
+	end := g.decl.End()
+	if !afterRelated {
+		return end
+	}
+	for i, decl := range g.file.Decls {
+		if decl != g.decl {
+			continue
+		}
+		for _, next := range g.file.Decls[i+1:] {
+			if !g.related(next) {
+				break
+			}
+			end = next.End()
+		}
+	}
+	return end
+}
+
+func (g *genTarget) related(decl ast.Decl) bool {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return recvBaseName(d.Recv.List[0].Type) == g.tn.Name()
+		}
+		fn, ok := g.pkg.Info.Defs[d.Name].(*types.Func)
+		if !ok {
+			return false
+		}
+		results := fn.Type().(*types.Signature).Results()
+		for i := 0; i < results.Len(); i++ {
+			if g.mentions(results.At(i).Type()) {
+				return true
+			}
+		}
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			if ts, ok := spec.(*ast.TypeSpec); ok {
+				if obj := g.pkg.Info.Defs[ts.Name]; obj != nil && g.mentions(obj.Type()) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// mentions reports whether t is the target type, a pointer to it, or a
+// func type over it, such as an option type.
+func (g *genTarget) mentions(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok && named.Origin().Obj() == g.tn {
+		return true
+	}
+	if sig, ok := t.Underlying().(*types.Signature); ok {
+		for i := 0; i < sig.Params().Len(); i++ {
+			if g.mentions(sig.Params().At(i).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply inserts the generated code after the type declaration.
+func (g *genTarget) apply(what string, afterRelated bool) (*GenResult, error) {
+	if len(g.code) == 0 {
+		g.result.Message = "nothing to generate"
+		if len(g.result.Skipped) > 0 {
+			g.result.Message += ": skipped " + strings.Join(g.result.Skipped, ", ")
+		}
+		return g.result, nil
+	}
+	code := strings.Join(g.code, "\n\n")
+	g.result.Code = code
+
+	pos := g.prog.lineEnd(g.anchor(afterRelated))
+	files, err := applyEdits([]textEdit{g.prog.edit(pos, pos, "\n\n"+code+"\n")})
+	if err != nil {
+		return nil, err
+	}
+	g.result.FilesChanged = files
+	g.result.Message = fmt.Sprintf("generated %s for %s: %s", what, g.tn.Name(), strings.Join(g.result.Generated, ", "))
+	return g.result, nil
+}
+
+// GenConstructor generates New<Type>. Its parameters are the given fields
+// or, by default, the fields without a usable zero value (pointers,
+// interfaces, funcs, channels). Maps not passed in are made.
+func GenConstructor(typeName string, fields []string) (*GenResult, error) {
+	g, err := loadGenTarget(typeName)
+	if err != nil {
+		return nil, err
+	}
+	all, err := g.structFields(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range fields {
+		if _, err := g.structFields([]string{name}); err != nil {
+			return nil, err
+		}
+	}
+
+	var params, elts []string
+	for _, f := range all {
+		p := paramName(f.Name)
+		switch {
+		case len(fields) > 0 && contains(fields, f.Name), len(fields) == 0 && f.isDependency():
+			params = append(params, p+" "+f.Type)
+			elts = append(elts, fmt.Sprintf("%s: %s,", f.Name, p))
+		default:
+			if _, ok := f.Var.Type().Underlying().(*types.Map); ok {
+				elts = append(elts, fmt.Sprintf("%s: make(%s),", f.Name, f.Type))
+			}
+		}
+	}
+
+	name := "New" + exportedName(g.tn.Name())
+	if !g.tn.Exported() {
+		name = "new" + exportedName(g.tn.Name())
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s returns a new %s.\n", name, g.tn.Name())
+	fmt.Fprintf(&b, "func %s%s(%s) *%s {\n", name, g.tparams, strings.Join(params, ", "), g.typeRef())
+	if len(elts) == 0 {
+		fmt.Fprintf(&b, "\treturn &%s{}\n}", g.typeRef())
+	} else {
+		fmt.Fprintf(&b, "\treturn &%s{\n\t\t%s\n\t}\n}", g.typeRef(), strings.Join(elts, "\n\t\t"))
+	}
+	g.emit(name, g.hasFunc(name), b.String())
+	return g.apply("constructor", false)
+}
+
+// GenAccessors generates getters and setters for the given fields, by
+// default every unexported field. Exported fields need no accessors and
+// are skipped.
+func GenAccessors(typeName string, fields []string) (*GenResult, error) {
+	g, err := loadGenTarget(typeName)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := g.structFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range selected {
+		if ast.IsExported(f.Name) {
+			g.result.Skipped = append(g.result.Skipped, f.Name)
+			continue
+		}
+		getter := exportedName(f.Name)
+		setter := "Set" + getter
+		p := paramName(f.Name)
+		if p == g.recv {
+			p = "v"
+		}
+		g.emit(getter, g.hasMethod(getter), fmt.Sprintf("// %s returns the %s of the %s.\nfunc (%s *%s) %s() %s {\n\treturn %s.%s\n}",
+			getter, f.Name, g.tn.Name(), g.recv, g.typeRef(), getter, f.Type, g.recv, f.Name))
+		g.emit(setter, g.hasMethod(setter), fmt.Sprintf("// %s sets the %s of the %s.\nfunc (%s *%s) %s(%s %s) {\n\t%s.%s = %s\n}",
+			setter, f.Name, g.tn.Name(), g.recv, g.typeRef(), setter, p, f.Type, g.recv, f.Name, p))
+	}
+	return g.apply("accessors", true)
+}
+
+// GenOptions generates functional options: an Option func type and a With
+// function per field. If the package already has an Option type for
+// another struct, the type is named <Type>Option.
+func GenOptions(typeName string, fields []string) (*GenResult, error) {
+	g, err := loadGenTarget(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if g.tparams != "" {
+		return nil, fmt.Errorf("functional options for generic type %s are not supported", typeName)
+	}
+	selected, err := g.structFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// The names are checked as they will be declared: lowercase for an
+	// unexported type.
+	optName := func(name string) string {
+		if !g.tn.Exported() {
+			return strings.ToLower(name[:1]) + name[1:]
+		}
+		return name
+	}
+	want := types.NewSignatureType(nil, nil, nil,
+		types.NewTuple(types.NewVar(token.NoPos, nil, "", types.NewPointer(g.tn.Type()))), nil, false)
+	isOption := func(obj types.Object) bool {
+		tn, ok := obj.(*types.TypeName)
+		return ok && types.Identical(tn.Type().Underlying(), want)
+	}
+	optType := optName("Option")
+	existing := g.pkg.Types.Scope().Lookup(optType)
+	if existing != nil && !isOption(existing) {
+		optType = optName(exportedName(g.tn.Name()) + "Option")
+		existing = g.pkg.Types.Scope().Lookup(optType)
+		if existing != nil && !isOption(existing) {
+			return nil, fmt.Errorf("package %s already declares %s at %s", g.pkg.Name, optType, g.prog.position(existing.Pos()))
+		}
+	}
+	g.emit(optType, existing != nil, fmt.Sprintf("// %s configures a %s.\ntype %s func(*%s)", optType, g.tn.Name(), optType, g.tn.Name()))
+
+	for _, f := range selected {
+		name := "With" + exportedName(f.Name)
+		p := paramName(f.Name)
+		if p == g.recv {
+			p = "v"
+		}
+		g.emit(name, g.hasFunc(name), fmt.Sprintf("// %s sets the %s of a %s.\nfunc %s(%s %s) %s {\n\treturn func(%s *%s) {\n\t\t%s.%s = %s\n\t}\n}",
+			name, f.Name, g.tn.Name(), name, p, f.Type, optType, g.recv, g.tn.Name(), g.recv, f.Name, p))
+	}
+	return g.apply("options", true)
+}
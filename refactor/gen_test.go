@@ -0,0 +1,202 @@
+package refactor_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const genModule = `package app
+
+import "io"
+
+type Service struct {
+	out   io.Writer
+	cache map[string]int
+	Name  string
+	limit int
+}
+
+func (s *Service) Limit() int { return s.limit }
+`
+
+func TestGenStruct(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": genModule})
+	chdir(t, dir)
+
+	result, err := refactor.GenConstructor("Service", nil)
+	if err != nil {
+		t.Fatalf("GenConstructor error: %v", err)
+	}
+	if !strings.Contains(result.Code, "func NewService(out io.Writer) *Service") || !strings.Contains(result.Code, "cache: make(map[string]int)") {
+		t.Errorf("unexpected constructor:\n%s", result.Code)
+	}
+
+	result, err = refactor.GenAccessors("Service", nil)
+	if err != nil {
+		t.Fatalf("GenAccessors error: %v", err)
+	}
+	if strings.Join(result.Generated, ",") != "Out,SetOut,Cache,SetCache,SetLimit" {
+		t.Errorf("unexpected accessors: %v (skipped %v)", result.Generated, result.Skipped)
+	}
+
+	result, err = refactor.GenOptions("Service", []string{"limit"})
+	if err != nil {
+		t.Fatalf("GenOptions error: %v", err)
+	}
+	if !strings.Contains(result.Code, "type Option func(*Service)") || !strings.Contains(result.Code, "func WithLimit(limit int) Option") {
+		t.Errorf("unexpected options:\n%s", result.Code)
+	}
+
+	again, err := refactor.GenConstructor("Service", nil)
+	if err != nil || len(again.Generated) != 0 || len(again.Skipped) != 1 {
+		t.Errorf("constructor generated twice: %+v, %v", again, err)
+	}
+
+	if out, err := exec.Command("go", "vet", ".").CombinedOutput(); err != nil {
+		t.Errorf("generated code does not build: %s\n%s", out, readModuleFile(t, dir, "app.go"))
+	}
+}
+
+func TestGenOptionsUnexported(t *testing.T) {
+	src := "package app\n\ntype option int\n\ntype server struct {\n\tport int\n}\n"
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": src})
+	chdir(t, dir)
+
+	// option is taken by another type, so the name is serverOption.
+	result, err := refactor.GenOptions("server", nil)
+	if err != nil {
+		t.Fatalf("GenOptions error: %v", err)
+	}
+	if !strings.Contains(result.Code, "type serverOption func(*server)") {
+		t.Errorf("unexpected options:\n%s", result.Code)
+	}
+	if out, err := exec.Command("go", "vet", ".").CombinedOutput(); err != nil {
+		t.Errorf("generated code does not build: %s\n%s", out, readModuleFile(t, dir, "app.go"))
+	}
+}
+
+func TestGenEnum(t *testing.T) {
+	src := "package app\n\ntype Status int\n\nconst (\n\tPending Status = iota\n\tActive\n\tDefault = Pending\n)\n"
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": src})
+	chdir(t, dir)
+
+	result, err := refactor.GenEnum("Status", &refactor.EnumOptions{Text: true})
+	if err != nil {
+		t.Fatalf("GenEnum error: %v", err)
+	}
+	if strings.Join(result.Generated, ",") != "String,ParseStatus,StatusValues,IsValid,MarshalText,UnmarshalText" {
+		t.Errorf("unexpected functions: %v", result.Generated)
+	}
+	if strings.Count(result.Code, `return "Pending"`) != 1 {
+		t.Errorf("alias Default should not get its own case:\n%s", result.Code)
+	}
+
+	source := readModuleFile(t, dir, "app.go") + "\nconst Closed Status = 5\n"
+	if err := os.WriteFile(filepath.Join(dir, "app.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = refactor.GenEnum("Status", nil)
+	if err != nil {
+		t.Fatalf("GenEnum re-run error: %v", err)
+	}
+	if len(result.Updated) != 6 || len(result.Generated) != 0 {
+		t.Errorf("re-run should update in place: updated %v, generated %v", result.Updated, result.Generated)
+	}
+	if !strings.Contains(readModuleFile(t, dir, "app.go"), "return []Status{Pending, Active, Closed}") {
+		t.Errorf("values not synced:\n%s", readModuleFile(t, dir, "app.go"))
+	}
+
+	if out, err := exec.Command("go", "vet", ".").CombinedOutput(); err != nil {
+		t.Errorf("generated code does not build: %s\n%s", out, readModuleFile(t, dir, "app.go"))
+	}
+}
+
+func TestGenEnumString(t *testing.T) {
+	src := "package app\n\ntype Color string\n\nconst (\n\tRed Color = \"red\"\n\tGreen Color = \"green\"\n)\n"
+	test := `package app
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range ColorValues() {
+		text, err := c.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Color
+		if err := back.UnmarshalText(text); err != nil || back != c {
+			t.Errorf("%s: got %q, %v", text, back, err)
+		}
+	}
+}
+`
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": src, "app_test.go": test})
+	chdir(t, dir)
+
+	result, err := refactor.GenEnum("Color", &refactor.EnumOptions{Text: true})
+	if err != nil {
+		t.Fatalf("GenEnum error: %v", err)
+	}
+	if !strings.Contains(result.Code, "case string(Red):") {
+		t.Errorf("ParseColor should match values:\n%s", result.Code)
+	}
+	if out, err := exec.Command("go", "test", ".").CombinedOutput(); err != nil {
+		t.Errorf("MarshalText and UnmarshalText do not round-trip: %s\n%s", out, readModuleFile(t, dir, "app.go"))
+	}
+}
+
+func TestGenTest(t *testing.T) {
+	src := `package app
+
+import "errors"
+
+var calls int
+
+type Counter struct{ n int }
+
+func (c *Counter) Add(n int) int {
+	calls++
+	c.n += n
+	return c.n
+}
+
+func Parse(s string) ([]string, error) {
+	if s == "" {
+		return nil, errors.New("empty")
+	}
+	return []string{s}, nil
+}
+`
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n", "app.go": src})
+	chdir(t, dir)
+
+	result, err := refactor.GenTest("Parse", nil)
+	if err != nil {
+		t.Fatalf("GenTest error: %v", err)
+	}
+	if filepath.Base(result.File) != "app_test.go" || !strings.Contains(result.Code, "wantErr error") ||
+		!strings.Contains(result.Code, "!reflect.DeepEqual(got, tt.want)") || strings.Contains(result.Code, "tt := tt") {
+		t.Errorf("unexpected test for Parse in %s:\n%s", result.File, result.Code)
+	}
+
+	result, err = refactor.GenTest("Counter.Add", nil)
+	if err != nil {
+		t.Fatalf("GenTest method error: %v", err)
+	}
+	if !strings.Contains(result.Code, "func TestCounter_Add(t *testing.T)") || !strings.Contains(result.Code, "c := &Counter{}") ||
+		strings.Contains(result.Code, "t.Parallel()") {
+		t.Errorf("unexpected test for Counter.Add:\n%s", result.Code)
+	}
+
+	if _, err := refactor.GenTest("Parse", nil); err == nil {
+		t.Error("expected an error generating TestParse twice")
+	}
+	if out, err := exec.Command("go", "vet", ".").CombinedOutput(); err != nil {
+		t.Errorf("generated test does not build: %s\n%s", out, readModuleFile(t, dir, "app_test.go"))
+	}
+}
@@ -0,0 +1,259 @@
+package refactor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GenerateDirective is a //go:generate line.
+type GenerateDirective struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+}
+
+func (d *GenerateDirective) String() string {
+	return fmt.Sprintf("//go:generate %s (%s:%d)", d.Command, d.File, d.Line)
+}
+
+type GenerateResult struct {
+	Success    bool                `json:"success"`
+	Package    string              `json:"package"`
+	Passed     bool                `json:"passed"`
+	Directives []GenerateDirective `json:"directives"`
+	Changed    []string            `json:"changed"`
+	Added      []string            `json:"added,omitempty"`
+	Removed    []string            `json:"removed,omitempty"`
+	Output     string              `json:"output,omitempty"`
+	Message    string              `json:"message"`
+}
+
+// fileMeta is what symbol locations report about their file.
+type fileMeta struct {
+	constraint string
+	generated  bool
+	generator  string
+}
+
+func fileMetaOf(path string, src []byte) *fileMeta {
+	m := &fileMeta{constraint: fileConstraint(path, src), generated: isGenerated(src)}
+	if m.generated {
+		if d := generatorOf(path, src); d != nil {
+			m.generator = d.String()
+		}
+	}
+	return m
+}
+
+func (m *fileMeta) annotate(loc *SymbolLocation) {
+	loc.Constraint = m.constraint
+	loc.Generated = m.generated
+	loc.Generator = m.generator
+}
+
+// forceGenerated lets modifying commands edit generated files.
+var forceGenerated bool
+
+// SetForce allows or forbids modifying commands to edit files marked
+// "Code generated ... DO NOT EDIT."
+func SetForce(force bool) {
+	forceGenerated = force
+}
+
+var generatedComment = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether src carries the generated-code comment
+// before its package clause.
+func isGenerated(src []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if generatedComment.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritable refuses to let a modifying command edit a generated file,
+// unless forced. The error names the directive that regenerates it.
+func checkWritable(file string) error {
+	if forceGenerated {
+		return nil
+	}
+	src, err := os.ReadFile(file)
+	if err != nil || !isGenerated(src) {
+		return nil
+	}
+	by := ""
+	if d := generatorOf(file, src); d != nil {
+		by = " by " + d.String()
+	}
+	return fmt.Errorf("%s is generated%s and would be overwritten on the next go generate; change the generator's input or pass --force", file, by)
+}
+
+// generatorOf finds the //go:generate directive of the file's package that
+// produces it: one naming the file, one running the program its header
+// names, or the package's only directive.
+func generatorOf(file string, src []byte) *GenerateDirective {
+	directives := generateDirectives(filepath.Dir(file))
+	if len(directives) == 0 {
+		return nil
+	}
+	base := filepath.Base(file)
+	for i, d := range directives {
+		if strings.Contains(d.Command, base) {
+			return &directives[i]
+		}
+	}
+	// "// Code generated by "stringer -type=Color"; DO NOT EDIT."
+	if program := headerGenerator(src); program != "" {
+		for i, d := range directives {
+			for _, word := range strings.Fields(d.Command) {
+				if filepath.Base(word) == program || strings.HasPrefix(filepath.Base(word), program+"@") {
+					return &directives[i]
+				}
+			}
+		}
+	}
+	if len(directives) == 1 {
+		return &directives[0]
+	}
+	return nil
+}
+
+// headerGenerator returns the program named by "Code generated by X" in
+// the generated-code comment.
+func headerGenerator(src []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !generatedComment.MatchString(line) {
+			continue
+		}
+		rest, ok := strings.CutPrefix(line, "// Code generated by ")
+		if !ok {
+			return ""
+		}
+		fields := strings.Fields(strings.Trim(rest, `"`))
+		if len(fields) == 0 {
+			return ""
+		}
+		return filepath.Base(strings.TrimRight(fields[0], `".;`))
+	}
+	return ""
+}
+
+// generateDirectives returns the //go:generate lines of the Go files in
+// dir the build configuration includes, as go generate runs them.
+func generateDirectives(dir string) []GenerateDirective {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var directives []GenerateDirective
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || !buildMatches(path) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if command, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "//go:generate "); ok {
+				directives = append(directives, GenerateDirective{File: path, Line: i + 1, Command: strings.TrimSpace(command)})
+			}
+		}
+	}
+	return directives
+}
+
+// Generate runs go generate on pkg and reports the files of the module
+// that it created, changed or removed.
+func Generate(pkg string) (*GenerateResult, error) {
+	if pkg == "" {
+		pkg = "./..."
+	}
+	ws, err := findWorkspace(".")
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", pkg).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v", pkg, err)
+	}
+	result := &GenerateResult{Success: true, Package: pkg, Directives: []GenerateDirective{}, Changed: []string{}}
+	for _, dir := range strings.Fields(string(out)) {
+		result.Directives = append(result.Directives, generateDirectives(dir)...)
+	}
+
+	before := ws.snapshot()
+	output, runErr := exec.Command("go", "generate", pkg).CombinedOutput()
+	after := ws.snapshot()
+	result.Passed = runErr == nil
+	if !result.Passed {
+		result.Output = string(output)
+	}
+
+	for file, sum := range after {
+		old, ok := before[file]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, file)
+		case old != sum:
+			result.Changed = append(result.Changed, file)
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok {
+			result.Removed = append(result.Removed, file)
+		}
+	}
+	sort.Strings(result.Changed)
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+
+	result.Message = fmt.Sprintf("ran %d directive(s): %d file(s) changed, %d added, %d removed",
+		len(result.Directives), len(result.Changed), len(result.Added), len(result.Removed))
+	if !result.Passed {
+		result.Message = "go generate failed; " + result.Message
+	}
+	return result, nil
+}
+
+// snapshot hashes every file of the workspace modules, skipping hidden
+// directories, to see what a generator touched.
+func (w *workspace) snapshot() map[string][sha256.Size]byte {
+	sums := make(map[string][sha256.Size]byte)
+	for _, m := range w.Modules {
+		filepath.Walk(m.Dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if fi.IsDir() {
+				if path != m.Dir && strings.HasPrefix(fi.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if data, err := os.ReadFile(path); err == nil {
+				sums[path] = sha256.Sum256(data)
+			}
+			return nil
+		})
+	}
+	return sums
+}
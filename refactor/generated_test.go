@@ -0,0 +1,61 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const generatorMain = `package main
+
+import "os"
+
+func main() {
+	os.WriteFile("color_string.go", []byte("// Code generated by colorgen. DO NOT EDIT.\n\npackage app\n\nfunc (c Color) String() string { return \"color\" }\n"), 0644)
+}
+`
+
+func TestGenerated(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":                    "module example.com/app\n\ngo 1.21\n",
+		"color.go":                  "package app\n\n//go:generate go run ./internal/colorgen\n\ntype Color int\n",
+		"internal/colorgen/main.go": generatorMain,
+	})
+	chdir(t, dir)
+
+	gen, err := refactor.Generate("")
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if !gen.Passed || len(gen.Directives) != 1 || len(gen.Added) != 1 || !strings.HasSuffix(gen.Added[0], "color_string.go") {
+		t.Fatalf("expected color_string.go to be added, got %+v", gen)
+	}
+
+	found, err := refactor.FindFunc("Color.String", ".")
+	if err != nil {
+		t.Fatalf("FindFunc error: %v", err)
+	}
+	if found.Count != 1 || !found.Matches[0].Generated || !strings.Contains(found.Matches[0].Generator, "go run ./internal/colorgen") {
+		t.Fatalf("expected a generated match with its directive, got %+v", found.Matches)
+	}
+
+	code := `func (c Color) String() string { return "edited" }`
+	if _, err := refactor.Replace("Color.String", "", strings.NewReader(code)); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected replace to refuse the generated file, got %v", err)
+	}
+
+	refactor.SetForce(true)
+	t.Cleanup(func() { refactor.SetForce(false) })
+	if _, err := refactor.Replace("Color.String", "", strings.NewReader(code)); err != nil {
+		t.Fatalf("Replace with force error: %v", err)
+	}
+
+	gen, err = refactor.Generate("./...")
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if len(gen.Changed) != 1 || len(gen.Added) != 0 {
+		t.Errorf("expected the edited file to be regenerated, got %+v", gen)
+	}
+}
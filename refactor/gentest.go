@@ -0,0 +1,340 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type GenTestOptions struct {
+	File string // test file, by default the function's file with _test.go
+}
+
+// GenTest appends a table-driven test for a function or method to its
+// _test.go file, creating the file if needed, through AddFunc. The table
+// has a field per parameter and one per result; an error result becomes
+// wantErr and is compared with errors.Is. Methods are called on a zero
+// value receiver. Subtests run in parallel unless the function touches
+// package-level variables or the process environment.
+func GenTest(funcName string, opts *GenTestOptions) (*GenResult, error) {
+	if opts == nil {
+		opts = &GenTestOptions{}
+	}
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	pkg, decl, fn := prog.lookupFunc(funcName)
+	if decl == nil || fn == nil {
+		return nil, fmt.Errorf("function %s not found", funcName)
+	}
+	srcFile := prog.position(decl.Pos()).Filename
+	if strings.HasSuffix(srcFile, "_test.go") {
+		return nil, fmt.Errorf("%s is declared in a test file", funcName)
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.TypeParams().Len() > 0 || sig.RecvTypeParams().Len() > 0 {
+		return nil, fmt.Errorf("%s is generic; generic functions are not supported", funcName)
+	}
+
+	testFile := opts.File
+	switch {
+	case testFile == "":
+		testFile = strings.TrimSuffix(srcFile, ".go") + "_test.go"
+	case !filepath.IsAbs(testFile) && filepath.Base(testFile) == testFile:
+		testFile = filepath.Join(pkg.Dir, testFile)
+	}
+	if !strings.HasSuffix(testFile, "_test.go") {
+		return nil, fmt.Errorf("%s is not a _test.go file", testFile)
+	}
+
+	// The test goes into the package of an existing file, which may be the
+	// external test package.
+	fset := token.NewFileSet()
+	pkgName := pkg.Name
+	var existing *ast.File
+	if _, err := os.Stat(testFile); err == nil {
+		if existing, err = parser.ParseFile(fset, testFile, nil, parser.ImportsOnly); err != nil {
+			return nil, err
+		}
+		pkgName = existing.Name.Name
+	}
+	external := pkgName != pkg.Name
+
+	var recv *types.Named
+	if sig.Recv() != nil {
+		t := sig.Recv().Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		recv, _ = t.(*types.Named)
+		if recv == nil {
+			return nil, fmt.Errorf("unsupported receiver type for %s", funcName)
+		}
+	}
+	if external && (!fn.Exported() || (recv != nil && !recv.Obj().Exported())) {
+		return nil, fmt.Errorf("%s is unexported and cannot be tested from package %s", funcName, pkgName)
+	}
+
+	testName := "Test" + exportedName(fn.Name())
+	if recv != nil {
+		testName = "Test" + exportedName(recv.Obj().Name()) + "_" + fn.Name()
+	}
+	for _, path := range []string{pkg.ImportPath, pkg.ImportPath + "_test"} {
+		if lp := prog.byPath[path]; lp != nil && lp.Types != nil && lp.Types.Scope().Lookup(testName) != nil {
+			return nil, fmt.Errorf("%s already exists", testName)
+		}
+	}
+
+	imports := []string{"testing"}
+	qualify := func(other *types.Package) string {
+		if other == pkg.Types && !external {
+			return ""
+		}
+		if existing != nil {
+			if name := importName(existing, other.Path()); name != "" {
+				return name
+			}
+		}
+		imports = append(imports, other.Path())
+		return other.Name()
+	}
+	typeStr := func(t types.Type) string { return types.TypeString(t, qualify) }
+
+	t := &testTable{}
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		v := params.At(i)
+		name := v.Name()
+		switch {
+		case name == "" || name == "_":
+			name = "arg" + strconv.Itoa(i+1)
+		case name == "name" || strings.HasPrefix(name, "want"):
+			name = "arg" + exportedName(name)
+		}
+		t.field(name, typeStr(v.Type()))
+		arg := "tt." + name
+		if sig.Variadic() && i == params.Len()-1 {
+			arg += "..."
+		}
+		t.args = append(t.args, arg)
+	}
+
+	results := sig.Results()
+	errorType := types.Universe.Lookup("error").Type()
+	hasErr := results.Len() > 0 && types.Identical(results.At(results.Len()-1).Type(), errorType)
+	var gots []string
+	var deepEqual bool
+	for i := 0; i < results.Len(); i++ {
+		if hasErr && i == results.Len()-1 {
+			gots = append(gots, "err")
+			continue
+		}
+		suffix := ""
+		if n := len(gots); n > 0 {
+			suffix = strconv.Itoa(n + 1)
+		}
+		rt := results.At(i).Type()
+		t.field("want"+suffix, typeStr(rt))
+		gots = append(gots, "got"+suffix)
+		cmp := fmt.Sprintf("got%s != tt.want%s", suffix, suffix)
+		if !simplyComparable(rt) {
+			cmp = fmt.Sprintf("!reflect.DeepEqual(got%s, tt.want%s)", suffix, suffix)
+			deepEqual = true
+		}
+		t.checks = append(t.checks, resultCheck{cmp: cmp, suffix: suffix})
+	}
+	if hasErr {
+		t.field("wantErr", "error")
+		imports = append(imports, "errors")
+	}
+	if deepEqual {
+		imports = append(imports, "reflect")
+	}
+
+	// The callee as the test refers to it.
+	callee := fn.Name()
+	label := fn.Name()
+	var setup string
+	if recv != nil {
+		r := "recv"
+		if len(decl.Recv.List[0].Names) > 0 {
+			if n := decl.Recv.List[0].Names[0].Name; n != "_" && !contains([]string{"t", "tt", "tests", "err"}, n) && !strings.HasPrefix(n, "got") {
+				r = n
+			}
+		}
+		rt := typeStr(recv)
+		switch {
+		case !isPointerRecv(sig):
+			setup = fmt.Sprintf("var %s %s\n", r, rt)
+		case isStruct(recv):
+			setup = fmt.Sprintf("%s := &%s{}\n", r, rt)
+		default:
+			setup = fmt.Sprintf("%s := new(%s)\n", r, rt)
+		}
+		callee = r + "." + fn.Name()
+		label = recv.Obj().Name() + "." + fn.Name()
+	} else if external {
+		callee = qualify(pkg.Types) + "." + fn.Name()
+	}
+	call := callee + "(" + strings.Join(t.args, ", ") + ")"
+	if len(gots) > 0 {
+		call = strings.Join(gots, ", ") + " := " + call
+	}
+
+	parallel := parallelSafe(pkg, decl)
+	var b strings.Builder
+	fmt.Fprintf(&b, "func %s(t *testing.T) {\n", testName)
+	if parallel {
+		b.WriteString("t.Parallel()\n")
+	}
+	b.WriteString("tests := []struct {\nname string\n")
+	for _, f := range t.fields {
+		b.WriteString(f + "\n")
+	}
+	b.WriteString("}{\n// TODO: add test cases.\n}\n")
+	b.WriteString("for _, tt := range tests {\n")
+	if parallel && !goVersionAtLeast(prog.Root, 22) {
+		b.WriteString("tt := tt\n")
+	}
+	b.WriteString("t.Run(tt.name, func(t *testing.T) {\n")
+	if parallel {
+		b.WriteString("t.Parallel()\n")
+	}
+	b.WriteString(setup + call + "\n")
+	if hasErr {
+		fmt.Fprintf(&b, "if !errors.Is(err, tt.wantErr) {\nt.Fatalf(\"%s() error = %%v, want %%v\", err, tt.wantErr)\n}\n", label)
+		if len(t.checks) > 0 {
+			b.WriteString("if tt.wantErr != nil {\nreturn\n}\n")
+		}
+	}
+	for _, c := range t.checks {
+		fmt.Fprintf(&b, "if %s {\nt.Errorf(\"%s() got%s = %%v, want %%v\", got%s, tt.want%s)\n}\n", c.cmp, label, c.suffix, c.suffix, c.suffix)
+	}
+	b.WriteString("})\n}\n}")
+	code := b.String()
+	if formatted, err := formatSource([]byte(code)); err == nil {
+		code = strings.TrimSpace(string(formatted))
+	}
+
+	if existing == nil {
+		if err := os.WriteFile(testFile, []byte("package "+pkgName+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := AddFunc(testFile, strings.NewReader(code)); err != nil {
+		return nil, err
+	}
+	if external {
+		imports = append(imports, pkg.ImportPath)
+	}
+	f, err := parser.ParseFile(fset, testFile, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	if edits := (&program{Fset: fset}).addImports(f, imports...); len(edits) > 0 {
+		if _, err := applyEdits(edits); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &GenResult{
+		Success:      true,
+		File:         testFile,
+		Generated:    []string{testName},
+		Code:         code,
+		FilesChanged: []string{testFile},
+		Message:      fmt.Sprintf("generated %s; fill in the test cases", testName),
+	}
+	if recv != nil {
+		result.Type = recv.Obj().Name()
+	}
+	return result, nil
+}
+
+// testTable collects the fields of a generated test table and the
+// arguments and result checks built from them.
+type testTable struct {
+	fields []string
+	args   []string
+	checks []resultCheck
+}
+
+// resultCheck compares got<suffix> with tt.want<suffix>.
+type resultCheck struct {
+	cmp    string
+	suffix string
+}
+
+func (t *testTable) field(name, typ string) {
+	t.fields = append(t.fields, name+" "+typ)
+}
+
+// simplyComparable reports whether values of t can be compared with !=
+// without surprises: basic types and pointers. Everything else goes through
+// reflect.DeepEqual.
+func simplyComparable(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Basic, *types.Pointer:
+		return true
+	}
+	return false
+}
+
+func isPointerRecv(sig *types.Signature) bool {
+	_, ok := sig.Recv().Type().(*types.Pointer)
+	return ok
+}
+
+func isStruct(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+// parallelSafe reports whether fn can run in parallel subtests: it must not
+// use package-level variables or change the working directory or
+// environment.
+func parallelSafe(pkg *loadedPackage, fn *ast.FuncDecl) bool {
+	if fn.Body == nil {
+		return true
+	}
+	safe := true
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if v, ok := pkg.Info.Uses[n].(*types.Var); ok && v.Pkg() != nil && v.Parent() == v.Pkg().Scope() {
+				safe = false
+			}
+		case *ast.SelectorExpr:
+			if obj, ok := pkg.Info.Uses[n.Sel].(*types.Func); ok && obj.Pkg() != nil && obj.Pkg().Path() == "os" {
+				switch obj.Name() {
+				case "Chdir", "Setenv", "Unsetenv", "Clearenv":
+					safe = false
+				}
+			}
+		}
+		return safe
+	})
+	return safe
+}
+
+// goVersionAtLeast reports whether the go directive of the module at root
+// is at least 1.minor.
+func goVersionAtLeast(root string, minor int) bool {
+	m, err := readModule(root)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(m.GoVersion, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	n, err := strconv.Atoi(parts[1])
+	return err == nil && parts[0] == "1" && n >= minor
+}
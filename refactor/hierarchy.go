@@ -0,0 +1,373 @@
+package refactor
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+type HierarchyMethod struct {
+	Name      string `json:"name"`
+	Method    string `json:"method"`
+	Signature string `json:"signature"`
+	Receiver  string `json:"receiver,omitempty"`
+	Via       string `json:"via,omitempty"`
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+}
+
+type HierarchyField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Via  string `json:"via"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+type HierarchyType struct {
+	Name     string `json:"name"`
+	Package  string `json:"package,omitempty"`
+	Receiver string `json:"receiver,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+type HierarchyImplementer struct {
+	HierarchyType
+	Methods []HierarchyMethod `json:"methods"`
+}
+
+type HierarchyResult struct {
+	Success        bool                   `json:"success"`
+	Name           string                 `json:"name"`
+	Kind           string                 `json:"kind"`
+	Package        string                 `json:"package"`
+	File           string                 `json:"file"`
+	Line           int                    `json:"line"`
+	Embedded       []HierarchyType        `json:"embedded,omitempty"`
+	Satisfies      []HierarchyType        `json:"satisfies,omitempty"`
+	PromotedFields []HierarchyField       `json:"promotedFields,omitempty"`
+	MethodSet      []HierarchyMethod      `json:"methodSet,omitempty"`
+	Methods        []HierarchyMethod      `json:"methods,omitempty"`
+	Implementers   []HierarchyImplementer `json:"implementers,omitempty"`
+}
+
+// Hierarchy describes the place of a named type in the type hierarchy. For
+// concrete types it lists embedded types, promoted fields, the full method
+// set and every module or imported interface the type satisfies. For
+// interfaces it lists embedded interfaces, methods and module implementers.
+func Hierarchy(name string) (*HierarchyResult, error) {
+	prog, err := loadProgram(".", false)
+	if err != nil {
+		return nil, err
+	}
+
+	tn := prog.lookupType(name)
+	if tn == nil {
+		return nil, fmt.Errorf("type %s not found", name)
+	}
+
+	pos := prog.position(tn.Pos())
+	result := &HierarchyResult{
+		Success: true,
+		Name:    tn.Name(),
+		Kind:    "type",
+		Package: tn.Pkg().Path(),
+		File:    pos.Filename,
+		Line:    pos.Line,
+	}
+
+	if iface, ok := tn.Type().Underlying().(*types.Interface); ok {
+		result.Kind = "interface"
+		prog.describeInterface(result, tn, iface)
+	} else {
+		if _, ok := tn.Type().Underlying().(*types.Struct); ok {
+			result.Kind = "struct"
+		}
+		prog.describeConcrete(result, tn)
+	}
+	return result, nil
+}
+
+func (p *program) hierarchyType(tn *types.TypeName) HierarchyType {
+	ht := HierarchyType{Name: tn.Name()}
+	if tn.Pkg() != nil {
+		ht.Package = tn.Pkg().Path()
+	}
+	if p.pkgOf(tn) != nil {
+		pos := p.position(tn.Pos())
+		ht.File = pos.Filename
+		ht.Line = pos.Line
+	} else if tn.Pkg() != nil {
+		ht.Name = tn.Pkg().Name() + "." + tn.Name()
+	}
+	return ht
+}
+
+// hierarchyMethod describes fn, found in t at the given selection index.
+// Receiver is the receiver of the declaration, empty for interface methods.
+func (p *program) hierarchyMethod(t types.Type, fn *types.Func, index []int) HierarchyMethod {
+	hm := HierarchyMethod{
+		Name:      p.funcName(fn),
+		Method:    fn.Name(),
+		Signature: types.TypeString(fn.Type(), types.RelativeTo(fn.Pkg())),
+		Via:       embeddedPath(t, index),
+	}
+	if sig, ok := fn.Type().(*types.Signature); ok && sig.Recv() != nil && !types.IsInterface(sig.Recv().Type()) {
+		hm.Receiver = "value"
+		if _, ptr := sig.Recv().Type().(*types.Pointer); ptr {
+			hm.Receiver = "pointer"
+		}
+	}
+	if p.pkgOf(fn) != nil {
+		pos := p.position(fn.Pos())
+		hm.File = pos.Filename
+		hm.Line = pos.Line
+	}
+	return hm
+}
+
+// embeddedPath names the embedded fields walked by a selection index, e.g.
+// "Base.Logger" for a method promoted through two levels. Empty for direct
+// members.
+func embeddedPath(t types.Type, index []int) string {
+	var path []string
+	for _, i := range index[:len(index)-1] {
+		if ptr, ok := t.Underlying().(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		st, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			break
+		}
+		field := st.Field(i)
+		path = append(path, field.Name())
+		t = field.Type()
+	}
+	return strings.Join(path, ".")
+}
+
+func (p *program) describeConcrete(result *HierarchyResult, tn *types.TypeName) {
+	t := tn.Type()
+	ptr := types.NewPointer(t)
+
+	if st, ok := t.Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if !f.Embedded() {
+				continue
+			}
+			ft := f.Type()
+			if fp, ok := ft.(*types.Pointer); ok {
+				ft = fp.Elem()
+			}
+			if named, ok := ft.(*types.Named); ok {
+				result.Embedded = append(result.Embedded, p.hierarchyType(named.Obj()))
+			}
+		}
+		result.PromotedFields = p.promotedFields(t)
+	}
+
+	// The method set of *T is a superset of that of T; Receiver tells
+	// which of the two a method belongs to.
+	valueSet := types.NewMethodSet(t)
+	ptrSet := types.NewMethodSet(ptr)
+	for i := 0; i < ptrSet.Len(); i++ {
+		sel := ptrSet.At(i)
+		fn := sel.Obj().(*types.Func)
+		hm := p.hierarchyMethod(t, fn, sel.Index())
+		hm.Receiver = "pointer"
+		if valueSet.Lookup(fn.Pkg(), fn.Name()) != nil {
+			hm.Receiver = "value"
+		}
+		result.MethodSet = append(result.MethodSet, hm)
+	}
+
+	for _, iface := range p.candidateInterfaces() {
+		it := iface.Type().Underlying().(*types.Interface)
+		if it.NumMethods() == 0 {
+			continue
+		}
+		recv := ""
+		if types.Implements(t, it) {
+			recv = "value"
+		} else if types.Implements(ptr, it) {
+			recv = "pointer"
+		}
+		if recv == "" {
+			continue
+		}
+		ht := p.hierarchyType(iface)
+		ht.Receiver = recv
+		result.Satisfies = append(result.Satisfies, ht)
+	}
+}
+
+// promotedFields lists fields reachable through embedded structs that are
+// not shadowed by shallower fields.
+func (p *program) promotedFields(t types.Type) []HierarchyField {
+	var fields []HierarchyField
+	seen := make(map[string]bool)
+	if st, ok := t.Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			seen[st.Field(i).Name()] = true
+		}
+	}
+
+	type level struct {
+		t   types.Type
+		via []string
+	}
+	current := []level{{t: t}}
+	for depth := 0; depth < 8 && len(current) > 0; depth++ {
+		var next []level
+		for _, lv := range current {
+			lt := lv.t
+			if ptr, ok := lt.Underlying().(*types.Pointer); ok {
+				lt = ptr.Elem()
+			}
+			st, ok := lt.Underlying().(*types.Struct)
+			if !ok {
+				continue
+			}
+			for i := 0; i < st.NumFields(); i++ {
+				f := st.Field(i)
+				if depth > 0 && !seen[f.Name()] {
+					seen[f.Name()] = true
+					hf := HierarchyField{
+						Name: f.Name(),
+						Type: types.TypeString(f.Type(), types.RelativeTo(f.Pkg())),
+						Via:  strings.Join(lv.via, "."),
+					}
+					if p.pkgOf(f) != nil {
+						pos := p.position(f.Pos())
+						hf.File = pos.Filename
+						hf.Line = pos.Line
+					}
+					fields = append(fields, hf)
+				}
+				if f.Embedded() {
+					next = append(next, level{t: f.Type(), via: append(append([]string(nil), lv.via...), f.Name())})
+				}
+			}
+		}
+		current = next
+	}
+	return fields
+}
+
+// satisfiedInterfaces names the interfaces with the given method that T or
+// *T implements, T being t without its pointer: those a change to the
+// method's signature or its removal would break.
+func (p *program) satisfiedInterfaces(t types.Type, method string) []string {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	candidates := append(p.candidateInterfaces(), types.Universe.Lookup("error").(*types.TypeName))
+	var names []string
+	for _, tn := range candidates {
+		iface := tn.Type().Underlying().(*types.Interface)
+		if obj, _, _ := types.LookupFieldOrMethod(iface, false, tn.Pkg(), method); obj == nil {
+			continue
+		}
+		if types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface) {
+			name := tn.Name()
+			if tn.Pkg() != nil {
+				name = tn.Pkg().Name() + "." + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// candidateInterfaces returns the exported interfaces of the module and of
+// every package it imports, directly or indirectly.
+func (p *program) candidateInterfaces() []*types.TypeName {
+	var result []*types.TypeName
+	for _, tn := range p.namedTypes() {
+		if types.IsInterface(tn.Type()) {
+			result = append(result, tn)
+		}
+	}
+
+	var external []*types.TypeName
+	seen := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if pkg == nil || seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		if p.byPath[pkg.Path()] == nil {
+			scope := pkg.Scope()
+			for _, name := range scope.Names() {
+				if tn, ok := scope.Lookup(name).(*types.TypeName); ok && tn.Exported() && types.IsInterface(tn.Type()) {
+					external = append(external, tn)
+				}
+			}
+		}
+		for _, imp := range pkg.Imports() {
+			visit(imp)
+		}
+	}
+	for _, pkg := range p.Packages {
+		if pkg.Types != nil {
+			visit(pkg.Types)
+		}
+	}
+	sort.Slice(external, func(i, j int) bool {
+		a, b := external[i], external[j]
+		if a.Pkg().Path() != b.Pkg().Path() {
+			return a.Pkg().Path() < b.Pkg().Path()
+		}
+		return a.Name() < b.Name()
+	})
+	return append(result, external...)
+}
+
+func (p *program) describeInterface(result *HierarchyResult, tn *types.TypeName, iface *types.Interface) {
+	for i := 0; i < iface.NumEmbeddeds(); i++ {
+		if named, ok := iface.EmbeddedType(i).(*types.Named); ok {
+			result.Embedded = append(result.Embedded, p.hierarchyType(named.Obj()))
+		}
+	}
+
+	ms := types.NewMethodSet(tn.Type())
+	for i := 0; i < ms.Len(); i++ {
+		result.Methods = append(result.Methods, p.hierarchyMethod(tn.Type(), ms.At(i).Obj().(*types.Func), ms.At(i).Index()))
+	}
+	if iface.NumMethods() == 0 {
+		return
+	}
+
+	for _, impl := range p.namedTypes() {
+		if types.IsInterface(impl.Type()) {
+			continue
+		}
+		t := impl.Type()
+		recv := ""
+		if types.Implements(t, iface) {
+			recv = "value"
+		} else if types.Implements(types.NewPointer(t), iface) {
+			recv = "pointer"
+			t = types.NewPointer(t)
+		} else {
+			continue
+		}
+
+		hi := HierarchyImplementer{HierarchyType: p.hierarchyType(impl)}
+		hi.Receiver = recv
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			obj, index, _ := types.LookupFieldOrMethod(t, false, m.Pkg(), m.Name())
+			fn, ok := obj.(*types.Func)
+			if !ok {
+				continue
+			}
+			hi.Methods = append(hi.Methods, p.hierarchyMethod(t, fn, index))
+		}
+		result.Implementers = append(result.Implementers, hi)
+	}
+}
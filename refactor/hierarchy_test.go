@@ -0,0 +1,99 @@
+package refactor_test
+
+import (
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const hierarchyModule = `package h
+
+import "io"
+
+type Logger struct{ Prefix string }
+
+func (l *Logger) Log(s string) {}
+
+type Base struct {
+	Logger
+	ID int
+}
+
+func (b Base) Close() error { return nil }
+
+type File struct {
+	Base
+	Name string
+}
+
+func (f *File) Read(p []byte) (int, error) { return 0, nil }
+
+type ReadCloser interface {
+	io.Reader
+	Close() error
+}
+`
+
+func TestHierarchyConcrete(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/h\n\ngo 1.21\n",
+		"h.go":   hierarchyModule,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Hierarchy("File")
+	if err != nil {
+		t.Fatalf("Hierarchy error: %v", err)
+	}
+	if len(result.Embedded) != 1 || result.Embedded[0].Name != "Base" {
+		t.Errorf("expected embedded Base, got %+v", result.Embedded)
+	}
+
+	satisfies := map[string]string{}
+	for _, s := range result.Satisfies {
+		satisfies[s.Name] = s.Receiver
+	}
+	if satisfies["ReadCloser"] != "pointer" || satisfies["io.Reader"] != "pointer" || satisfies["io.Closer"] != "value" {
+		t.Errorf("unexpected satisfied interfaces: %v", satisfies)
+	}
+
+	methods := map[string]refactor.HierarchyMethod{}
+	for _, m := range result.MethodSet {
+		methods[m.Method] = m
+	}
+	if m := methods["Log"]; m.Name != "*Logger.Log" || m.Via != "Base.Logger" || m.Receiver != "pointer" {
+		t.Errorf("unexpected promoted Log: %+v", m)
+	}
+
+	promoted := map[string]string{}
+	for _, f := range result.PromotedFields {
+		promoted[f.Name] = f.Via
+	}
+	if promoted["Prefix"] != "Base.Logger" || promoted["ID"] != "Base" {
+		t.Errorf("unexpected promoted fields: %v", promoted)
+	}
+}
+
+func TestHierarchyInterface(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/h\n\ngo 1.21\n",
+		"h.go":   hierarchyModule,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Hierarchy("ReadCloser")
+	if err != nil {
+		t.Fatalf("Hierarchy error: %v", err)
+	}
+	if result.Kind != "interface" || len(result.Embedded) != 1 || result.Embedded[0].Name != "io.Reader" {
+		t.Errorf("unexpected interface description: %+v", result)
+	}
+	if len(result.Implementers) != 1 || result.Implementers[0].Name != "File" || result.Implementers[0].Receiver != "pointer" {
+		t.Fatalf("expected *File to implement ReadCloser, got %+v", result.Implementers)
+	}
+	for _, m := range result.Implementers[0].Methods {
+		if m.Method == "Close" && m.Name != "Base.Close" {
+			t.Errorf("Close should come from Base, got %+v", m)
+		}
+	}
+}
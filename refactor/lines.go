@@ -48,6 +48,10 @@ func ReadLines(file string, start, end int) (*LinesResult, error) {
 }
 
 func ReplaceLines(file string, start, end int, newContent string) (*ModifyResult, error) {
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
+
 	content, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -85,6 +89,10 @@ func ReplaceLines(file string, start, end int, newContent string) (*ModifyResult
 }
 
 func DeleteLines(file string, start, end int) (*ModifyResult, error) {
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
+
 	content, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -119,6 +127,10 @@ func DeleteLines(file string, start, end int) (*ModifyResult, error) {
 }
 
 func InsertLines(file string, after int, newContent string) (*ModifyResult, error) {
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
+
 	content, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
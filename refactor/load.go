@@ -0,0 +1,396 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// buildContext decides which files take part in type-checked analysis.
+var buildContext = build.Default
+
+// program is a type-checked view of all packages in a module. Commands that
+// need more than syntax (call hierarchy, call graph, dead code, ...) load one
+// and work on it instead of re-parsing files themselves.
+type program struct {
+	Fset      *token.FileSet
+	Root      string
+	Module    string
+	Workspace *workspace
+	Packages  []*loadedPackage
+
+	byPath   map[string]*loadedPackage
+	fallback types.Importer
+	files    map[string]*fileMeta
+}
+
+type loadedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	Files      []*ast.File
+	Types      *types.Package
+	Info       *types.Info
+	Errors     []string
+
+	checking bool
+	checked  bool
+}
+
+// findModuleRoot walks up from dir to the nearest go.mod and returns its
+// directory and module path.
+func findModuleRoot(dir string) (string, string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for d := absDir; ; d = filepath.Dir(d) {
+		if data, err := os.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			return d, modfile.ModulePath(data), nil
+		}
+		if filepath.Dir(d) == d {
+			return "", "", fmt.Errorf("go.mod not found in %s or any parent directory", absDir)
+		}
+	}
+}
+
+// loadProgram parses and type-checks every package of the workspace of
+// dir: the module containing it, its nested modules, or all modules of
+// go.work. Root and Module stay those of the module containing dir, or the
+// go.work directory and "" when dir is in no module. With tests set,
+// _test.go files are included: in-package tests are checked together with
+// their package, external tests as "<path>_test".
+func loadProgram(dir string, tests bool) (*program, error) {
+	ws, err := findWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, modulePath, err := ws.moduleRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := &program{
+		Fset:      token.NewFileSet(),
+		Root:      root,
+		Module:    modulePath,
+		Workspace: ws,
+		byPath:    make(map[string]*loadedPackage),
+	}
+	prog.fallback = importer.ForCompiler(prog.Fset, "gc", nil)
+
+	ws.walkPackageDirs(func(_ *wsModule, path string) {
+		prog.parseDir(path, tests)
+	})
+
+	for _, pkg := range prog.Packages {
+		prog.check(pkg)
+	}
+
+	return prog, nil
+}
+
+func (p *program) importPathOf(dir string) string {
+	if path := p.Workspace.importPath(dir); path != "" {
+		return path
+	}
+	rel, err := filepath.Rel(p.Root, dir)
+	if err != nil || rel == "." {
+		return p.Module
+	}
+	return p.Module + "/" + filepath.ToSlash(rel)
+}
+
+func (p *program) parseDir(dir string, tests bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	importPath := p.importPathOf(dir)
+	var pkg, xtest *loadedPackage
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		isTest := strings.HasSuffix(name, "_test.go")
+		if isTest && !tests {
+			continue
+		}
+		if ok, err := buildContext.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+
+		f, err := parser.ParseFile(p.Fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+
+		if isTest && strings.HasSuffix(f.Name.Name, "_test") {
+			if xtest == nil {
+				xtest = &loadedPackage{ImportPath: importPath + "_test", Name: f.Name.Name, Dir: dir}
+			}
+			xtest.Files = append(xtest.Files, f)
+			continue
+		}
+		if pkg == nil {
+			pkg = &loadedPackage{ImportPath: importPath, Name: f.Name.Name, Dir: dir}
+		}
+		if f.Name.Name == pkg.Name {
+			pkg.Files = append(pkg.Files, f)
+		}
+	}
+
+	for _, lp := range []*loadedPackage{pkg, xtest} {
+		if lp != nil {
+			p.Packages = append(p.Packages, lp)
+			p.byPath[lp.ImportPath] = lp
+		}
+	}
+}
+
+func (p *program) Import(path string) (*types.Package, error) {
+	if lp, ok := p.byPath[path]; ok {
+		if lp.checking {
+			return nil, fmt.Errorf("import cycle through %s", path)
+		}
+		p.check(lp)
+		return lp.Types, nil
+	}
+	return p.fallback.Import(path)
+}
+
+func (p *program) check(pkg *loadedPackage) {
+	if pkg.checked || pkg.checking {
+		return
+	}
+	pkg.checking = true
+	defer func() {
+		pkg.checking = false
+		pkg.checked = true
+	}()
+
+	pkg.Info = &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Instances:  make(map[*ast.Ident]types.Instance),
+	}
+	conf := types.Config{
+		Importer: p,
+		Sizes:    types.SizesFor(buildContext.Compiler, buildContext.GOARCH),
+		Error: func(err error) {
+			pkg.Errors = append(pkg.Errors, err.Error())
+		},
+	}
+	pkg.Types, _ = conf.Check(pkg.ImportPath, p.Fset, pkg.Files, pkg.Info)
+}
+
+// pkgOf returns the module package that declares obj, or nil for objects
+// from the standard library and other modules.
+func (p *program) pkgOf(obj types.Object) *loadedPackage {
+	if obj == nil || obj.Pkg() == nil {
+		return nil
+	}
+	for _, lp := range p.Packages {
+		if lp.Types == obj.Pkg() {
+			return lp
+		}
+	}
+	return nil
+}
+
+// funcName names fn the way SymbolLocation.Name does ("Func", "T.Method",
+// "*T.Method"), so results can be passed back to read/replace. Functions
+// outside the module use their qualified name ("fmt.Println").
+func (p *program) funcName(fn *types.Func) string {
+	fn = fn.Origin()
+	sig, _ := fn.Type().(*types.Signature)
+	if p.pkgOf(fn) == nil {
+		return fn.FullName()
+	}
+	if sig == nil || sig.Recv() == nil {
+		return fn.Name()
+	}
+	return recvTypeName(sig.Recv().Type()) + "." + fn.Name()
+}
+
+func recvTypeName(t types.Type) string {
+	prefix := ""
+	if ptr, ok := t.(*types.Pointer); ok {
+		prefix = "*"
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return prefix + named.Obj().Name()
+	}
+	return prefix + t.String()
+}
+
+// funcDecls calls visit for every function declaration in the program.
+func (p *program) funcDecls(visit func(pkg *loadedPackage, file *ast.File, fn *ast.FuncDecl)) {
+	for _, pkg := range p.Packages {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok {
+					visit(pkg, f, fn)
+				}
+			}
+		}
+	}
+}
+
+// lookupFunc finds a function or method declaration by the names matchFunc
+// accepts.
+func (p *program) lookupFunc(name string) (*loadedPackage, *ast.FuncDecl, *types.Func) {
+	var foundPkg *loadedPackage
+	var foundDecl *ast.FuncDecl
+	p.funcDecls(func(pkg *loadedPackage, _ *ast.File, fn *ast.FuncDecl) {
+		if foundDecl == nil && matchFunc(fn, name) {
+			foundPkg, foundDecl = pkg, fn
+		}
+	})
+	if foundDecl == nil {
+		return nil, nil, nil
+	}
+	obj, _ := foundPkg.Info.Defs[foundDecl.Name].(*types.Func)
+	return foundPkg, foundDecl, obj
+}
+
+// declOf returns the declaration of a module function.
+func (p *program) declOf(fn *types.Func) (*loadedPackage, *ast.FuncDecl) {
+	pkg := p.pkgOf(fn)
+	if pkg == nil {
+		return nil, nil
+	}
+	fn = fn.Origin()
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && pkg.Info.Defs[d.Name] == fn {
+				return pkg, d
+			}
+		}
+	}
+	return nil, nil
+}
+
+// namedTypes returns every package-level named type declared in the module,
+// sorted by position.
+func (p *program) namedTypes() []*types.TypeName {
+	var result []*types.TypeName
+	for _, pkg := range p.Packages {
+		if pkg.Types == nil {
+			continue
+		}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !tn.IsAlias() {
+				result = append(result, tn)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Pos() < result[j].Pos() })
+	return result
+}
+
+// lookupType finds a module named type by "Type" or "pkg.Type".
+func (p *program) lookupType(name string) *types.TypeName {
+	for _, tn := range p.namedTypes() {
+		if tn.Name() == name || tn.Pkg().Name()+"."+tn.Name() == name {
+			return tn
+		}
+	}
+	return nil
+}
+
+// typeSpecOf returns the declaration of a module named type.
+func (p *program) typeSpecOf(tn *types.TypeName) (*loadedPackage, *ast.File, *ast.TypeSpec) {
+	pkg := p.pkgOf(tn)
+	if pkg == nil {
+		return nil, nil, nil
+	}
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if ts := spec.(*ast.TypeSpec); pkg.Info.Defs[ts.Name] == tn {
+					return pkg, f, ts
+				}
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+func (p *program) position(pos token.Pos) token.Position {
+	return p.Fset.Position(pos)
+}
+
+// enclosingFunc returns the top-level function declaration containing pos.
+func enclosingFunc(f *ast.File, pos token.Pos) *ast.FuncDecl {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Pos() <= pos && pos < fn.End() {
+			return fn
+		}
+	}
+	return nil
+}
+
+// funcLocation describes a function declaration the way find reports it.
+func (p *program) funcLocation(d *ast.FuncDecl) SymbolLocation {
+	pos := p.position(d.Name.Pos())
+	loc := SymbolLocation{
+		Name:      funcDeclName(d),
+		Kind:      "func",
+		File:      pos.Filename,
+		Line:      pos.Line,
+		Column:    pos.Column,
+		EndLine:   p.position(d.End()).Line,
+		Exported:  ast.IsExported(d.Name.Name),
+		Signature: formatFuncSignature(d),
+	}
+	if d.Recv != nil && len(d.Recv.List) > 0 {
+		loc.Receiver = formatExpr(d.Recv.List[0].Type)
+	}
+	p.fileMeta(pos.Filename).annotate(&loc)
+	return loc
+}
+
+// fileMeta returns what SymbolLocation reports about a file, read once.
+func (p *program) fileMeta(file string) *fileMeta {
+	m, ok := p.files[file]
+	if !ok {
+		if p.files == nil {
+			p.files = make(map[string]*fileMeta)
+		}
+		src, _ := os.ReadFile(file)
+		m = fileMetaOf(file, src)
+		p.files[file] = m
+	}
+	return m
+}
+
+func funcDeclName(fn *ast.FuncDecl) string {
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		return formatExpr(fn.Recv.List[0].Type) + "." + fn.Name.Name
+	}
+	return fn.Name.Name
+}
@@ -0,0 +1,870 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type LocalVarResult struct {
+	Success      bool     `json:"success"`
+	Name         string   `json:"name"`
+	Expression   string   `json:"expression"`
+	File         string   `json:"file"`
+	Line         int      `json:"line"`              // of the declaration
+	Replaced     int      `json:"replaced"`          // occurrences or uses replaced
+	Skipped      []string `json:"skipped,omitempty"` // identical occurrences left alone, and why
+	FilesChanged []string `json:"filesChanged"`
+	Message      string   `json:"message"`
+}
+
+type ExtractVarOptions struct {
+	All bool // also replace identical occurrences later in the same block
+}
+
+// exprRange is file:line:col-col or file:line:col-line:col.
+var exprRange = regexp.MustCompile(`^(.+):(\d+):(\d+)-(?:(\d+):)?(\d+)$`)
+
+// ExtractVar declares name := expr before the statement holding the
+// expression in rangeSpec and uses name in its place. The end column may
+// point at the last character of the expression or just past it. The
+// declaration must see the same objects the expression refers to, and
+// the expression must be evaluated unconditionally by its statement: not
+// in a loop condition, an else-if, a case list or the right operand of
+// && and ||. Nor may a call or assignment before it in the statement
+// change what it reads. With opts.All, identical occurrences later in the
+// block are replaced too when the expression has no side effects, its
+// variables are not assigned in between and no call or assignment in
+// between can change a package variable it reads or memory it reads
+// through a pointer, slice or map.
+func ExtractVar(rangeSpec, name string, opts *ExtractVarOptions) (*LocalVarResult, error) {
+	if opts == nil {
+		opts = &ExtractVarOptions{}
+	}
+	m := exprRange.FindStringSubmatch(rangeSpec)
+	if m == nil {
+		return nil, fmt.Errorf("invalid range %s, expected file:line:col-col or file:line:col-line:col", rangeSpec)
+	}
+	if !token.IsIdentifier(name) || name == "_" {
+		return nil, fmt.Errorf("invalid variable name %q", name)
+	}
+	file, err := filepath.Abs(m[1])
+	if err != nil {
+		return nil, err
+	}
+	startLine, _ := strconv.Atoi(m[2])
+	startCol, _ := strconv.Atoi(m[3])
+	endLine := startLine
+	if m[4] != "" {
+		endLine, _ = strconv.Atoi(m[4])
+	}
+	endCol, _ := strconv.Atoi(m[5])
+
+	prog, err := loadProgram(filepath.Dir(file), true)
+	if err != nil {
+		return nil, err
+	}
+	pkg, f := prog.fileNamed(file)
+	if f == nil {
+		return nil, fmt.Errorf("%s is not part of the module's packages", file)
+	}
+	tf := prog.Fset.File(f.Pos())
+	if startLine < 1 || endLine > tf.LineCount() || startLine > endLine {
+		return nil, fmt.Errorf("invalid range %s: %s has %d lines", rangeSpec, file, tf.LineCount())
+	}
+	start := tf.LineStart(startLine) + token.Pos(startCol-1)
+	end := tf.LineStart(endLine) + token.Pos(endCol-1)
+
+	var expr ast.Expr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if expr != nil || n == nil || n.Pos() > start || n.End() < end {
+			return false
+		}
+		if e, ok := n.(ast.Expr); ok && e.Pos() == start && (e.End() == end || e.End() == end+1) {
+			expr = e
+			return false
+		}
+		return true
+	})
+	if expr == nil {
+		return nil, fmt.Errorf("no expression spans %s", rangeSpec)
+	}
+	tv, ok := pkg.Info.Types[expr]
+	if !ok || !tv.IsValue() {
+		return nil, fmt.Errorf("%s is not a value", prog.text(expr.Pos(), expr.End()))
+	}
+	if _, tuple := tv.Type.(*types.Tuple); tuple {
+		return nil, fmt.Errorf("%s has several values", prog.text(expr.Pos(), expr.End()))
+	}
+	if tv.IsNil() {
+		return nil, fmt.Errorf("nil has no type to declare a variable with")
+	}
+
+	path := pathTo(f, expr.Pos(), expr.End())
+	i := enclosingStmt(path)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not inside a function body", prog.text(expr.Pos(), expr.End()))
+	}
+	stmt, list := path[i].(ast.Stmt), stmtList(path[i-1])
+	if reason := evaluatedLater(path[i:]); reason != "" {
+		return nil, fmt.Errorf("cannot extract %s: %s", prog.text(expr.Pos(), expr.End()), reason)
+	}
+	if !pureExpr(pkg.Info, expr) || readsMemory(pkg.Info, expr) {
+		if n := mutationBefore(pkg.Info, stmt, stmt.Pos(), expr.Pos()); n != nil {
+			return nil, fmt.Errorf("cannot extract %s: it would be evaluated before the %s at %s", prog.text(expr.Pos(), expr.End()), mutationKind(n), prog.position(n.Pos()))
+		}
+	}
+	scope := blockScope(pkg.Info, path, i-1)
+	if err := sameObjects(pkg.Info, scope, expr, stmt.Pos()); err != nil {
+		return nil, err
+	}
+	if _, obj := scope.LookupParent(name, stmt.Pos()); obj != nil || scope.Lookup(name) != nil {
+		return nil, fmt.Errorf("%s is already declared where the variable would go", name)
+	}
+
+	src := prog.text(expr.Pos(), expr.End())
+	decl := name + " := " + src
+	if !hasTypeAlone(prog, pkg, expr, src, tv.Type) {
+		decl = "var " + name + " " + qualifiedType(f, pkg, tv.Type) + " = " + src
+	}
+	edits := []textEdit{
+		prog.edit(stmt.Pos(), stmt.Pos(), decl+"\n"),
+		prog.edit(expr.Pos(), expr.End(), name),
+	}
+	pos := prog.position(stmt.Pos())
+	result := &LocalVarResult{Success: true, Name: name, Expression: src, File: pos.Filename, Line: pos.Line, Replaced: 1}
+
+	if opts.All {
+		more, skipped := prog.occurrences(pkg, expr, list[indexOf(list, stmt):], name)
+		for _, e := range more {
+			edits = append(edits, prog.edit(e.Pos(), e.End(), name))
+		}
+		result.Replaced += len(more)
+		result.Skipped = skipped
+	}
+
+	if result.FilesChanged, err = applyEdits(edits); err != nil {
+		return nil, err
+	}
+	result.Message = fmt.Sprintf("extracted %s into %s, %d occurrence(s) replaced", src, name, result.Replaced)
+	return result, nil
+}
+
+// occurrences finds the expressions of stmts identical to expr, referring
+// to the same objects, that can use the variable holding its value instead.
+func (p *program) occurrences(pkg *loadedPackage, expr ast.Expr, stmts []ast.Stmt, name string) (found []ast.Expr, skipped []string) {
+	shape := formatNode(p.Fset, expr)
+	var candidates []ast.Expr
+	written := make(map[ast.Expr]string)
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			e, ok := n.(ast.Expr)
+			if !ok || e == expr || formatNode(p.Fset, e) != shape || !sameUses(pkg.Info, e, expr) {
+				return true
+			}
+			candidates = append(candidates, e)
+			written[e] = isWritten(pathTo(stmt, e.Pos(), e.End()))
+			return false
+		})
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	skip := func(e ast.Expr, reason string) {
+		skipped = append(skipped, fmt.Sprintf("%s: %s", p.position(e.Pos()), reason))
+	}
+	if !pureExpr(pkg.Info, expr) {
+		for _, e := range candidates {
+			skip(e, "the expression has side effects")
+		}
+		return nil, skipped
+	}
+	var assigned []string
+	for _, obj := range usedVars(pkg.Info, expr) {
+		for _, stmt := range stmts {
+			if len(writes(pkg.Info, stmt, obj)) > 0 && !contains(assigned, obj.Name()) {
+				assigned = append(assigned, obj.Name())
+			}
+		}
+	}
+	block := &ast.BlockStmt{List: stmts, Lbrace: stmts[0].Pos(), Rbrace: stmts[len(stmts)-1].End() - 1}
+	for _, e := range candidates {
+		if len(assigned) > 0 {
+			skip(e, strings.Join(assigned, ", ")+" may change before it")
+			continue
+		}
+		if _, obj := pkg.Types.Scope().Innermost(e.Pos()).LookupParent(name, e.Pos()); obj != nil {
+			skip(e, name+" is shadowed there")
+			continue
+		}
+		if written[e] != "" {
+			skip(e, written[e])
+			continue
+		}
+		if readsMemory(pkg.Info, expr) {
+			if n := mutationBefore(pkg.Info, block, expr.End(), e.Pos()); n != nil {
+				skip(e, fmt.Sprintf("the %s at %s may change what it reads", mutationKind(n), p.position(n.Pos())))
+				continue
+			}
+		}
+		found = append(found, e)
+	}
+	return found, skipped
+}
+
+// InlineVar replaces every use of the local variable varName of funcName
+// with its initializer and deletes the declaration. The variable must be
+// declared alone, by := or var, and never assigned afterwards, and the
+// initializer must mean the same at every use: no call or assignment
+// between the declaration and a use may change a package variable it
+// reads or memory it reads through a pointer, slice or map. An initializer with side effects is only inlined
+// into a single use in the next statement, where it is evaluated before
+// anything else.
+func InlineVar(funcName, varName string) (*LocalVarResult, error) {
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+	pkg, decl, _ := prog.lookupFunc(funcName)
+	if decl == nil || decl.Body == nil {
+		return nil, fmt.Errorf("function %s not found", funcName)
+	}
+	f := fileOf(pkg, decl.Pos())
+	info := pkg.Info
+
+	var defs []*ast.Ident
+	for id, obj := range info.Defs {
+		if _, ok := obj.(*types.Var); ok && id.Name == varName && decl.Body.Pos() <= id.Pos() && id.Pos() < decl.Body.End() {
+			defs = append(defs, id)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Pos() < defs[j].Pos() })
+	switch len(defs) {
+	case 0:
+		return nil, fmt.Errorf("%s declares no local variable %s", funcName, varName)
+	case 1:
+	default:
+		var lines []string
+		for _, id := range defs {
+			lines = append(lines, strconv.Itoa(prog.position(id.Pos()).Line))
+		}
+		return nil, fmt.Errorf("%s declares %s %d times (lines %s); rename them apart first", funcName, varName, len(defs), strings.Join(lines, ", "))
+	}
+	def := defs[0]
+	obj := info.Defs[def].(*types.Var)
+
+	// The declaring statement: "v := init" or "var v = init".
+	path := pathTo(decl.Body, def.Pos(), def.End())
+	var init ast.Expr
+	i := len(path) - 2
+	switch n := path[i].(type) {
+	case *ast.AssignStmt:
+		if n.Tok == token.DEFINE && len(n.Lhs) == 1 && len(n.Rhs) == 1 {
+			init = n.Rhs[0]
+		} else if n.Tok == token.DEFINE {
+			return nil, fmt.Errorf("%s is declared together with other variables; split the statement first", varName)
+		}
+	case *ast.ValueSpec:
+		if len(n.Names) != 1 || len(n.Values) > 1 || len(path[i-1].(*ast.GenDecl).Specs) != 1 {
+			return nil, fmt.Errorf("%s is declared together with other variables; split the declaration first", varName)
+		}
+		if len(n.Values) == 0 {
+			return nil, fmt.Errorf("%s has no initializer", varName)
+		}
+		init = n.Values[0]
+		i -= 2 // the GenDecl and its DeclStmt
+	}
+	if init == nil {
+		return nil, fmt.Errorf("%s is not declared by := or var", varName)
+	}
+	stmt, ok := path[i].(ast.Stmt)
+	if !ok || stmtList(path[i-1]) == nil {
+		return nil, fmt.Errorf("%s is declared in the header of an if, for or switch", varName)
+	}
+	list := stmtList(path[i-1])
+
+	if w := writes(info, decl.Body, obj); len(w) > 0 {
+		return nil, fmt.Errorf("%s is assigned again at %s", varName, prog.position(w[0]))
+	}
+	var uses []*ast.Ident
+	for id, o := range info.Uses {
+		if o == obj {
+			uses = append(uses, id)
+		}
+	}
+	sort.Slice(uses, func(i, j int) bool { return uses[i].Pos() < uses[j].Pos() })
+
+	// Side effects: a call moved past other code, or run once per use,
+	// would change what the function does.
+	if !pureExpr(info, init) {
+		next := indexOf(list, stmt) + 1
+		if len(uses) != 1 || next >= len(list) || uses[0].Pos() < list[next].Pos() || uses[0].End() > list[next].End() {
+			return nil, fmt.Errorf("%s has side effects; it can only be inlined into a single use in the next statement", prog.text(init.Pos(), init.End()))
+		}
+		usePath := pathTo(list[next], uses[0].Pos(), uses[0].End())
+		if reason := evaluatedLater(usePath); reason != "" {
+			return nil, fmt.Errorf("%s has side effects and its use is %s", prog.text(init.Pos(), init.End()), strings.TrimPrefix(reason, "it is "))
+		}
+		var before token.Pos
+		ast.Inspect(list[next], func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr, *ast.UnaryExpr:
+				if u, ok := n.(*ast.UnaryExpr); ok && u.Op != token.ARROW {
+					return true
+				}
+				if n.End() <= uses[0].Pos() && before == token.NoPos {
+					before = n.Pos()
+				}
+			}
+			return true
+		})
+		if before != token.NoPos {
+			return nil, fmt.Errorf("%s has side effects and would run after the call at %s", prog.text(init.Pos(), init.End()), prog.position(before))
+		}
+	}
+	if readsMemory(info, init) {
+		for _, use := range uses {
+			if n := mutationBefore(info, decl.Body, stmt.End(), use.Pos()); n != nil {
+				return nil, fmt.Errorf("%s reads memory that the %s at %s may change", prog.text(init.Pos(), init.End()), mutationKind(n), prog.position(n.Pos()))
+			}
+		}
+	}
+	for _, v := range usedVars(info, init) {
+		if w := writes(info, decl.Body, v); len(w) > 0 {
+			for _, pos := range w {
+				if pos > stmt.End() {
+					return nil, fmt.Errorf("%s changes at %s, after %s is declared", v.Name(), prog.position(pos), varName)
+				}
+			}
+		}
+	}
+	for _, use := range uses {
+		scope := pkg.Types.Scope().Innermost(use.Pos())
+		if err := sameObjects(info, scope, init, use.Pos()); err != nil {
+			return nil, err
+		}
+	}
+
+	src := prog.text(init.Pos(), init.End())
+	if !hasTypeAlone(prog, pkg, init, src, obj.Type()) {
+		src = qualifiedType(f, pkg, obj.Type()) + "(" + src + ")"
+	}
+
+	var edits []textEdit
+	for _, use := range uses {
+		usePath := pathTo(decl.Body, use.Pos(), use.End())
+		text := src
+		if needsParens(init, usePath[len(usePath)-2], use) && src == prog.text(init.Pos(), init.End()) {
+			text = "(" + src + ")"
+		}
+		edits = append(edits, prog.edit(use.Pos(), use.End(), text))
+	}
+	start, end := stmt.Pos(), stmt.End()
+	if strings.TrimSpace(prog.text(prog.lineStart(start), start)) == "" && strings.TrimSpace(prog.text(end, prog.lineEnd(end))) == "" {
+		start, end = prog.lineStart(start), prog.lineEnd(end)+1
+	}
+	edits = append(edits, prog.edit(start, end, ""))
+
+	pos := prog.position(def.Pos())
+	result := &LocalVarResult{Success: true, Name: varName, Expression: prog.text(init.Pos(), init.End()), File: pos.Filename, Line: pos.Line, Replaced: len(uses)}
+	if result.FilesChanged, err = applyEdits(edits); err != nil {
+		return nil, err
+	}
+	result.Message = fmt.Sprintf("inlined %s into %d use(s)", varName, len(uses))
+	return result, nil
+}
+
+// fileNamed returns the package and syntax tree of a file of the program.
+func (p *program) fileNamed(file string) (*loadedPackage, *ast.File) {
+	for _, pkg := range p.Packages {
+		for _, f := range pkg.Files {
+			if p.position(f.Pos()).Filename == file {
+				return pkg, f
+			}
+		}
+	}
+	return nil, nil
+}
+
+// pathTo returns the nodes of root enclosing [start, end), outermost first.
+func pathTo(root ast.Node, start, end token.Pos) []ast.Node {
+	var path []ast.Node
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil || n.Pos() > start || n.End() < end {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// stmtList returns the statements of a block, case or select clause.
+func stmtList(n ast.Node) []ast.Stmt {
+	switch n := n.(type) {
+	case *ast.BlockStmt:
+		return n.List
+	case *ast.CaseClause:
+		return n.Body
+	case *ast.CommClause:
+		return n.Body
+	}
+	return nil
+}
+
+func indexOf(list []ast.Stmt, stmt ast.Stmt) int {
+	for i, s := range list {
+		if s == stmt {
+			return i
+		}
+	}
+	return -1
+}
+
+// enclosingStmt returns the index in path of the innermost statement that
+// belongs to a statement list, or -1.
+func enclosingStmt(path []ast.Node) int {
+	for i := len(path) - 1; i > 0; i-- {
+		switch path[i].(type) {
+		case *ast.CaseClause, *ast.CommClause:
+			continue
+		}
+		if stmt, ok := path[i].(ast.Stmt); ok && indexOf(stmtList(path[i-1]), stmt) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// blockScope returns the scope of the statement list path[i]; a function
+// body shares the scope of its signature.
+func blockScope(info *types.Info, path []ast.Node, i int) *types.Scope {
+	if s := info.Scopes[path[i]]; s != nil {
+		return s
+	}
+	switch fn := path[i-1].(type) {
+	case *ast.FuncDecl:
+		return info.Scopes[fn.Type]
+	case *ast.FuncLit:
+		return info.Scopes[fn.Type]
+	}
+	return nil
+}
+
+// evaluatedLater explains why the last node of path, a statement and the
+// nodes down to an expression, is not evaluated exactly once when the
+// statement starts, or returns "".
+func evaluatedLater(path []ast.Node) string {
+	for j := 0; j < len(path)-1; j++ {
+		child := path[j+1]
+		switch n := path[j].(type) {
+		case *ast.ForStmt:
+			if child == n.Cond || child == n.Post || child == n.Body {
+				return "it is evaluated on every iteration"
+			}
+		case *ast.RangeStmt:
+			if child == n.Body {
+				return "it is evaluated on every iteration"
+			}
+			if child == n.Key || child == n.Value {
+				return "it is assigned by the range clause"
+			}
+		case *ast.IfStmt:
+			if child == n.Body || child == n.Else {
+				return "it is evaluated conditionally"
+			}
+		case *ast.CaseClause:
+			return "it is evaluated only when the case is reached"
+		case *ast.CommClause:
+			return "it is part of a select"
+		case *ast.BinaryExpr:
+			if (n.Op == token.LAND || n.Op == token.LOR) && child == n.Y {
+				return "it is the right operand of " + n.Op.String() + ", evaluated conditionally"
+			}
+		case *ast.FuncLit:
+			return "it is inside a function literal"
+		}
+	}
+	return isWritten(path)
+}
+
+// isWritten explains why the expression at the end of path is a place
+// written to rather than a value, or returns "".
+func isWritten(path []ast.Node) string {
+	if len(path) < 2 {
+		return ""
+	}
+	child := path[len(path)-1]
+	switch n := path[len(path)-2].(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range n.Lhs {
+			if lhs == child {
+				return "it is assigned to"
+			}
+		}
+	case *ast.IncDecStmt:
+		return "it is assigned to"
+	case *ast.UnaryExpr:
+		if n.Op == token.AND {
+			return "its address is taken"
+		}
+	}
+	return ""
+}
+
+// sameObjects checks that every identifier of e refers at pos, in scope,
+// to the object it refers to where e is.
+func sameObjects(info *types.Info, scope *types.Scope, e ast.Expr, pos token.Pos) error {
+	var err error
+	var check func(n ast.Node) bool
+	check = func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			// Fields, methods and qualified names resolve alike anywhere.
+			ast.Inspect(sel.X, check)
+			return false
+		}
+		id, ok := n.(*ast.Ident)
+		if !ok || err != nil {
+			return err == nil
+		}
+		obj := info.Uses[id]
+		if obj == nil || obj.Parent() == nil {
+			return true
+		}
+		if _, found := scope.LookupParent(id.Name, pos); found != obj {
+			err = fmt.Errorf("%s does not refer to the same %s at the new position", id.Name, objectKind(obj))
+		}
+		return true
+	}
+	ast.Inspect(e, check)
+	return err
+}
+
+func objectKind(obj types.Object) string {
+	switch obj.(type) {
+	case *types.Var:
+		return "variable"
+	case *types.Const:
+		return "constant"
+	case *types.TypeName:
+		return "type"
+	case *types.Func:
+		return "function"
+	}
+	return "object"
+}
+
+// sameUses reports whether two identical-looking expressions refer to the
+// same objects.
+func sameUses(info *types.Info, a, b ast.Expr) bool {
+	var objs []types.Object
+	ast.Inspect(a, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			objs = append(objs, info.Uses[id])
+		}
+		return true
+	})
+	i := 0
+	same := true
+	ast.Inspect(b, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if i >= len(objs) || objs[i] != info.Uses[id] {
+				same = false
+			}
+			i++
+		}
+		return same
+	})
+	return same && i == len(objs)
+}
+
+// usedVars returns the local and package variables e reads.
+func usedVars(info *types.Info, e ast.Expr) []*types.Var {
+	var vars []*types.Var
+	ast.Inspect(e, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if v, ok := info.Uses[id].(*types.Var); ok && !v.IsField() {
+				vars = append(vars, v)
+			}
+		}
+		return true
+	})
+	return vars
+}
+
+// writes returns where root assigns v, takes its address or, for a struct
+// or array, assigns one of its fields or elements.
+func writes(info *types.Info, root ast.Node, v *types.Var) []token.Pos {
+	var positions []token.Pos
+	ast.Inspect(root, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || info.Uses[id] != v {
+			return true
+		}
+		path := pathTo(root, id.Pos(), id.End())
+		// Climb from v to the place written: v.f, v[i] of an array.
+		k := len(path) - 1
+		for k > 0 {
+			switch p := path[k-1].(type) {
+			case *ast.ParenExpr:
+				k--
+				continue
+			case *ast.SelectorExpr:
+				if sel := info.Selections[p]; p.X == path[k] && sel != nil && sel.Kind() == types.FieldVal && !isPointer(info.TypeOf(p.X)) {
+					k--
+					continue
+				}
+				if sel := info.Selections[p]; p.X == path[k] && sel != nil && sel.Kind() == types.MethodVal && !isPointer(info.TypeOf(p.X)) {
+					if recv := sel.Obj().(*types.Func).Type().(*types.Signature).Recv(); recv != nil && isPointer(recv.Type()) {
+						positions = append(positions, id.Pos())
+						return true
+					}
+				}
+			case *ast.IndexExpr:
+				if _, array := info.TypeOf(p.X).Underlying().(*types.Array); p.X == path[k] && array {
+					k--
+					continue
+				}
+			}
+			break
+		}
+		if isWritten(path[:k+1]) != "" {
+			positions = append(positions, id.Pos())
+		}
+		if k > 0 {
+			if r, ok := path[k-1].(*ast.RangeStmt); ok && r.Tok == token.ASSIGN && (r.Key == path[k] || r.Value == path[k]) {
+				positions = append(positions, id.Pos())
+			}
+		}
+		return true
+	})
+	return positions
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+// pureExpr reports whether evaluating e twice, or later, gives the same
+// value without side effects: no calls but conversions and pure builtins,
+// no channel receives. Memory e reads may still change in between; see
+// readsMemory.
+func pureExpr(info *types.Info, e ast.Expr) bool {
+	pure := true
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			pure = pureCall(info, n)
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				pure = false
+			}
+		case *ast.FuncLit, *ast.CompositeLit:
+			// A new closure or composite value each time.
+			pure = false
+		}
+		return pure
+	})
+	return pure
+}
+
+// pureCall reports whether call is a conversion or a builtin that neither
+// writes memory nor has other side effects.
+func pureCall(info *types.Info, call *ast.CallExpr) bool {
+	if tv, ok := info.Types[call.Fun]; ok && tv.IsType() {
+		return true
+	}
+	if id, ok := unparen(call.Fun).(*ast.Ident); ok {
+		if b, ok := info.Uses[id].(*types.Builtin); ok {
+			switch b.Name() {
+			case "len", "cap", "real", "imag", "complex", "min", "max":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readsMemory reports whether e reads memory that code other than an
+// assignment to its local variables can change: a package variable, or
+// memory through a pointer, or an element of a slice or map.
+func readsMemory(info *types.Info, e ast.Expr) bool {
+	reads := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if v, ok := info.Uses[n].(*types.Var); ok && v.Pkg() != nil && v.Parent() == v.Pkg().Scope() {
+				reads = true
+			}
+		case *ast.StarExpr:
+			reads = info.Types[n].IsValue()
+		case *ast.IndexExpr:
+			if tv, ok := info.Types[n]; ok && tv.IsValue() {
+				switch info.TypeOf(n.X).Underlying().(type) {
+				case *types.Slice, *types.Map, *types.Pointer, *types.Interface:
+					reads = true
+				}
+			}
+		case *ast.SelectorExpr:
+			if sel := info.Selections[n]; sel != nil && sel.Kind() == types.FieldVal && sel.Indirect() {
+				reads = true
+			}
+		}
+		return !reads
+	})
+	return reads
+}
+
+// mutationBefore returns the first call, assignment or increment of root
+// that starts at or after from and may run before the expression at pos:
+// one that ends before pos, one in a loop around pos that starts after
+// from, or anywhere after from when pos is in such a function literal.
+// It returns nil if there is none.
+func mutationBefore(info *types.Info, root ast.Node, from, pos token.Pos) ast.Node {
+	to := pos
+	for _, n := range pathTo(root, pos, pos) {
+		if n.Pos() < from {
+			continue
+		}
+		switch n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			if n.End() > to {
+				to = n.End()
+			}
+		case *ast.FuncLit:
+			to = root.End()
+		}
+	}
+	var found ast.Node
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == nil || found != nil || n.End() <= from || n.Pos() >= to {
+			return false
+		}
+		mutates := false
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			mutates = !pureCall(info, n)
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				mutates = mutates || n.Tok != token.DEFINE && sharedPlace(info, root, lhs)
+			}
+		case *ast.IncDecStmt:
+			mutates = sharedPlace(info, root, n.X)
+		case *ast.RangeStmt:
+			mutates = n.Tok == token.ASSIGN && (sharedPlace(info, root, n.Key) || sharedPlace(info, root, n.Value))
+		}
+		if mutates && n.Pos() >= from && n.End() <= to {
+			found = n
+		}
+		return found == nil
+	})
+	return found
+}
+
+// sharedPlace reports whether assigning e may change memory read through
+// a pointer, slice or map: e is not a local variable of root whose
+// address is never taken.
+func sharedPlace(info *types.Info, root ast.Node, e ast.Expr) bool {
+	if e == nil {
+		return false
+	}
+	id, ok := unparen(e).(*ast.Ident)
+	if !ok {
+		return true
+	}
+	v, ok := info.ObjectOf(id).(*types.Var)
+	if !ok || v.Pkg() == nil {
+		return false // the blank identifier
+	}
+	if v.Parent() == v.Pkg().Scope() {
+		return true
+	}
+	return addressTaken(info, root, v)
+}
+
+// addressTaken reports whether root takes the address of v or part of it,
+// by & or by calling a pointer method on it.
+func addressTaken(info *types.Info, root ast.Node, v *types.Var) bool {
+	uses := func(e ast.Expr) bool {
+		found := false
+		ast.Inspect(e, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && info.Uses[id] == v {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+	taken := false
+	ast.Inspect(root, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			taken = n.Op == token.AND && uses(n.X)
+		case *ast.SelectorExpr:
+			if sel := info.Selections[n]; sel != nil && sel.Kind() == types.MethodVal && !isPointer(info.TypeOf(n.X)) {
+				if recv := sel.Obj().(*types.Func).Type().(*types.Signature).Recv(); recv != nil && isPointer(recv.Type()) {
+					taken = uses(n.X)
+				}
+			}
+		}
+		return !taken
+	})
+	return taken
+}
+
+// mutationKind names the node mutationBefore found.
+func mutationKind(n ast.Node) string {
+	if _, ok := n.(*ast.CallExpr); ok {
+		return "call"
+	}
+	return "assignment"
+}
+
+// hasTypeAlone reports whether e, written alone as src, has type t: an
+// untyped constant such as 5 in "var n int64 = 5" defaults to another.
+func hasTypeAlone(prog *program, pkg *loadedPackage, e ast.Expr, src string, t types.Type) bool {
+	alone, err := types.Eval(prog.Fset, pkg.Types, e.Pos(), src)
+	if err != nil {
+		return true
+	}
+	return types.Identical(types.Default(alone.Type), t)
+}
+
+// needsParens reports whether e needs parentheses in place of child, an
+// operand of parent.
+func needsParens(e ast.Expr, parent, child ast.Node) bool {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		switch p := parent.(type) {
+		case *ast.BinaryExpr:
+			// a - (b - c) keeps them, a + b*c does not need them.
+			prec, parentPrec := e.Op.Precedence(), p.Op.Precedence()
+			return prec < parentPrec || prec == parentPrec && p.Y == child
+		case *ast.UnaryExpr, *ast.StarExpr:
+			return true
+		}
+	case *ast.UnaryExpr, *ast.StarExpr:
+	default:
+		return false
+	}
+	switch p := parent.(type) {
+	case *ast.SelectorExpr:
+		return p.X == child
+	case *ast.IndexExpr:
+		return p.X == child
+	case *ast.SliceExpr:
+		return p.X == child
+	case *ast.TypeAssertExpr:
+		return p.X == child
+	case *ast.CallExpr:
+		return p.Fun == child
+	}
+	return false
+}
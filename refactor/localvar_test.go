@@ -0,0 +1,153 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestExtractVar(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"a.go": `package app
+
+func Area(x, scale int) int {
+	if x*scale > 10 {
+		return x*scale + 1
+	}
+	return x * scale
+}
+`,
+		"b.go": `package app
+
+type counter struct{ n int }
+
+func (c *counter) inc() { c.n++ }
+
+func Twice(p *counter) int {
+	a := p.n * 2
+	p.inc()
+	b := p.n * 2
+	return a + b
+}
+`,
+		"c.go": `package app
+
+var total int
+
+func bump() { total++ }
+
+func Next() int {
+	a := total + 1
+	bump()
+	b := total + 1
+	return a + b
+}
+`,
+	})
+	chdir(t, dir)
+
+	if _, err := refactor.ExtractVar("a.go:4:5-12", "scale", nil); err == nil {
+		t.Error("expected a name already in scope to be refused")
+	}
+	result, err := refactor.ExtractVar("a.go:4:5-12", "w", &refactor.ExtractVarOptions{All: true})
+	if err != nil {
+		t.Fatalf("ExtractVar error: %v", err)
+	}
+	if result.Replaced != 3 {
+		t.Errorf("expected 3 occurrences replaced, got %d", result.Replaced)
+	}
+	content := readModuleFile(t, dir, "a.go")
+	for _, s := range []string{"w := x * scale\n\tif w > 10", "return w + 1", "return w\n"} {
+		if !strings.Contains(content, s) {
+			t.Errorf("expected %q:\n%s", s, content)
+		}
+	}
+
+	// p.inc() changes p.n between the two occurrences.
+	result, err = refactor.ExtractVar("b.go:8:7-13", "w", &refactor.ExtractVarOptions{All: true})
+	if err != nil {
+		t.Fatalf("ExtractVar error: %v", err)
+	}
+	if result.Replaced != 1 || len(result.Skipped) != 1 {
+		t.Errorf("expected 1 occurrence replaced and 1 skipped, got %d and %v", result.Replaced, result.Skipped)
+	}
+	if content := readModuleFile(t, dir, "b.go"); !strings.Contains(content, "b := p.n * 2") {
+		t.Errorf("expected the occurrence after p.inc() kept:\n%s", content)
+	}
+
+	// bump() changes the package variable total.
+	result, err = refactor.ExtractVar("c.go:8:7-16", "w", &refactor.ExtractVarOptions{All: true})
+	if err != nil {
+		t.Fatalf("ExtractVar error: %v", err)
+	}
+	if result.Replaced != 1 || len(result.Skipped) != 1 {
+		t.Errorf("expected 1 occurrence replaced and 1 skipped, got %d and %v", result.Replaced, result.Skipped)
+	}
+	if content := readModuleFile(t, dir, "c.go"); !strings.Contains(content, "b := total + 1") {
+		t.Errorf("expected the occurrence after bump() kept:\n%s", content)
+	}
+}
+
+func TestInlineVar(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"a.go": `package app
+
+func next() int { return 1 }
+
+func Sum(xs []int, y int64) int64 {
+	var n int64 = 2
+	a := next()
+	b := next()
+	total := int64(len(xs)) * n
+	return total + y*n + int64(b) + int64(a)
+}
+
+func First(xs []int) int {
+	v := xs[0]
+	xs[0] = 5
+	return v
+}
+
+var counter int
+
+func bump() { counter++ }
+
+func Counted() int {
+	n := counter
+	bump()
+	return n
+}
+`,
+	})
+	chdir(t, dir)
+
+	if _, err := refactor.InlineVar("Sum", "n"); err != nil {
+		t.Fatalf("InlineVar error: %v", err)
+	}
+	content := readModuleFile(t, dir, "a.go")
+	if !strings.Contains(content, "int64(len(xs)) * int64(2)") || strings.Contains(content, "var n") {
+		t.Errorf("expected n inlined as int64(2):\n%s", content)
+	}
+
+	// next() would run after the second call.
+	if _, err := refactor.InlineVar("Sum", "a"); err == nil {
+		t.Error("expected inlining a call past another call to fail")
+	}
+	// xs[0] = 5 changes what v was read from.
+	if _, err := refactor.InlineVar("First", "v"); err == nil {
+		t.Error("expected inlining an element read past a write to it to fail")
+	}
+	// bump() changes the package variable counter.
+	if _, err := refactor.InlineVar("Counted", "n"); err == nil {
+		t.Error("expected inlining a package variable read past a call to fail")
+	}
+	if _, err := refactor.InlineVar("Sum", "total"); err != nil {
+		t.Fatalf("InlineVar error: %v", err)
+	}
+	if content := readModuleFile(t, dir, "a.go"); !strings.Contains(content, "return int64(len(xs))*int64(2) + y*int64(2)") {
+		t.Errorf("expected total inlined:\n%s", content)
+	}
+}
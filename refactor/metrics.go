@@ -0,0 +1,308 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type FuncMetrics struct {
+	SymbolLocation
+	Package    string `json:"package"`
+	Cyclomatic int    `json:"cyclomatic"`
+	Cognitive  int    `json:"cognitive"`
+	Lines      int    `json:"lines"`
+	Params     int    `json:"params"`
+	Results    int    `json:"results"`
+	Nesting    int    `json:"nesting"` // deepest nesting of control structures
+	FanIn      int    `json:"fanIn"`   // distinct module functions calling it
+	FanOut     int    `json:"fanOut"`  // distinct functions it calls
+}
+
+type PackageMetrics struct {
+	Package       string  `json:"package"`
+	Functions     int     `json:"functions"`
+	Lines         int     `json:"lines"`
+	Cyclomatic    int     `json:"cyclomatic"`
+	Cognitive     int     `json:"cognitive"`
+	MaxCyclomatic int     `json:"maxCyclomatic"`
+	AvgCyclomatic float64 `json:"avgCyclomatic"`
+}
+
+type MetricsResult struct {
+	Success   bool             `json:"success"`
+	Sort      string           `json:"sort,omitempty"`
+	Functions []FuncMetrics    `json:"functions"`
+	Packages  []PackageMetrics `json:"packages"`
+	Count     int              `json:"count"`
+}
+
+type MetricsOptions struct {
+	Sort string // complexity, cognitive, lines, nesting, params, fanin, fanout
+	Top  int    // keep only the first N functions after sorting
+}
+
+// metricsSorts maps --sort values to the metric sorted by, descending.
+var metricsSorts = map[string]func(m *FuncMetrics) int{
+	"complexity": func(m *FuncMetrics) int { return m.Cyclomatic },
+	"cyclomatic": func(m *FuncMetrics) int { return m.Cyclomatic },
+	"cognitive":  func(m *FuncMetrics) int { return m.Cognitive },
+	"lines":      func(m *FuncMetrics) int { return m.Lines },
+	"nesting":    func(m *FuncMetrics) int { return m.Nesting },
+	"params":     func(m *FuncMetrics) int { return m.Params },
+	"fanin":      func(m *FuncMetrics) int { return m.FanIn },
+	"fanout":     func(m *FuncMetrics) int { return m.FanOut },
+}
+
+// Metrics measures every function of the package in pkgPath, or of the
+// whole module when pkgPath is empty or "./...". Function names are those
+// read accepts; package totals cover all measured functions even when
+// opts.Top cuts the list.
+func Metrics(pkgPath string, opts *MetricsOptions) (*MetricsResult, error) {
+	if opts == nil {
+		opts = &MetricsOptions{}
+	}
+	key := metricsSorts[opts.Sort]
+	if opts.Sort != "" && key == nil {
+		return nil, fmt.Errorf("unknown sort %s (want complexity, cognitive, lines, nesting, params, fanin or fanout)", opts.Sort)
+	}
+
+	prog, err := loadProgram(".", false)
+	if err != nil {
+		return nil, err
+	}
+	var scope string
+	if pkgPath != "" && pkgPath != "./..." {
+		scope, err = filepath.Abs(strings.TrimSuffix(pkgPath, "/..."))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Fan-in and fan-out over the whole program, so that callers outside
+	// the measured package count.
+	fanIn := make(map[*types.Func]map[*types.Func]bool)
+	fanOut := make(map[*types.Func]map[*types.Func]bool)
+	prog.callSites(func(cs callSite) {
+		if cs.caller == nil || cs.callee == nil {
+			return
+		}
+		caller, ok := cs.pkg.Info.Defs[cs.caller.Name].(*types.Func)
+		if !ok {
+			return
+		}
+		callee := cs.callee.Origin()
+		if fanOut[caller] == nil {
+			fanOut[caller] = make(map[*types.Func]bool)
+		}
+		fanOut[caller][callee] = true
+		if fanIn[callee] == nil {
+			fanIn[callee] = make(map[*types.Func]bool)
+		}
+		fanIn[callee][caller] = true
+	})
+
+	result := &MetricsResult{Success: true, Sort: opts.Sort, Functions: []FuncMetrics{}, Packages: []PackageMetrics{}}
+	for _, pkg := range prog.Packages {
+		if pkg.Info == nil || (scope != "" && !withinDir(pkg.Dir, scope, strings.HasSuffix(pkgPath, "/..."))) {
+			continue
+		}
+		totals := PackageMetrics{Package: pkg.ImportPath}
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				d, ok := decl.(*ast.FuncDecl)
+				if !ok || d.Body == nil {
+					continue
+				}
+				fn, _ := pkg.Info.Defs[d.Name].(*types.Func)
+				m := FuncMetrics{
+					SymbolLocation: prog.funcLocation(d),
+					Package:        pkg.ImportPath,
+					Lines:          prog.position(d.End()).Line - prog.position(d.Pos()).Line + 1,
+					Params:         fieldCount(d.Type.Params),
+					Results:        fieldCount(d.Type.Results),
+					FanIn:          len(fanIn[fn]),
+					FanOut:         len(fanOut[fn]),
+				}
+				c := &complexity{info: pkg.Info, fn: fn, cyclomatic: 1}
+				c.walk(d.Body, 0)
+				m.Cyclomatic, m.Cognitive, m.Nesting = c.cyclomatic, c.cognitive, c.maxNesting
+
+				totals.Functions++
+				totals.Lines += m.Lines
+				totals.Cyclomatic += m.Cyclomatic
+				totals.Cognitive += m.Cognitive
+				if m.Cyclomatic > totals.MaxCyclomatic {
+					totals.MaxCyclomatic = m.Cyclomatic
+				}
+				result.Functions = append(result.Functions, m)
+			}
+		}
+		if totals.Functions > 0 {
+			totals.AvgCyclomatic = math.Round(10*float64(totals.Cyclomatic)/float64(totals.Functions)) / 10
+			result.Packages = append(result.Packages, totals)
+		}
+	}
+	sort.Slice(result.Packages, func(i, j int) bool { return result.Packages[i].Package < result.Packages[j].Package })
+
+	if key != nil {
+		sort.SliceStable(result.Functions, func(i, j int) bool {
+			return key(&result.Functions[i]) > key(&result.Functions[j])
+		})
+	}
+	if opts.Top > 0 && len(result.Functions) > opts.Top {
+		result.Functions = result.Functions[:opts.Top]
+	}
+	result.Count = len(result.Functions)
+	return result, nil
+}
+
+// fieldCount counts the parameters or results a field list declares.
+func fieldCount(fields *ast.FieldList) int {
+	if fields == nil {
+		return 0
+	}
+	n := 0
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			n++
+		}
+		n += len(field.Names)
+	}
+	return n
+}
+
+// complexity measures one function body. Cyclomatic complexity counts the
+// decision points plus one. Cognitive complexity follows the SonarSource
+// rules: control structures add one plus their nesting level, else and
+// else-if add one, every run of a logical operator adds one, as do labeled
+// jumps and recursive calls; function literals nest.
+type complexity struct {
+	info       *types.Info
+	fn         *types.Func
+	cyclomatic int
+	cognitive  int
+	maxNesting int
+}
+
+func (c *complexity) walk(n ast.Node, nesting int) {
+	if n == nil {
+		return
+	}
+	if nesting > c.maxNesting {
+		c.maxNesting = nesting
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt:
+			c.ifStmt(n, nesting, false)
+			return false
+		case *ast.ForStmt:
+			c.cyclomatic++
+			c.cognitive += 1 + nesting
+			c.walk(n.Init, nesting)
+			c.walk(n.Cond, nesting)
+			c.walk(n.Post, nesting)
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.RangeStmt:
+			c.cyclomatic++
+			c.cognitive += 1 + nesting
+			c.walk(n.X, nesting)
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.SwitchStmt:
+			c.cognitive += 1 + nesting
+			c.walk(n.Init, nesting)
+			c.walk(n.Tag, nesting)
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.TypeSwitchStmt:
+			c.cognitive += 1 + nesting
+			c.walk(n.Init, nesting)
+			c.walk(n.Assign, nesting)
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.SelectStmt:
+			c.cognitive += 1 + nesting
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.CaseClause:
+			if n.List != nil {
+				c.cyclomatic++
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				c.cyclomatic++
+			}
+		case *ast.FuncLit:
+			c.walk(n.Body, nesting+1)
+			return false
+		case *ast.BinaryExpr:
+			if n.Op != token.LAND && n.Op != token.LOR {
+				return true
+			}
+			var ops []token.Token
+			var operands []ast.Expr
+			logicalOps(n, &ops, &operands)
+			for i, op := range ops {
+				c.cyclomatic++
+				if i == 0 || op != ops[i-1] {
+					c.cognitive++
+				}
+			}
+			for _, e := range operands {
+				c.walk(e, nesting)
+			}
+			return false
+		case *ast.BranchStmt:
+			if n.Label != nil {
+				c.cognitive++
+			}
+		case *ast.CallExpr:
+			if callee, _, ok := resolveCall(c.info, n); ok && callee != nil && c.fn != nil && callee.Origin() == c.fn {
+				c.cognitive++
+			}
+		}
+		return true
+	})
+}
+
+// ifStmt handles an if and its else chain; an else-if adds one without
+// counting nesting.
+func (c *complexity) ifStmt(n *ast.IfStmt, nesting int, elseIf bool) {
+	c.cyclomatic++
+	if elseIf {
+		c.cognitive++
+	} else {
+		c.cognitive += 1 + nesting
+	}
+	c.walk(n.Init, nesting)
+	c.walk(n.Cond, nesting)
+	c.walk(n.Body, nesting+1)
+	switch e := n.Else.(type) {
+	case *ast.IfStmt:
+		c.ifStmt(e, nesting, true)
+	case *ast.BlockStmt:
+		c.cognitive++
+		c.walk(e, nesting+1)
+	}
+}
+
+// logicalOps flattens a chain of && and || into its operators, in source
+// order, and the operands between them.
+func logicalOps(e ast.Expr, ops *[]token.Token, operands *[]ast.Expr) {
+	e = unparen(e)
+	if b, ok := e.(*ast.BinaryExpr); ok && (b.Op == token.LAND || b.Op == token.LOR) {
+		logicalOps(b.X, ops, operands)
+		*ops = append(*ops, b.Op)
+		logicalOps(b.Y, ops, operands)
+		return
+	}
+	*operands = append(*operands, e)
+}
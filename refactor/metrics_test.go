@@ -0,0 +1,61 @@
+package refactor_test
+
+import (
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const metricsModule = `package app
+
+import "errors"
+
+func Classify(n int, ok bool) (string, error) {
+	if n < 0 && ok {
+		return "", errors.New("negative")
+	}
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			continue
+		}
+	}
+	switch {
+	case n > 10:
+		return "big", nil
+	default:
+	}
+	return "small", nil
+}
+
+func Use() {
+	Classify(1, true)
+	Classify(2, false)
+}
+`
+
+func TestMetrics(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": metricsModule})
+	chdir(t, dir)
+
+	result, err := refactor.Metrics("", &refactor.MetricsOptions{Sort: "complexity", Top: 1})
+	if err != nil {
+		t.Fatalf("Metrics error: %v", err)
+	}
+	if result.Count != 1 {
+		t.Fatalf("expected the top function only, got %d", result.Count)
+	}
+	m := result.Functions[0]
+	want := refactor.FuncMetrics{Cyclomatic: 6, Cognitive: 6, Lines: 16, Params: 2, Results: 2, Nesting: 2, FanIn: 1, FanOut: 1}
+	if m.Name != "Classify" || m.Cyclomatic != want.Cyclomatic || m.Cognitive != want.Cognitive || m.Lines != want.Lines ||
+		m.Params != want.Params || m.Results != want.Results || m.Nesting != want.Nesting || m.FanIn != want.FanIn || m.FanOut != want.FanOut {
+		t.Errorf("unexpected metrics for %s: %+v", m.Name, m)
+	}
+
+	if len(result.Packages) != 1 || result.Packages[0].Functions != 2 || result.Packages[0].Cyclomatic != 7 || result.Packages[0].MaxCyclomatic != 6 {
+		t.Errorf("unexpected package totals: %+v", result.Packages)
+	}
+
+	if _, err := refactor.Metrics("", &refactor.MetricsOptions{Sort: "size"}); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+}
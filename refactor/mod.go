@@ -0,0 +1,434 @@
+package refactor
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+type ModRequirement struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Indirect bool   `json:"indirect,omitempty"`
+}
+
+type ModReplacement struct {
+	Old        string `json:"old"`
+	OldVersion string `json:"oldVersion,omitempty"`
+	New        string `json:"new"`
+	NewVersion string `json:"newVersion,omitempty"`
+}
+
+type ModExclusion struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+type ModResult struct {
+	Success      bool             `json:"success"`
+	File         string           `json:"file"`
+	Module       string           `json:"module"`
+	Go           string           `json:"go,omitempty"`
+	Toolchain    string           `json:"toolchain,omitempty"`
+	Requires     []ModRequirement `json:"requires"`
+	Replaces     []ModReplacement `json:"replaces,omitempty"`
+	Excludes     []ModExclusion   `json:"excludes,omitempty"`
+	Message      string           `json:"message,omitempty"`
+	Warnings     []string         `json:"warnings,omitempty"`
+	FilesChanged []string         `json:"filesChanged,omitempty"`
+}
+
+type ModImporter struct {
+	Package string `json:"package"`
+	Import  string `json:"import"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+}
+
+type ModWhyResult struct {
+	Success   bool          `json:"success"`
+	Path      string        `json:"path"`
+	Required  string        `json:"required,omitempty"` // version in go.mod
+	Indirect  bool          `json:"indirect,omitempty"`
+	Importers []ModImporter `json:"importers"`
+	Count     int           `json:"count"`
+	Message   string        `json:"message,omitempty"`
+}
+
+// modFile is the go.mod of the module containing the working directory.
+type modFile struct {
+	path string
+	data []byte
+	f    *modfile.File
+}
+
+func loadModFile() (*modFile, error) {
+	root, _, err := findModuleRoot(".")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(root, "go.mod")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &modFile{path: path, data: data, f: f}, nil
+}
+
+// result describes the go.mod as ModShow reports it.
+func (m *modFile) result() *ModResult {
+	r := &ModResult{Success: true, File: m.path, Requires: []ModRequirement{}}
+	if m.f.Module != nil {
+		r.Module = m.f.Module.Mod.Path
+	}
+	if m.f.Go != nil {
+		r.Go = m.f.Go.Version
+	}
+	if m.f.Toolchain != nil {
+		r.Toolchain = m.f.Toolchain.Name
+	}
+	for _, req := range m.f.Require {
+		r.Requires = append(r.Requires, ModRequirement{Path: req.Mod.Path, Version: req.Mod.Version, Indirect: req.Indirect})
+	}
+	for _, rep := range m.f.Replace {
+		r.Replaces = append(r.Replaces, ModReplacement{Old: rep.Old.Path, OldVersion: rep.Old.Version, New: rep.New.Path, NewVersion: rep.New.Version})
+	}
+	for _, ex := range m.f.Exclude {
+		r.Excludes = append(r.Excludes, ModExclusion{Path: ex.Mod.Path, Version: ex.Mod.Version})
+	}
+	return r
+}
+
+// write formats and saves the go.mod, and returns the result describing it.
+func (m *modFile) write(message string) (*ModResult, error) {
+	m.f.Cleanup()
+	data, err := m.f.Format()
+	if err != nil {
+		return nil, err
+	}
+	r := m.result()
+	r.Message = message
+	if !bytes.Equal(data, m.data) {
+		if err := os.WriteFile(m.path, data, 0644); err != nil {
+			return nil, err
+		}
+		r.FilesChanged = []string{m.path}
+	}
+	return r, nil
+}
+
+// ModShow reports the requires, replaces, excludes and toolchain of go.mod.
+func ModShow() (*ModResult, error) {
+	m, err := loadModFile()
+	if err != nil {
+		return nil, err
+	}
+	return m.result(), nil
+}
+
+// ModRequire adds or updates a requirement given as path@version. It works
+// offline: the version must be in the module cache, from where its go.mod
+// is checked and its go.sum lines are taken. A dependency declaring a newer
+// go version than this module is refused, since the go command would raise
+// the go directive.
+func ModRequire(spec string) (*ModResult, error) {
+	path, version, ok := strings.Cut(spec, "@")
+	if !ok || version == "" {
+		return nil, fmt.Errorf("expected path@version, got %s", spec)
+	}
+	if err := module.CheckPath(path); err != nil {
+		return nil, err
+	}
+	if !semver.IsValid(version) || semver.Canonical(version) != version {
+		return nil, fmt.Errorf("invalid version %s: want a full semantic version like v1.2.3", version)
+	}
+	m, err := loadModFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	cached, err := moduleCacheFiles(path, version)
+	if err != nil {
+		return nil, err
+	}
+	if cached.mod == "" {
+		return nil, fmt.Errorf("%s@%s is not in the module cache; run go mod download %s@%s first", path, version, path, version)
+	}
+	modData, err := os.ReadFile(cached.mod)
+	if err != nil {
+		return nil, err
+	}
+	if dep, err := modfile.ParseLax(cached.mod, modData, nil); err == nil && dep.Go != nil && m.f.Go != nil &&
+		semver.Compare("v"+dep.Go.Version, "v"+m.f.Go.Version) > 0 {
+		return nil, fmt.Errorf("%s@%s requires go %s, newer than go %s of this module", path, version, dep.Go.Version, m.f.Go.Version)
+	}
+
+	sums, err := cached.sums(path, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(sums) < 2 {
+		warnings = append(warnings, fmt.Sprintf("only the go.mod of %s@%s is cached; go.sum lacks its module hash until it is downloaded", path, version))
+	}
+
+	old := ""
+	for _, req := range m.f.Require {
+		if req.Mod.Path == path {
+			old = req.Mod.Version
+		}
+	}
+	if err := m.f.AddRequire(path, version); err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("required %s %s", path, version)
+	if old != "" && old != version {
+		message = fmt.Sprintf("changed %s from %s to %s", path, old, version)
+	}
+	result, err := m.write(message)
+	if err != nil {
+		return nil, err
+	}
+	sumFile := filepath.Join(filepath.Dir(m.path), "go.sum")
+	if changed, err := addSums(sumFile, sums); err != nil {
+		return nil, err
+	} else if changed {
+		result.FilesChanged = append(result.FilesChanged, sumFile)
+	}
+	result.Warnings = warnings
+	return result, nil
+}
+
+// ModReplace adds a replace directive given as old[@v]=>new[@v]. A
+// replacement that is not a local directory needs a version.
+func ModReplace(spec string) (*ModResult, error) {
+	oldSpec, newSpec, ok := strings.Cut(spec, "=>")
+	if !ok {
+		return nil, fmt.Errorf("expected old=>new, got %s", spec)
+	}
+	oldPath, oldVersion, _ := strings.Cut(strings.TrimSpace(oldSpec), "@")
+	newSpec = strings.TrimSpace(newSpec)
+	newPath, newVersion := newSpec, ""
+	if !modfile.IsDirectoryPath(newSpec) {
+		newPath, newVersion, _ = strings.Cut(newSpec, "@")
+		if newVersion == "" {
+			return nil, fmt.Errorf("replacement %s needs a version, or must be a directory starting with ./ or ../", newSpec)
+		}
+	}
+
+	m, err := loadModFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.f.AddReplace(oldPath, oldVersion, newPath, newVersion); err != nil {
+		return nil, err
+	}
+	result, err := m.write(fmt.Sprintf("replaced %s with %s", strings.TrimSpace(oldSpec), newSpec))
+	if err != nil {
+		return nil, err
+	}
+	if newVersion == "" {
+		dir := newPath
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(m.path), dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s has no go.mod", newPath))
+		}
+	}
+	return result, nil
+}
+
+// ModDrop removes the requirement on path and any replace of it. Packages
+// still importing it are reported as warnings.
+func ModDrop(path string) (*ModResult, error) {
+	m, err := loadModFile()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, req := range m.f.Require {
+		found = found || req.Mod.Path == path
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not required by %s", path, m.path)
+	}
+	if err := m.f.DropRequire(path); err != nil {
+		return nil, err
+	}
+	for _, rep := range m.f.Replace {
+		if rep.Old.Path == path {
+			if err := m.f.DropReplace(path, rep.Old.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	result, err := m.write("dropped " + path)
+	if err != nil {
+		return nil, err
+	}
+	if why, err := ModWhy(path); err == nil {
+		for _, imp := range why.Importers {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d still imports %s", imp.File, imp.Line, imp.Import))
+		}
+	}
+	return result, nil
+}
+
+// ModWhy lists the packages of the workspace, tests included, that import
+// path or a package below it.
+func ModWhy(path string) (*ModWhyResult, error) {
+	ws, err := findWorkspace(".")
+	if err != nil {
+		return nil, err
+	}
+	result := &ModWhyResult{Success: true, Path: path, Importers: []ModImporter{}}
+	if m, err := loadModFile(); err == nil {
+		for _, req := range m.f.Require {
+			if req.Mod.Path == path {
+				result.Required, result.Indirect = req.Mod.Version, req.Indirect
+			}
+		}
+	}
+
+	fset := token.NewFileSet()
+	ws.walkPackageDirs(func(_ *wsModule, dir string) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+				continue
+			}
+			file := filepath.Join(dir, e.Name())
+			f, err := parser.ParseFile(fset, file, nil, parser.ImportsOnly)
+			if err != nil {
+				continue
+			}
+			for _, imp := range f.Imports {
+				target, err := strconv.Unquote(imp.Path.Value)
+				if err != nil || (target != path && !strings.HasPrefix(target, path+"/")) {
+					continue
+				}
+				pkg := ws.importPath(dir)
+				if strings.HasSuffix(e.Name(), "_test.go") && strings.HasSuffix(f.Name.Name, "_test") {
+					pkg += "_test"
+				}
+				result.Importers = append(result.Importers, ModImporter{
+					Package: pkg,
+					Import:  target,
+					File:    file,
+					Line:    fset.Position(imp.Pos()).Line,
+				})
+			}
+		}
+	})
+	sort.SliceStable(result.Importers, func(i, j int) bool { return result.Importers[i].Package < result.Importers[j].Package })
+	result.Count = len(result.Importers)
+	switch {
+	case result.Count > 0:
+		result.Message = fmt.Sprintf("%s is imported by %d file(s)", path, result.Count)
+	case result.Required != "":
+		result.Message = fmt.Sprintf("%s is required but not imported by the workspace; it is needed by another dependency or can be dropped", path)
+	default:
+		result.Message = fmt.Sprintf("%s is not imported", path)
+	}
+	return result, nil
+}
+
+// cachedModule locates the files of a module version in the download
+// cache of GOMODCACHE.
+type cachedModule struct {
+	mod     string // the version's go.mod
+	ziphash string // h1 hash of the version's zip, when downloaded
+}
+
+func moduleCacheFiles(path, version string) (*cachedModule, error) {
+	cache := os.Getenv("GOMODCACHE")
+	if cache == "" {
+		out, err := exec.Command("go", "env", "GOMODCACHE").Output()
+		if err != nil {
+			return nil, fmt.Errorf("go env GOMODCACHE: %v", err)
+		}
+		cache = strings.TrimSpace(string(out))
+	}
+	escPath, err := module.EscapePath(path)
+	if err != nil {
+		return nil, err
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(cache, "cache", "download", filepath.FromSlash(escPath), "@v", escVersion)
+	c := &cachedModule{}
+	if _, err := os.Stat(base + ".mod"); err == nil {
+		c.mod = base + ".mod"
+	}
+	if _, err := os.Stat(base + ".ziphash"); err == nil {
+		c.ziphash = base + ".ziphash"
+	}
+	return c, nil
+}
+
+// sums returns the go.sum lines of the cached version: the go.mod hash and,
+// if the zip was downloaded, the module hash.
+func (c *cachedModule) sums(path, version string) ([]string, error) {
+	modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return os.Open(c.mod)
+	})
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{fmt.Sprintf("%s %s/go.mod %s", path, version, modHash)}
+	if c.ziphash != "" {
+		data, err := os.ReadFile(c.ziphash)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", path, version, strings.TrimSpace(string(data))))
+	}
+	return lines, nil
+}
+
+// addSums adds lines missing from go.sum, keeping it sorted.
+func addSums(file string, lines []string) (bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	existing := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(data) == 0 {
+		existing = nil
+	}
+	changed := false
+	for _, line := range lines {
+		if !contains(existing, line) {
+			existing = append(existing, line)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	sort.Strings(existing)
+	return true, os.WriteFile(file, []byte(strings.Join(existing, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,65 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+func TestMod(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.21\n",
+		"app.go":     "package app\n\nimport \"golang.org/x/mod/semver\"\n\nvar Valid = semver.IsValid\n",
+		"lib/go.mod": "module example.com/lib\n\ngo 1.21\n",
+	})
+	chdir(t, dir)
+
+	// The module this repository builds against is in the cache.
+	result, err := refactor.ModRequire("golang.org/x/mod@v0.20.0")
+	if err != nil {
+		t.Fatalf("ModRequire error: %v", err)
+	}
+	if len(result.Requires) != 1 || result.Requires[0].Version != "v0.20.0" {
+		t.Errorf("expected golang.org/x/mod v0.20.0 required, got %+v", result.Requires)
+	}
+	if sum := readModuleFile(t, dir, "go.sum"); !strings.Contains(sum, "golang.org/x/mod v0.20.0/go.mod h1:") {
+		t.Errorf("expected go.sum line for the go.mod, got:\n%s", sum)
+	}
+	if _, err := refactor.ModRequire("golang.org/x/mod@latest"); err == nil {
+		t.Error("expected an error for a version that is not semantic")
+	}
+
+	why, err := refactor.ModWhy("golang.org/x/mod")
+	if err != nil {
+		t.Fatalf("ModWhy error: %v", err)
+	}
+	if why.Required != "v0.20.0" || why.Count != 1 || why.Importers[0].Import != "golang.org/x/mod/semver" {
+		t.Errorf("unexpected importers: %+v", why)
+	}
+
+	if _, err := refactor.ModReplace("example.com/lib=>./lib"); err != nil {
+		t.Fatalf("ModReplace error: %v", err)
+	}
+	if _, err := refactor.ModReplace("example.com/lib=>example.com/fork"); err == nil {
+		t.Error("expected an error for a module replacement without a version")
+	}
+	show, err := refactor.ModShow()
+	if err != nil {
+		t.Fatalf("ModShow error: %v", err)
+	}
+	if show.Module != "example.com/app" || show.Go != "1.21" || len(show.Replaces) != 1 || show.Replaces[0].New != "./lib" {
+		t.Errorf("unexpected go.mod: %+v", show)
+	}
+
+	drop, err := refactor.ModDrop("golang.org/x/mod")
+	if err != nil {
+		t.Fatalf("ModDrop error: %v", err)
+	}
+	if len(drop.Requires) != 0 || len(drop.Warnings) != 1 {
+		t.Errorf("expected the requirement dropped with a warning about app.go, got %+v", drop)
+	}
+	if content := readModuleFile(t, dir, "go.mod"); strings.Contains(content, "golang.org/x/mod") || !strings.Contains(content, "example.com/lib => ./lib") {
+		t.Errorf("unexpected go.mod:\n%s", content)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -56,15 +58,96 @@ func funcAtLine(file string, line int) string {
 }
 
 type ProjectInfo struct {
-	Success   bool     `json:"success"`
-	Name      string   `json:"name"`
-	Path      string   `json:"path"`
-	Module    string   `json:"module,omitempty"`
-	GoVersion string   `json:"goVersion,omitempty"`
-	Packages  int      `json:"packages"`
-	GoFiles   int      `json:"goFiles"`
-	TestFiles int      `json:"testFiles"`
-	Dirs      []string `json:"dirs"`
+	Success   bool         `json:"success"`
+	Name      string       `json:"name"`
+	Path      string       `json:"path"`
+	Module    string       `json:"module,omitempty"`
+	GoVersion string       `json:"goVersion,omitempty"`
+	Workspace string       `json:"workspace,omitempty"` // go.work in use
+	Packages  int          `json:"packages"`
+	GoFiles   int          `json:"goFiles"`
+	TestFiles int          `json:"testFiles"`
+	Dirs      []string     `json:"dirs"`
+	Modules   []ModuleInfo `json:"modules,omitempty"`
+}
+
+// ModuleInfo is the share of one module in a project or package listing.
+type ModuleInfo struct {
+	Path      string `json:"path"`
+	Dir       string `json:"dir"`
+	GoVersion string `json:"goVersion,omitempty"`
+	Packages  int    `json:"packages"`
+	GoFiles   int    `json:"goFiles"`
+	TestFiles int    `json:"testFiles,omitempty"`
+}
+
+// moduleBreakdown keeps a ModuleInfo per workspace module, in module order,
+// for the modules under dir or holding any counted file.
+type moduleBreakdown struct {
+	ws    *workspace
+	dir   string
+	infos map[*wsModule]*ModuleInfo
+	dirs  map[string]bool
+}
+
+func newModuleBreakdown(dir string) *moduleBreakdown {
+	ws, err := findWorkspace(dir)
+	if err != nil {
+		return nil
+	}
+	return &moduleBreakdown{ws: ws, dir: dir, infos: make(map[*wsModule]*ModuleInfo), dirs: make(map[string]bool)}
+}
+
+// add counts a Go file of the package in pkgDir.
+func (b *moduleBreakdown) add(pkgDir string, test bool) {
+	if b == nil {
+		return
+	}
+	info := b.info(b.ws.moduleOf(pkgDir))
+	if info == nil {
+		return
+	}
+	if !b.dirs[pkgDir] {
+		b.dirs[pkgDir] = true
+		info.Packages++
+	}
+	if test {
+		info.TestFiles++
+	} else {
+		info.GoFiles++
+	}
+}
+
+func (b *moduleBreakdown) info(m *wsModule) *ModuleInfo {
+	if m == nil {
+		return nil
+	}
+	if info, ok := b.infos[m]; ok {
+		return info
+	}
+	rel, err := filepath.Rel(b.dir, m.Dir)
+	if err != nil {
+		rel = m.Dir
+	}
+	info := &ModuleInfo{Path: m.Path, Dir: filepath.ToSlash(rel), GoVersion: m.GoVersion}
+	b.infos[m] = info
+	return info
+}
+
+func (b *moduleBreakdown) modules() []ModuleInfo {
+	if b == nil {
+		return nil
+	}
+	var result []ModuleInfo
+	for _, m := range b.ws.Modules {
+		if withinDir(m.Dir, b.dir, true) {
+			b.info(m)
+		}
+		if info, ok := b.infos[m]; ok {
+			result = append(result, *info)
+		}
+	}
+	return result
 }
 
 func ProjectOverview(dir string) (*ProjectInfo, error) {
@@ -79,16 +162,11 @@ func ProjectOverview(dir string) (*ProjectInfo, error) {
 		Path:    absDir,
 	}
 
-	if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "module ") {
-				info.Module = strings.TrimPrefix(line, "module ")
-			}
-			if strings.HasPrefix(line, "go ") {
-				info.GoVersion = strings.TrimPrefix(line, "go ")
-			}
+	modules := newModuleBreakdown(absDir)
+	if modules != nil {
+		info.Workspace = modules.ws.Work
+		if m := modules.ws.moduleOf(absDir); m != nil {
+			info.Module, info.GoVersion = m.Path, m.GoVersion
 		}
 	}
 
@@ -111,6 +189,7 @@ func ProjectOverview(dir string) (*ProjectInfo, error) {
 		} else if strings.HasSuffix(path, ".go") {
 			pkgDir := filepath.Dir(path)
 			pkgSet[pkgDir] = true
+			modules.add(pkgDir, strings.HasSuffix(path, "_test.go"))
 			if strings.HasSuffix(path, "_test.go") {
 				info.TestFiles++
 			} else {
@@ -122,20 +201,24 @@ func ProjectOverview(dir string) (*ProjectInfo, error) {
 
 	info.Packages = len(pkgSet)
 	info.Dirs = dirs
+	info.Modules = modules.modules()
 
 	return info, nil
 }
 
 type PackageInfo struct {
-	Name     string `json:"name"`
-	Path     string `json:"path"`
-	NumFiles int    `json:"numFiles"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	ImportPath string `json:"importPath,omitempty"`
+	Module     string `json:"module,omitempty"`
+	NumFiles   int    `json:"numFiles"`
 }
 
 type PackagesResult struct {
 	Success  bool          `json:"success"`
 	Packages []PackageInfo `json:"packages"`
 	Count    int           `json:"count"`
+	Modules  []ModuleInfo  `json:"modules,omitempty"`
 }
 
 func ListPackages(dir string) (*PackagesResult, error) {
@@ -145,6 +228,7 @@ func ListPackages(dir string) (*PackagesResult, error) {
 	if err != nil {
 		return nil, err
 	}
+	modules := newModuleBreakdown(absDir)
 
 	filepath.Walk(absDir, func(path string, fi os.FileInfo, err error) error {
 		if err != nil || !fi.IsDir() {
@@ -167,6 +251,7 @@ func ListPackages(dir string) (*PackagesResult, error) {
 				continue
 			}
 			numFiles++
+			modules.add(path, false)
 			if pkgName == "" {
 				fset := token.NewFileSet()
 				f, err := parser.ParseFile(fset, filepath.Join(path, e.Name()), nil, parser.PackageClauseOnly)
@@ -181,11 +266,18 @@ func ListPackages(dir string) (*PackagesResult, error) {
 			if rel == "" {
 				rel = "."
 			}
-			packages = append(packages, PackageInfo{
+			pkg := PackageInfo{
 				Name:     pkgName,
 				Path:     rel,
 				NumFiles: numFiles,
-			})
+			}
+			if modules != nil {
+				if m := modules.ws.moduleOf(path); m != nil {
+					pkg.Module = m.Path
+					pkg.ImportPath = modules.ws.importPath(path)
+				}
+			}
+			packages = append(packages, pkg)
 		}
 
 		return nil
@@ -195,6 +287,7 @@ func ListPackages(dir string) (*PackagesResult, error) {
 		Success:  true,
 		Packages: packages,
 		Count:    len(packages),
+		Modules:  modules.modules(),
 	}, nil
 }
 
@@ -414,16 +507,13 @@ func Implementations(symbol string) (*ReferencesResult, error) {
 	}, nil
 }
 
-func Callers(funcName string) (*ReferencesResult, error) {
-	return References(funcName)
-}
-
 type RenameResult struct {
 	Error        string   `json:"error,omitempty"`
 	Success      bool     `json:"success"`
 	OldName      string   `json:"oldName"`
 	NewName      string   `json:"newName"`
 	FilesChanged []string `json:"filesChanged"`
+	Warnings     []string `json:"warnings,omitempty"`
 }
 
 func Rename(oldName, newName string) (*RenameResult, error) {
@@ -434,36 +524,51 @@ func Rename(oldName, newName string) (*RenameResult, error) {
 	if loc == nil {
 		return nil, fmt.Errorf("symbol %s not found", oldName)
 	}
-
 	col := loc.Column
 	if col == 0 {
 		col = 1
 	}
 	pos := fmt.Sprintf("%s:%d:%d", loc.File, loc.Line, col)
-	cmd := exec.Command(findGopls(), "rename", "-l", "-w", pos, newName)
-	output, _ := cmd.CombinedOutput()
+	result := &RenameResult{
+		Success:  true,
+		OldName:  oldName,
+		NewName:  newName,
+		Warnings: siblingWarnings(loc),
+	}
+
+	// gopls rewrites every file referring to the symbol. List them first,
+	// so that a generated one among them refuses the whole rename.
+	output, _ := exec.Command(findGopls(), "rename", "-l", pos, newName).CombinedOutput()
+	files, goplsErr := parseRenameOutput(output)
+	if goplsErr == "" {
+		for _, file := range append([]string{loc.File}, files...) {
+			if err := checkWritable(file); err != nil {
+				return nil, err
+			}
+		}
+		output, _ = exec.Command(findGopls(), "rename", "-l", "-w", pos, newName).CombinedOutput()
+		files, goplsErr = parseRenameOutput(output)
+	}
+	result.FilesChanged = files
+	if goplsErr != "" {
+		result.Error, result.Success = goplsErr, false
+	}
+	return result, nil
+}
 
+// parseRenameOutput returns the files "gopls rename -l" lists, or the
+// error it reports.
+func parseRenameOutput(output []byte) ([]string, string) {
 	var files []string
-	var error string
-	var success = true
 	for _, line := range strings.Split(string(output), "\n") {
 		if strings.HasPrefix(line, "gopls: ") {
-			error = strings.Split(line, "gopls: ")[1]
-			success = false
-			break
+			return nil, strings.Split(line, "gopls: ")[1]
 		}
 		if strings.HasSuffix(line, ".go") {
 			files = append(files, strings.TrimSpace(line))
 		}
 	}
-
-	return &RenameResult{
-		Error:        error,
-		Success:      success,
-		OldName:      oldName,
-		NewName:      newName,
-		FilesChanged: files,
-	}, nil
+	return files, ""
 }
 
 func RenameLocal(funcName, oldVar, newVar string) (*RenameResult, error) {
@@ -616,18 +721,18 @@ func RenamePackage(oldName, newName string) (*RenamePackageResult, error) {
 	}
 
 	absDir, _ := filepath.Abs(".")
+	ws, err := findWorkspace(".")
+	if err != nil {
+		return nil, err
+	}
 
-	// Find package directory
+	// Find package directory, preferring the module of the current directory
 	var pkgDir string
-	filepath.Walk(absDir, func(path string, fi os.FileInfo, err error) error {
-		if err != nil || !fi.IsDir() || pkgDir != "" {
-			return nil
+	current := ws.moduleOf(absDir)
+	ws.walkPackageDirs(func(m *wsModule, path string) {
+		if pkgDir != "" && (m != current || ws.moduleOf(pkgDir) == current) {
+			return // keep the first match, unless a later one is in the current module
 		}
-		base := fi.Name()
-		if path != absDir && (strings.HasPrefix(base, ".") || base == "vendor" || base == "testdata") {
-			return filepath.SkipDir
-		}
-
 		entries, _ := os.ReadDir(path)
 		for _, e := range entries {
 			if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
@@ -637,34 +742,17 @@ func RenamePackage(oldName, newName string) (*RenamePackageResult, error) {
 			f, err := parser.ParseFile(fset, filepath.Join(path, e.Name()), nil, parser.PackageClauseOnly)
 			if err == nil && f.Name.Name == oldName {
 				pkgDir = path
-				return filepath.SkipAll
 			}
 			break
 		}
-		return nil
 	})
 
 	if pkgDir == "" {
 		return nil, fmt.Errorf("package %s not found", oldName)
 	}
 
-	// Get module path from go.mod
-	var modulePath string
-	if data, err := os.ReadFile(filepath.Join(absDir, "go.mod")); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			if strings.HasPrefix(line, "module ") {
-				modulePath = strings.TrimSpace(strings.TrimPrefix(line, "module "))
-				break
-			}
-		}
-	}
-
-	// Calculate paths
-	relPkgDir, _ := filepath.Rel(absDir, pkgDir)
-	oldImportPath := modulePath
-	if relPkgDir != "." {
-		oldImportPath = modulePath + "/" + filepath.ToSlash(relPkgDir)
-	}
+	// Import paths are relative to the module holding the package
+	oldImportPath := ws.importPath(pkgDir)
 
 	// Check if directory name matches package name (can rename dir)
 	dirName := filepath.Base(pkgDir)
@@ -674,104 +762,200 @@ func RenamePackage(oldName, newName string) (*RenamePackageResult, error) {
 	var newImportPath string
 	if canRenameDir {
 		newPkgDir = filepath.Join(filepath.Dir(pkgDir), newName)
-		newRelDir, _ := filepath.Rel(absDir, newPkgDir)
-		newImportPath = modulePath
-		if newRelDir != "." {
-			newImportPath = modulePath + "/" + filepath.ToSlash(newRelDir)
-		}
+		newImportPath = ws.importPath(newPkgDir)
 	} else {
 		newPkgDir = pkgDir
 		newImportPath = oldImportPath
 	}
 
-	// Step 1: Rename package declaration in all files of the package
+	if oldName == newName {
+		return result, nil
+	}
+	prog, err := loadProgram(".", true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every edit is planned before anything is written, so that a
+	// generated file among them refuses the whole rename.
+	var edits []textEdit
+
+	// Step 1: Rename package declaration in all files of the package,
+	// including those the build constraints exclude
 	entries, _ := os.ReadDir(pkgDir)
 	for _, e := range entries {
 		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
 			continue
 		}
 		filePath := filepath.Join(pkgDir, e.Name())
-		src, err := os.ReadFile(filePath)
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filePath, nil, parser.PackageClauseOnly)
 		if err != nil {
 			continue
 		}
+		var name string
+		switch f.Name.Name {
+		case oldName:
+			name = newName
+		case oldName + "_test":
+			name = newName + "_test"
+		default:
+			continue
+		}
+		offset := fset.Position(f.Name.Pos()).Offset
+		edits = append(edits, textEdit{File: filePath, Start: offset, End: offset + len(f.Name.Name), Text: name})
+	}
 
-		oldDecl := "package " + oldName
-		newDecl := "package " + newName
-		if strings.Contains(string(src), oldDecl) {
-			newSrc := strings.Replace(string(src), oldDecl, newDecl, 1)
-			if err := os.WriteFile(filePath, []byte(newSrc), 0644); err == nil {
-				rel, _ := filepath.Rel(absDir, filePath)
-				result.FilesChanged = append(result.FilesChanged, rel)
+	// Step 2: Fix imports and qualified uses in all packages of the
+	// workspace. Files the build constraints exclude are not type-checked
+	// and get a syntax-only fix.
+	checked := make(map[string]bool)
+	for _, pkg := range prog.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		for _, f := range pkg.Files {
+			checked[prog.position(f.Pos()).Filename] = true
+			if fixed := prog.renameImport(pkg, f, oldImportPath, newImportPath, oldName, newName); len(fixed) > 0 {
+				edits = append(edits, fixed...)
+				result.ImportsFixed++
+			}
+		}
+	}
+	ws.walkPackageDirs(func(_ *wsModule, dir string) {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			filePath := filepath.Join(dir, e.Name())
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || checked[filePath] {
+				continue
+			}
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, filePath, nil, 0)
+			if err != nil {
+				continue
+			}
+			if fixed := renameImportSyntax(fset, filePath, f, oldImportPath, newImportPath, oldName, newName); len(fixed) > 0 {
+				edits = append(edits, fixed...)
+				result.ImportsFixed++
 			}
 		}
+	})
+
+	r, err := renderEdits(edits)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.write(); err != nil {
+		return nil, err
 	}
 
-	// Step 2: Rename directory if applicable
+	// Step 3: Rename directory if applicable
 	if canRenameDir && pkgDir != newPkgDir {
 		if err := os.Rename(pkgDir, newPkgDir); err != nil {
+			for _, file := range r.files {
+				os.WriteFile(file, r.before[file], 0644)
+			}
 			return nil, fmt.Errorf("failed to rename directory: %w", err)
 		}
-		// Update FilesChanged paths
-		for i, f := range result.FilesChanged {
-			result.FilesChanged[i] = strings.Replace(f, oldName+"/", newName+"/", 1)
+	}
+	for _, file := range r.files {
+		if filepath.Dir(file) == pkgDir {
+			file = filepath.Join(newPkgDir, filepath.Base(file))
 		}
+		rel, _ := filepath.Rel(absDir, file)
+		result.FilesChanged = append(result.FilesChanged, rel)
 	}
+	return result, nil
+}
 
-	// Step 3: Fix imports in all project files
-	filepath.Walk(absDir, func(path string, fi os.FileInfo, err error) error {
-		if err != nil || fi.IsDir() {
-			if fi != nil && fi.IsDir() {
-				base := fi.Name()
-				if strings.HasPrefix(base, ".") || base == "vendor" {
-					return filepath.SkipDir
+// renameImportSyntax is renameImport for a file that is not type-checked.
+// Uses are selectors on an identifier the parser left unresolved, which in
+// a file importing the package can only be the import; the new name counts
+// as taken if the file spells it anywhere.
+func renameImportSyntax(fset *token.FileSet, file string, f *ast.File, oldPath, newPath, oldName, newName string) []textEdit {
+	edit := func(n ast.Node, text string) textEdit {
+		return textEdit{File: file, Start: fset.Position(n.Pos()).Offset, End: fset.Position(n.End()).Offset, Text: text}
+	}
+	var edits []textEdit
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path != oldPath {
+			continue
+		}
+		pathText := strconv.Quote(newPath)
+		if spec.Name != nil {
+			if oldPath != newPath {
+				edits = append(edits, edit(spec.Path, pathText))
+			}
+			continue
+		}
+		var uses []*ast.Ident
+		taken := false
+		ast.Inspect(f, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == newName {
+				taken = true
+			}
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok && id.Name == oldName && id.Obj == nil {
+					uses = append(uses, id)
 				}
 			}
-			return nil
+			return true
+		})
+		if taken {
+			edits = append(edits, edit(spec.Path, oldName+" "+pathText))
+			continue
 		}
-		if !strings.HasSuffix(path, ".go") {
-			return nil
+		if oldPath != newPath {
+			edits = append(edits, edit(spec.Path, pathText))
 		}
-
-		src, err := os.ReadFile(path)
-		if err != nil {
-			return nil
+		for _, id := range uses {
+			edits = append(edits, edit(id, newName))
 		}
+	}
+	return edits
+}
 
-		content := string(src)
-		changed := false
-
-		// Fix import path
-		if oldImportPath != newImportPath && strings.Contains(content, `"`+oldImportPath+`"`) {
-			content = strings.ReplaceAll(content, `"`+oldImportPath+`"`, `"`+newImportPath+`"`)
-			changed = true
+// renameImport returns the edits making f import a renamed package: its
+// new path and, where f refers to it by its package name, the new name at
+// every use. Identifiers merely spelled like the package are left alone.
+// If the new name is taken where f uses the package, f imports it under
+// the old name instead.
+func (p *program) renameImport(pkg *loadedPackage, f *ast.File, oldPath, newPath, oldName, newName string) []textEdit {
+	var edits []textEdit
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path != oldPath {
+			continue
 		}
-
-		// Fix package usage: oldpkg.Something -> newpkg.Something
-		if strings.Contains(content, oldName+".") {
-			content = strings.ReplaceAll(content, oldName+".", newName+".")
-			changed = true
+		pathText := strconv.Quote(newPath)
+		pn, _ := pkg.Info.Implicits[spec].(*types.PkgName)
+		if spec.Name != nil || pn == nil {
+			// An explicit name stays.
+			if oldPath != newPath {
+				edits = append(edits, p.edit(spec.Path.Pos(), spec.Path.End(), pathText))
+			}
+			continue
 		}
-
-		if changed {
-			if err := os.WriteFile(path, []byte(content), 0644); err == nil {
-				rel, _ := filepath.Rel(absDir, path)
-				alreadyListed := false
-				for _, f := range result.FilesChanged {
-					if f == rel {
-						alreadyListed = true
-						break
-					}
-				}
-				if !alreadyListed {
-					result.FilesChanged = append(result.FilesChanged, rel)
-				}
-				result.ImportsFixed++
+		var uses []*ast.Ident
+		taken := false
+		for id, obj := range pkg.Info.Uses {
+			if obj != pn {
+				continue
+			}
+			uses = append(uses, id)
+			if _, other := pkg.Types.Scope().Innermost(id.Pos()).LookupParent(newName, id.Pos()); other != nil {
+				taken = true
 			}
 		}
-
-		return nil
-	})
-
-	return result, nil
+		if taken {
+			edits = append(edits, p.edit(spec.Path.Pos(), spec.Path.End(), oldName+" "+pathText))
+			continue
+		}
+		if oldPath != newPath {
+			edits = append(edits, p.edit(spec.Path.Pos(), spec.Path.End(), pathText))
+		}
+		for _, id := range uses {
+			edits = append(edits, p.edit(id.Pos(), id.End(), newName))
+		}
+	}
+	return edits
 }
@@ -3,6 +3,7 @@ package refactor_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/night-codes/gorefactor/refactor"
@@ -53,3 +54,86 @@ func TestCheck(t *testing.T) {
 		t.Errorf("gorefactor should build OK, got errors: %v", result.BuildErrors)
 	}
 }
+
+func TestWorkspace(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.work":         "go 1.21\n\nuse (\n\t./app\n\t./libs\n)\n",
+		"app/go.mod":      "module example.com/app\n\ngo 1.21\n",
+		"app/main.go":     "package main\n\nimport \"example.com/libs/lib\"\n\nfunc main() { println(lib.Hello()) }\n",
+		"libs/go.mod":     "module example.com/libs\n\ngo 1.21\n",
+		"libs/lib/lib.go": "package lib\n\nfunc Hello() string { return \"hi\" }\n",
+	})
+	chdir(t, filepath.Join(dir, "app"))
+
+	pkgs, err := refactor.ListPackages(dir)
+	if err != nil {
+		t.Fatalf("ListPackages error: %v", err)
+	}
+	if len(pkgs.Modules) != 2 || pkgs.Modules[1].Path != "example.com/libs" || pkgs.Modules[1].Packages != 1 {
+		t.Errorf("unexpected modules: %+v", pkgs.Modules)
+	}
+	for _, p := range pkgs.Packages {
+		if p.Name == "lib" && (p.Module != "example.com/libs" || p.ImportPath != "example.com/libs/lib") {
+			t.Errorf("lib attributed to %s as %s", p.Module, p.ImportPath)
+		}
+	}
+
+	info, err := refactor.ProjectOverview(".")
+	if err != nil {
+		t.Fatalf("ProjectOverview error: %v", err)
+	}
+	if info.Module != "example.com/app" || info.Workspace != filepath.Join(dir, "go.work") {
+		t.Errorf("unexpected project info: %+v", info)
+	}
+
+	if _, err := refactor.RenamePackage("lib", "util"); err != nil {
+		t.Fatalf("RenamePackage error: %v", err)
+	}
+	src, _ := os.ReadFile(filepath.Join(dir, "app", "main.go"))
+	if !strings.Contains(string(src), `"example.com/libs/util"`) || !strings.Contains(string(src), "util.Hello()") {
+		t.Errorf("import in another module not fixed:\n%s", src)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "libs", "util", "lib.go")); err != nil {
+		t.Errorf("package directory not renamed: %v", err)
+	}
+}
+
+func TestWorkspaceRoot(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.work":         "go 1.21\n\nuse (\n\t./app\n\t./libs\n)\n",
+		"app/go.mod":      "module example.com/app\n\ngo 1.21\n",
+		"app/main.go":     "package main\n\nimport \"example.com/libs/lib\"\n\nfunc main() { println(lib.Hello()) }\n",
+		"libs/go.mod":     "module example.com/libs\n\ngo 1.21\n",
+		"libs/lib/lib.go": "package lib\n\nfunc Hello() string { return \"hi\" }\n\nfunc unused() {}\n",
+	})
+	// The go.work directory is in no module.
+	chdir(t, dir)
+
+	callers, err := refactor.Callers("Hello", 1)
+	if err != nil {
+		t.Fatalf("Callers error: %v", err)
+	}
+	if callers.Count != 1 {
+		t.Errorf("expected the call from main, got %+v", callers.Calls)
+	}
+	deps, err := refactor.Deps(nil)
+	if err != nil {
+		t.Fatalf("Deps error: %v", err)
+	}
+	if len(deps.Packages) != 2 {
+		t.Errorf("expected both modules' packages, got %+v", deps.Packages)
+	}
+	if _, err := refactor.Cycles(); err != nil {
+		t.Errorf("Cycles error: %v", err)
+	}
+	unused, err := refactor.Unused(".", &refactor.UnusedOptions{Apply: true})
+	if err != nil {
+		t.Fatalf("Unused error: %v", err)
+	}
+	if unused.Count != 1 || unused.Unused[0].Name != "unused" {
+		t.Errorf("expected unused reported, got %+v", unused.Unused)
+	}
+	if unused.Check == nil || !unused.Check.BuildOK || len(unused.Deleted) != 1 {
+		t.Errorf("expected unused deleted and the modules built, got %+v", unused)
+	}
+}
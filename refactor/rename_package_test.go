@@ -2,6 +2,7 @@ package refactor_test
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -121,3 +122,77 @@ func TestRenamePackageSameName(t *testing.T) {
 		t.Error("expected success even for same name")
 	}
 }
+
+func TestRenamePackageGenerated(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":        "module example.com/test\n\ngo 1.21\n",
+		"oldpkg/lib.go": "package oldpkg\n\nfunc Helper() string { return \"hello\" }\n",
+		"main.go":       "package main\n\nimport \"example.com/test/oldpkg\"\n\nfunc main() { println(oldpkg.Helper()) }\n",
+		"gen/gen.go":    "// Code generated by stubgen. DO NOT EDIT.\n\npackage gen\n\nimport \"example.com/test/oldpkg\"\n\nvar V = oldpkg.Helper()\n",
+	})
+	chdir(t, dir)
+
+	if _, err := refactor.RenamePackage("oldpkg", "newpkg"); err == nil || !strings.Contains(err.Error(), "generated") {
+		t.Fatalf("expected the generated importer to refuse the rename, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "oldpkg", "lib.go")); err != nil {
+		t.Errorf("package directory should be left alone: %v", err)
+	}
+	if src := readModuleFile(t, dir, "main.go"); !strings.Contains(src, "oldpkg.Helper()") {
+		t.Errorf("main.go should be left alone:\n%s", src)
+	}
+}
+
+func TestRenamePackageSelectorsOnly(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":     "module example.com/test\n\ngo 1.21\n",
+		"lib/lib.go": "package lib\n\nfunc Hello() string { return \"hi\" }\n",
+		"main.go":    "package main\n\nimport \"example.com/test/lib\"\n\nfunc main() { println(lib.Hello(), \"lib.Hello\") }\n",
+		"other.go":   "package main\n\ntype box struct{ n int }\n\nfunc count() int {\n\tlib := box{1}\n\treturn lib.n\n}\n",
+		"use/use.go": "package use\n\nimport \"example.com/test/lib\"\n\nfunc Use(text int) string { return lib.Hello() }\n",
+	})
+	chdir(t, dir)
+
+	if _, err := refactor.RenamePackage("lib", "text"); err != nil {
+		t.Fatalf("RenamePackage error: %v", err)
+	}
+	if src := readModuleFile(t, dir, "main.go"); !strings.Contains(src, `text.Hello(), "lib.Hello"`) {
+		t.Errorf("expected only the qualified call renamed:\n%s", src)
+	}
+	if src := readModuleFile(t, dir, "other.go"); !strings.Contains(src, "return lib.n") {
+		t.Errorf("a local variable named lib should be left alone:\n%s", src)
+	}
+	// The parameter text shadows the new name, so the old one is kept.
+	if src := readModuleFile(t, dir, "use/use.go"); !strings.Contains(src, `lib "example.com/test/text"`) || !strings.Contains(src, "return lib.Hello()") {
+		t.Errorf("expected the import kept under its old name:\n%s", src)
+	}
+}
+
+func TestRenamePackageExcludedFiles(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":         "module example.com/test\n\ngo 1.21\n",
+		"lib/lib.go":     "package lib\n\nfunc Hello() string { return \"hi\" }\n",
+		"main.go":        "package main\n\nfunc main() { println(greeting()) }\n",
+		"app_linux.go":   "package main\n\nimport \"example.com/test/lib\"\n\nfunc greeting() string { return lib.Hello() }\n",
+		"app_windows.go": "package main\n\nimport \"example.com/test/lib\"\n\nfunc greeting() string {\n\tlocal := struct{ lib string }{}\n\treturn lib.Hello() + local.lib\n}\n",
+		"app_other.go":   "//go:build !linux && !windows\n\npackage main\n\nfunc greeting() string { return \"\" }\n",
+	})
+	chdir(t, dir)
+
+	if _, err := refactor.RenamePackage("lib", "util"); err != nil {
+		t.Fatalf("RenamePackage error: %v", err)
+	}
+	for _, name := range []string{"app_linux.go", "app_windows.go"} {
+		src := readModuleFile(t, dir, name)
+		if !strings.Contains(src, `"example.com/test/util"`) || !strings.Contains(src, "util.Hello()") {
+			t.Errorf("%s not fixed:\n%s", name, src)
+		}
+	}
+	for _, goos := range []string{"linux", "windows"} {
+		cmd := exec.Command("go", "vet", ".")
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=amd64")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("GOOS=%s go vet: %v\n%s", goos, err, out)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// StructTagPair is one key:"value" pair of a struct tag.
+type StructTagPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type TagField struct {
+	Name string          `json:"name"`
+	Line int             `json:"line"`
+	Tag  string          `json:"tag,omitempty"`
+	Tags []StructTagPair `json:"tags,omitempty"`
+}
+
+type TagIssue struct {
+	Field   string `json:"field"`
+	Line    int    `json:"line"`
+	Issue   string `json:"issue"`
+	Message string `json:"message"`
+}
+
+type TagsResult struct {
+	Success      bool       `json:"success"`
+	Type         string     `json:"type"`
+	File         string     `json:"file"`
+	Message      string     `json:"message,omitempty"`
+	Fields       []TagField `json:"fields"`
+	Issues       []TagIssue `json:"issues,omitempty"`
+	FilesChanged []string   `json:"filesChanged,omitempty"`
+}
+
+type TagsOptions struct {
+	Add       []string
+	Case      string // snake (default), camel or kebab
+	OmitEmpty bool
+	Remove    []string
+	SetField  string
+	SetTag    string
+	Check     bool
+}
+
+// parseStructTag splits a struct tag into its key:"value" pairs, in order,
+// following the conventions of reflect.StructTag. raw may be backquoted.
+func parseStructTag(raw string) ([]StructTagPair, error) {
+	tag := raw
+	if strings.HasPrefix(tag, "`") || strings.HasPrefix(tag, `"`) {
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag literal %s", raw)
+		}
+		tag = unquoted
+	}
+
+	var pairs []StructTagPair
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return pairs, nil
+		}
+
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return pairs, fmt.Errorf("bad syntax for struct tag pair at %q", tag)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return pairs, fmt.Errorf("bad syntax for struct tag value of %s", key)
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return pairs, fmt.Errorf("bad syntax for struct tag value of %s", key)
+		}
+		tag = tag[i+1:]
+		if tag != "" && tag[0] != ' ' {
+			return pairs, fmt.Errorf("struct tag pair %s is not followed by a space", key)
+		}
+		pairs = append(pairs, StructTagPair{Key: key, Value: value})
+	}
+}
+
+// formatStructTag renders pairs as a backquoted tag literal, or "" when
+// there are none.
+func formatStructTag(pairs []StructTagPair) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, p := range pairs {
+		parts = append(parts, p.Key+":"+strconv.Quote(p.Value))
+	}
+	tag := strings.Join(parts, " ")
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
+
+func setTagPair(pairs []StructTagPair, key, value string) []StructTagPair {
+	for i := range pairs {
+		if pairs[i].Key == key {
+			pairs[i].Value = value
+			return pairs
+		}
+	}
+	return append(pairs, StructTagPair{Key: key, Value: value})
+}
+
+// splitWords splits a Go identifier into words, keeping initialisms
+// together: "HTTPServerID" -> HTTP, Server, ID.
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case cur == '_':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case unicode.IsLower(prev) && unicode.IsUpper(cur),
+			unicode.IsDigit(prev) != unicode.IsDigit(cur) && !unicode.IsUpper(prev),
+			unicode.IsUpper(prev) && unicode.IsUpper(cur) && unicode.IsLower(next):
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// tagName converts a field name to the given case: snake, camel or kebab.
+func tagName(name, style string) string {
+	words := splitWords(name)
+	switch style {
+	case "camel":
+		words[0] = strings.ToLower(words[0])
+		return strings.Join(words, "")
+	case "kebab":
+		return strings.ToLower(strings.Join(words, "-"))
+	default:
+		return strings.ToLower(strings.Join(words, "_"))
+	}
+}
+
+// Tags reads, edits or checks the struct tags of a type. Without options it
+// lists the parsed tags of every field.
+func Tags(typeName string, opts *TagsOptions) (*TagsResult, error) {
+	if opts == nil {
+		opts = &TagsOptions{}
+	}
+	switch opts.Case {
+	case "", "snake", "camel", "kebab":
+	default:
+		return nil, fmt.Errorf("unknown case %s: use snake, camel or kebab", opts.Case)
+	}
+
+	loc, err := locateType(typeName, ".")
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, loc.File, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var st *ast.StructType
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ts, ok := n.(*ast.TypeSpec); ok && ts.Name.Name == typeName {
+			st, _ = ts.Type.(*ast.StructType)
+			return false
+		}
+		return st == nil
+	})
+	if st == nil {
+		return nil, fmt.Errorf("%s is not a struct type", typeName)
+	}
+
+	result := &TagsResult{Success: true, Type: typeName, File: loc.File}
+	if opts.Check {
+		checkTags(fset, st, result)
+		result.Message = fmt.Sprintf("%d tag issue(s) in %s", len(result.Issues), typeName)
+		return result, nil
+	}
+
+	var setPairs []StructTagPair
+	if opts.SetField != "" {
+		if setPairs, err = parseStructTag(opts.SetTag); err != nil {
+			return nil, err
+		}
+	}
+
+	var edits []textEdit
+	setFound := false
+	for _, field := range st.Fields.List {
+		names := fieldNames(field)
+		line := fset.Position(field.Pos()).Line
+		var pairs []StructTagPair
+		if field.Tag != nil {
+			if pairs, err = parseStructTag(field.Tag.Value); err != nil {
+				return nil, fmt.Errorf("field %s: %v", names[0], err)
+			}
+		}
+		changed := false
+
+		if len(opts.Add) > 0 && len(field.Names) > 1 {
+			result.Issues = append(result.Issues, TagIssue{Field: names[0], Line: line, Issue: "shared",
+				Message: fmt.Sprintf("fields %s share one tag; split them to tag each", strings.Join(names, ", "))})
+		} else if len(field.Names) == 1 && ast.IsExported(names[0]) {
+			for _, key := range opts.Add {
+				if _, ok := lookupTag(pairs, key); ok {
+					continue
+				}
+				value := tagName(names[0], opts.Case)
+				if opts.OmitEmpty {
+					value += ",omitempty"
+				}
+				pairs = append(pairs, StructTagPair{Key: key, Value: value})
+				changed = true
+			}
+		}
+		for _, key := range opts.Remove {
+			for i := range pairs {
+				if pairs[i].Key == key {
+					pairs = append(pairs[:i], pairs[i+1:]...)
+					changed = true
+					break
+				}
+			}
+		}
+		if opts.SetField != "" && contains(names, opts.SetField) {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("fields %s share one tag; split them first", strings.Join(names, ", "))
+			}
+			setFound = true
+			for _, p := range setPairs {
+				pairs = setTagPair(pairs, p.Key, p.Value)
+			}
+			changed = true
+		}
+
+		tag := formatStructTag(pairs)
+		for _, name := range names {
+			result.Fields = append(result.Fields, TagField{Name: name, Line: line, Tag: strings.Trim(tag, "`"), Tags: pairs})
+		}
+		if !changed {
+			continue
+		}
+
+		end := fset.Position(field.Type.End()).Offset
+		switch {
+		case field.Tag != nil:
+			start := fset.Position(field.Tag.Pos()).Offset
+			if tag == "" {
+				start = end
+			}
+			edits = append(edits, textEdit{File: loc.File, Start: start, End: fset.Position(field.Tag.End()).Offset, Text: tag})
+		case tag != "":
+			edits = append(edits, textEdit{File: loc.File, Start: end, End: end, Text: " " + tag})
+		}
+	}
+	if opts.SetField != "" && !setFound {
+		return nil, fmt.Errorf("%s has no field %s", typeName, opts.SetField)
+	}
+
+	if len(edits) == 0 {
+		result.Message = "no tags changed"
+		return result, nil
+	}
+	if result.FilesChanged, err = applyEdits(edits); err != nil {
+		return nil, err
+	}
+	result.Message = fmt.Sprintf("updated tags of %d field(s) in %s", len(edits), typeName)
+	return result, nil
+}
+
+func lookupTag(pairs []StructTagPair, key string) (string, bool) {
+	for _, p := range pairs {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// checkTags reports malformed tags, tags on unexported fields and fields
+// that encode to the same JSON key.
+func checkTags(fset *token.FileSet, st *ast.StructType, result *TagsResult) {
+	jsonKeys := make(map[string]string)
+	for _, field := range st.Fields.List {
+		names := fieldNames(field)
+		line := fset.Position(field.Pos()).Line
+		var pairs []StructTagPair
+		if field.Tag != nil {
+			var err error
+			pairs, err = parseStructTag(field.Tag.Value)
+			if err != nil {
+				result.Issues = append(result.Issues, TagIssue{Field: names[0], Line: line, Issue: "malformed", Message: err.Error()})
+			}
+			seen := make(map[string]bool)
+			for _, p := range pairs {
+				if seen[p.Key] {
+					result.Issues = append(result.Issues, TagIssue{Field: names[0], Line: line, Issue: "duplicate-key",
+						Message: fmt.Sprintf("tag key %s appears more than once", p.Key)})
+				}
+				seen[p.Key] = true
+			}
+		}
+		tag := formatStructTag(pairs)
+		for _, name := range names {
+			result.Fields = append(result.Fields, TagField{Name: name, Line: line, Tag: strings.Trim(tag, "`"), Tags: pairs})
+		}
+
+		if len(field.Names) > 0 && !ast.IsExported(names[0]) {
+			if field.Tag != nil {
+				result.Issues = append(result.Issues, TagIssue{Field: names[0], Line: line, Issue: "unexported",
+					Message: fmt.Sprintf("unexported field %s has tags but is ignored by encoders", names[0])})
+			}
+			continue
+		}
+
+		jsonTag, tagged := lookupTag(pairs, "json")
+		if !tagged && len(field.Names) == 0 {
+			continue // embedded fields are flattened
+		}
+		for _, name := range names {
+			key := name
+			if jsonTag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(jsonTag, ","); n != "" {
+				key = n
+			}
+			if other, ok := jsonKeys[key]; ok {
+				result.Issues = append(result.Issues, TagIssue{Field: name, Line: line, Issue: "duplicate-json",
+					Message: fmt.Sprintf("fields %s and %s both encode to JSON key %q", other, name, key)})
+				continue
+			}
+			jsonKeys[key] = name
+		}
+	}
+}
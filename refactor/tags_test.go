@@ -0,0 +1,56 @@
+package refactor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const tagsModule = "package app\n\ntype User struct {\n\tUserID int `db:\"uid\" json:\"id\"`\n\tHTTPHost string\n\tAlias string `json:\"id\"`\n\tsecret string `json:\"s\"`\n}\n"
+
+func TestTagsEdit(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": tagsModule})
+	chdir(t, dir)
+
+	_, err := refactor.Tags("User", &refactor.TagsOptions{Add: []string{"json", "yaml"}, OmitEmpty: true})
+	if err != nil {
+		t.Fatalf("Tags error: %v", err)
+	}
+	_, err = refactor.Tags("User", &refactor.TagsOptions{Remove: []string{"db"}, SetField: "Alias", SetTag: `json:"alias,string"`})
+	if err != nil {
+		t.Fatalf("Tags error: %v", err)
+	}
+
+	src := readModuleFile(t, dir, "app.go")
+	for _, want := range []string{
+		"`json:\"id\" yaml:\"user_id,omitempty\"`",
+		"`json:\"http_host,omitempty\" yaml:\"http_host,omitempty\"`",
+		"`json:\"alias,string\" yaml:\"alias,omitempty\"`",
+		"secret   string `json:\"s\"`",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("expected %s in:\n%s", want, src)
+		}
+	}
+}
+
+func TestTagsCheck(t *testing.T) {
+	dir := writeModule(t, map[string]string{"go.mod": "module example.com/app\n\ngo 1.21\n", "app.go": tagsModule})
+	chdir(t, dir)
+
+	result, err := refactor.Tags("User", &refactor.TagsOptions{Check: true})
+	if err != nil {
+		t.Fatalf("Tags error: %v", err)
+	}
+	issues := make(map[string]string)
+	for _, issue := range result.Issues {
+		issues[issue.Field] = issue.Issue
+	}
+	if issues["Alias"] != "duplicate-json" || issues["secret"] != "unexported" || len(issues) != 2 {
+		t.Errorf("unexpected issues: %+v", result.Issues)
+	}
+	if f := result.Fields[0]; len(f.Tags) != 2 || f.Tags[0].Key != "db" || f.Tags[1].Value != "id" {
+		t.Errorf("tags not parsed in order: %+v", f)
+	}
+}
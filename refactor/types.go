@@ -74,14 +74,15 @@ func ReadType(name, file string) (*ReadTypeResult, error) {
 }
 
 type ReadFieldResult struct {
-	Success bool   `json:"success"`
-	Name    string `json:"name"`
-	Parent  string `json:"parent"`
-	File    string `json:"file"`
-	Line    int    `json:"line"`
-	Type    string `json:"type"`
-	Tag     string `json:"tag,omitempty"`
-	Code    string `json:"code"`
+	Success bool            `json:"success"`
+	Name    string          `json:"name"`
+	Parent  string          `json:"parent"`
+	File    string          `json:"file"`
+	Line    int             `json:"line"`
+	Type    string          `json:"type"`
+	Tag     string          `json:"tag,omitempty"`
+	Tags    []StructTagPair `json:"tags,omitempty"`
+	Code    string          `json:"code"`
 }
 
 func ReadField(name, file string) (*ReadFieldResult, error) {
@@ -147,6 +148,7 @@ func ReadField(name, file string) (*ReadFieldResult, error) {
 						}
 						if field.Tag != nil {
 							result.Tag = field.Tag.Value
+							result.Tags, _ = parseStructTag(field.Tag.Value)
 						}
 						return result, nil
 					}
@@ -218,6 +220,9 @@ func ReplaceType(name, file string, newCode io.Reader) (*ModifyResult, error) {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -287,6 +292,9 @@ func DeleteType(name, file string) (*ModifyResult, error) {
 		formatted = result
 	}
 
+	if err := checkWritable(file); err != nil {
+		return nil, err
+	}
 	if err := os.WriteFile(file, formatted, 0644); err != nil {
 		return nil, err
 	}
@@ -315,6 +323,9 @@ func MoveType(name, dstFile, srcFile string) (*ModifyResult, error) {
 		return nil, err
 	}
 
+	if err := checkWritable(dstFile); err != nil {
+		return nil, err
+	}
 	if _, err := DeleteType(name, srcFile); err != nil {
 		return nil, err
 	}
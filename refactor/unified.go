@@ -167,16 +167,22 @@ func Replace(name, file string, newCode io.Reader) (*ModifyResult, error) {
 		file = loc.File
 	}
 
+	var result *ModifyResult
 	switch loc.Kind {
 	case "func":
-		return ReplaceFunc(name, file, newCode)
+		result, err = ReplaceFunc(name, file, newCode)
 	case "struct", "interface", "type":
-		return ReplaceType(name, file, newCode)
+		result, err = ReplaceType(name, file, newCode)
 	case "var", "const":
-		return ReplaceVarConst(name, file, newCode)
+		result, err = ReplaceVarConst(name, file, newCode)
 	default:
 		return nil, fmt.Errorf("cannot replace symbol of kind %s", loc.Kind)
 	}
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = siblingWarnings(loc)
+	return result, nil
 }
 
 func Move(name, dstFile string) (*ModifyResult, error) {
@@ -226,16 +232,22 @@ func Move(name, dstFile string) (*ModifyResult, error) {
 		return nil, fmt.Errorf("symbol %s is already in %s", name, dstFile)
 	}
 
+	var result *ModifyResult
 	switch loc.Kind {
 	case "func":
-		return MoveFunc(name, dstFile, srcFile)
+		result, err = MoveFunc(name, dstFile, srcFile)
 	case "struct", "interface", "type":
-		return MoveType(name, dstFile, srcFile)
+		result, err = MoveType(name, dstFile, srcFile)
 	case "var", "const":
-		return MoveVarConst(name, dstFile, srcFile)
+		result, err = MoveVarConst(name, dstFile, srcFile)
 	default:
 		return nil, fmt.Errorf("cannot move symbol of kind %s", loc.Kind)
 	}
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = siblingWarnings(loc)
+	return result, nil
 }
 
 func Delete(name, file string) (*ModifyResult, error) {
@@ -251,14 +263,20 @@ func Delete(name, file string) (*ModifyResult, error) {
 		file = loc.File
 	}
 
+	var result *ModifyResult
 	switch loc.Kind {
 	case "func":
-		return DeleteFunc(name, file)
+		result, err = DeleteFunc(name, file)
 	case "struct", "interface", "type":
-		return DeleteType(name, file)
+		result, err = DeleteType(name, file)
 	case "var", "const":
-		return DeleteVarConst(name, file)
+		result, err = DeleteVarConst(name, file)
 	default:
 		return nil, fmt.Errorf("cannot delete symbol of kind %s", loc.Kind)
 	}
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = siblingWarnings(loc)
+	return result, nil
 }
@@ -0,0 +1,502 @@
+package refactor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type UnusedResult struct {
+	Success bool             `json:"success"`
+	Unused  []SymbolLocation `json:"unused"`
+	Count   int              `json:"count"`
+	Deleted []string         `json:"deleted,omitempty"`
+	Skipped []string         `json:"skipped,omitempty"`
+	// ReportOnly lists the reported fields: --apply leaves them in place,
+	// use "field remove" to drop them together with their literal values.
+	ReportOnly []string     `json:"reportOnly,omitempty"`
+	Check      *CheckResult `json:"check,omitempty"`
+	Message    string       `json:"message,omitempty"`
+}
+
+type UnusedOptions struct {
+	Exported bool // also report exported symbols not used inside the module
+	Apply    bool // delete the reported symbols and run Check
+}
+
+// deadCode tracks object-level reachability over a loaded program.
+type deadCode struct {
+	prog        *program
+	opts        *UnusedOptions
+	deps        map[types.Object][]types.Object
+	decls       map[types.Object]SymbolLocation
+	methods     map[*types.TypeName][]*types.Func
+	fieldParent map[types.Object]types.Object
+	ifaceNames  map[string]bool
+	live        map[types.Object]bool
+	queue       []types.Object
+}
+
+// Unused finds funcs, methods, types, fields, consts and vars of the module
+// that are not reachable from main, init, tests and (unless opts.Exported)
+// exported API. Only symbols declared under dir are reported.
+func Unused(dir string, opts *UnusedOptions) (*UnusedResult, error) {
+	if opts == nil {
+		opts = &UnusedOptions{}
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := loadProgram(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &deadCode{
+		prog:        prog,
+		opts:        opts,
+		deps:        make(map[types.Object][]types.Object),
+		decls:       make(map[types.Object]SymbolLocation),
+		methods:     make(map[*types.TypeName][]*types.Func),
+		fieldParent: make(map[types.Object]types.Object),
+		ifaceNames:  make(map[string]bool),
+		live:        make(map[types.Object]bool),
+	}
+	dc.collect()
+	dc.propagate()
+
+	result := &UnusedResult{Success: true}
+	for obj, loc := range dc.decls {
+		if dc.live[obj] || !withinDir(filepath.Dir(loc.File), absDir, true) {
+			continue
+		}
+		if parent, ok := dc.fieldParent[obj]; ok && !dc.live[parent] {
+			continue
+		}
+		result.Unused = append(result.Unused, loc)
+	}
+	sort.Slice(result.Unused, func(i, j int) bool {
+		a, b := result.Unused[i], result.Unused[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	result.Count = len(result.Unused)
+	for _, loc := range result.Unused {
+		if loc.Kind == "field" {
+			result.ReportOnly = append(result.ReportOnly, loc.Name)
+		}
+	}
+
+	if opts.Apply && result.Count > 0 {
+		// go build ./... refuses a go.work directory outside every module.
+		roots := []string{prog.Root}
+		if prog.Module == "" {
+			roots = nil
+			for _, m := range prog.Workspace.Modules {
+				roots = append(roots, m.Dir)
+			}
+		}
+		if err := applyUnused(roots, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func normalizeObj(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Origin()
+	case *types.Var:
+		return o.Origin()
+	}
+	return obj
+}
+
+func (dc *deadCode) markLive(obj types.Object) {
+	if obj == nil {
+		return
+	}
+	obj = normalizeObj(obj)
+	if dc.live[obj] {
+		return
+	}
+	dc.live[obj] = true
+	dc.queue = append(dc.queue, obj)
+}
+
+// refs collects the objects referenced inside node. Unkeyed struct literals
+// reference every field of the struct.
+func refs(info *types.Info, node ast.Node) []types.Object {
+	var result []types.Object
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch e := n.(type) {
+		case *ast.Ident:
+			if obj := info.Uses[e]; obj != nil {
+				result = append(result, normalizeObj(obj))
+			}
+		case *ast.CompositeLit:
+			if len(e.Elts) == 0 {
+				return true
+			}
+			if _, keyed := e.Elts[0].(*ast.KeyValueExpr); keyed {
+				return true
+			}
+			if st, ok := typeUnderlying(info.TypeOf(e)).(*types.Struct); ok {
+				for i := 0; i < st.NumFields(); i++ {
+					result = append(result, normalizeObj(st.Field(i)))
+				}
+			}
+		}
+		return true
+	})
+	return result
+}
+
+func typeUnderlying(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if ptr, ok := t.Underlying().(*types.Pointer); ok {
+		return ptr.Elem().Underlying()
+	}
+	return t.Underlying()
+}
+
+func hasCall(exprs []ast.Expr) bool {
+	found := false
+	for _, e := range exprs {
+		ast.Inspect(e, func(n ast.Node) bool {
+			if _, ok := n.(*ast.CallExpr); ok {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+func isTestFunc(name string) bool {
+	for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dc *deadCode) collect() {
+	p := dc.prog
+	for _, pkg := range p.Packages {
+		if pkg.Info == nil {
+			continue
+		}
+		isMain := pkg.Name == "main"
+		for _, f := range pkg.Files {
+			isTest := strings.HasSuffix(p.position(f.Pos()).Filename, "_test.go")
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					obj, ok := pkg.Info.Defs[d.Name].(*types.Func)
+					if !ok {
+						continue
+					}
+					dc.deps[obj] = refs(pkg.Info, d)
+					if !isTest {
+						dc.addDecl(obj, dc.prog.funcLocation(d))
+					}
+					name := d.Name.Name
+					switch {
+					case d.Recv == nil && (name == "init" || (isMain && name == "main")):
+						dc.markLive(obj)
+					case isTest && (d.Recv == nil || isTestFunc(name)):
+						dc.markLive(obj)
+					case !dc.opts.Exported && !isMain && ast.IsExported(name) && d.Recv == nil:
+						dc.markLive(obj)
+					}
+					if d.Recv != nil {
+						if tn := recvTypeObj(obj); tn != nil {
+							dc.methods[tn] = append(dc.methods[tn], obj)
+						}
+					}
+
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						switch s := spec.(type) {
+						case *ast.TypeSpec:
+							obj, ok := pkg.Info.Defs[s.Name].(*types.TypeName)
+							if !ok {
+								continue
+							}
+							dc.deps[obj] = refs(pkg.Info, s)
+							if !isTest {
+								dc.addDecl(obj, dc.typeLocation(s))
+								dc.collectFields(pkg, s)
+							}
+							if isTest || (!dc.opts.Exported && !isMain && ast.IsExported(s.Name.Name)) {
+								dc.markLive(obj)
+							}
+							if iface, ok := s.Type.(*ast.InterfaceType); ok && iface.Methods != nil {
+								for _, m := range iface.Methods.List {
+									for _, n := range m.Names {
+										dc.ifaceNames[n.Name] = true
+									}
+								}
+							}
+
+						case *ast.ValueSpec:
+							specRefs := refs(pkg.Info, s)
+							root := isTest || (d.Tok == token.VAR && hasCall(s.Values))
+							for _, ident := range s.Names {
+								if ident.Name == "_" {
+									for _, r := range specRefs {
+										dc.markLive(r)
+									}
+									continue
+								}
+								obj := pkg.Info.Defs[ident]
+								if obj == nil {
+									continue
+								}
+								dc.deps[obj] = specRefs
+								if !isTest {
+									dc.addDecl(obj, dc.valueLocation(d, s, ident))
+								}
+								if root || (!dc.opts.Exported && !isMain && ast.IsExported(ident.Name)) {
+									dc.markLive(obj)
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Methods that may satisfy interfaces of imported packages (String,
+	// Error, MarshalJSON, ...) are only called through those interfaces.
+	seen := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if pkg == nil || seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			if iface, ok := scope.Lookup(name).Type().Underlying().(*types.Interface); ok && scope.Lookup(name).Exported() {
+				for i := 0; i < iface.NumMethods(); i++ {
+					dc.ifaceNames[iface.Method(i).Name()] = true
+				}
+			}
+		}
+		for _, imp := range pkg.Imports() {
+			visit(imp)
+		}
+	}
+	for _, pkg := range p.Packages {
+		if pkg.Types != nil {
+			for _, imp := range pkg.Types.Imports() {
+				visit(imp)
+			}
+		}
+	}
+	dc.ifaceNames["Error"] = true
+}
+
+func recvTypeObj(fn *types.Func) *types.TypeName {
+	if named, ok := recvBase(fn).(*types.Named); ok {
+		return named.Origin().Obj()
+	}
+	return nil
+}
+
+func (dc *deadCode) collectFields(pkg *loadedPackage, s *ast.TypeSpec) {
+	st, ok := s.Type.(*ast.StructType)
+	if !ok || st.Fields == nil {
+		return
+	}
+	for _, field := range st.Fields.List {
+		for _, name := range field.Names {
+			obj := pkg.Info.Defs[name]
+			if obj == nil || name.Name == "_" {
+				continue
+			}
+			dc.fieldParent[normalizeObj(obj)] = pkg.Info.Defs[s.Name]
+			pos := dc.prog.position(name.Pos())
+			dc.addDecl(obj, SymbolLocation{
+				Name:     s.Name.Name + "." + name.Name,
+				Kind:     "field",
+				File:     pos.Filename,
+				Line:     pos.Line,
+				Column:   pos.Column,
+				EndLine:  dc.prog.position(field.End()).Line,
+				Exported: ast.IsExported(name.Name),
+				Type:     formatExpr(field.Type),
+				Parent:   s.Name.Name,
+			})
+			// Tagged fields are used by encoders through reflection.
+			if field.Tag != nil {
+				dc.markLive(obj)
+			}
+		}
+	}
+}
+
+func (dc *deadCode) addDecl(obj types.Object, loc SymbolLocation) {
+	dc.prog.fileMeta(loc.File).annotate(&loc)
+	dc.decls[normalizeObj(obj)] = loc
+}
+
+func (dc *deadCode) typeLocation(s *ast.TypeSpec) SymbolLocation {
+	kind := "type"
+	if _, ok := s.Type.(*ast.InterfaceType); ok {
+		kind = "interface"
+	} else if _, ok := s.Type.(*ast.StructType); ok {
+		kind = "struct"
+	}
+	pos := dc.prog.position(s.Name.Pos())
+	return SymbolLocation{
+		Name:     s.Name.Name,
+		Kind:     kind,
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		EndLine:  dc.prog.position(s.End()).Line,
+		Exported: ast.IsExported(s.Name.Name),
+	}
+}
+
+func (dc *deadCode) valueLocation(d *ast.GenDecl, s *ast.ValueSpec, ident *ast.Ident) SymbolLocation {
+	kind := "var"
+	if d.Tok == token.CONST {
+		kind = "const"
+	}
+	pos := dc.prog.position(ident.Pos())
+	loc := SymbolLocation{
+		Name:     ident.Name,
+		Kind:     kind,
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		EndLine:  dc.prog.position(s.End()).Line,
+		Exported: ast.IsExported(ident.Name),
+	}
+	if s.Type != nil {
+		loc.Type = formatExpr(s.Type)
+	}
+	return loc
+}
+
+func (dc *deadCode) propagate() {
+	for len(dc.queue) > 0 {
+		obj := dc.queue[0]
+		dc.queue = dc.queue[1:]
+
+		for _, dep := range dc.deps[obj] {
+			dc.markLive(dep)
+		}
+
+		switch o := obj.(type) {
+		case *types.TypeName:
+			for _, m := range dc.methods[o] {
+				if dc.ifaceNames[m.Name()] || (!dc.opts.Exported && m.Exported()) {
+					dc.markLive(m)
+				}
+			}
+			// Embedded fields promote their methods and fields.
+			if st, ok := o.Type().Underlying().(*types.Struct); ok {
+				for i := 0; i < st.NumFields(); i++ {
+					if st.Field(i).Embedded() {
+						dc.markLive(st.Field(i))
+					}
+				}
+			}
+		case *types.Func:
+			// A method is only useful while its receiver type is.
+			if tn := recvTypeObj(o); tn != nil {
+				dc.markLive(tn)
+			}
+		}
+	}
+}
+
+// applyUnused deletes the reported symbols as one transaction: on any error,
+// or if a module in roots no longer builds, every touched file is restored.
+func applyUnused(roots []string, result *UnusedResult) error {
+	backup := make(map[string][]byte)
+	for _, loc := range result.Unused {
+		if _, ok := backup[loc.File]; ok {
+			continue
+		}
+		data, err := os.ReadFile(loc.File)
+		if err != nil {
+			return err
+		}
+		backup[loc.File] = data
+	}
+	restore := func() {
+		for file, data := range backup {
+			os.WriteFile(file, data, 0644)
+		}
+	}
+
+	// Each Delete* call re-parses its file and finds the symbol by name, so
+	// earlier deletions do not invalidate later ones and order is irrelevant.
+	for _, loc := range result.Unused {
+		if loc.Kind == "field" {
+			continue // ReportOnly
+		}
+		if loc.Generated && !forceGenerated {
+			result.Skipped = append(result.Skipped, loc.Name)
+			continue
+		}
+		var err error
+		switch loc.Kind {
+		case "func":
+			_, err = DeleteFunc(loc.Name, loc.File)
+		case "struct", "interface", "type":
+			_, err = DeleteType(loc.Name, loc.File)
+		case "var", "const":
+			_, err = DeleteVarConst(loc.Name, loc.File)
+		default:
+			result.Skipped = append(result.Skipped, loc.Name)
+			continue
+		}
+		if err != nil {
+			restore()
+			return fmt.Errorf("deleting %s: %w", loc.Name, err)
+		}
+		result.Deleted = append(result.Deleted, loc.Name)
+	}
+
+	check := &CheckResult{Success: true, BuildOK: true, VetOK: true}
+	for _, root := range roots {
+		c, err := Check(root)
+		if err != nil {
+			restore()
+			return err
+		}
+		check.BuildOK = check.BuildOK && c.BuildOK
+		check.VetOK = check.VetOK && c.VetOK
+		check.BuildErrors = append(check.BuildErrors, c.BuildErrors...)
+		check.VetErrors = append(check.VetErrors, c.VetErrors...)
+	}
+	result.Check = check
+	if !check.BuildOK {
+		restore()
+		result.Deleted = nil
+		result.Message = "build failed after deleting, all changes reverted"
+	}
+	return nil
+}
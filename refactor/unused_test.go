@@ -0,0 +1,113 @@
+package refactor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/night-codes/gorefactor/refactor"
+)
+
+const unusedModule = `package lib
+
+import "fmt"
+
+const (
+	modeA = iota
+	modeB
+	modeC
+)
+
+type Thing struct {
+	used   int
+	unused int
+	Tagged string ` + "`json:\"tagged\"`" + `
+}
+
+func (t Thing) String() string { return fmt.Sprint(t.used, modeC) }
+
+func (t Thing) helper() {}
+
+func Public() Thing {
+	return Thing{used: 1}
+}
+
+func dead() {
+	deadToo()
+}
+
+func deadToo() {}
+
+type orphan struct{ x int }
+
+func Exported() {}
+`
+
+func unusedNames(r *refactor.UnusedResult) []string {
+	var names []string
+	for _, u := range r.Unused {
+		names = append(names, u.Name)
+	}
+	return names
+}
+
+func TestUnused(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":      "module example.com/lib\n\ngo 1.21\n",
+		"lib.go":      unusedModule,
+		"lib_test.go": "package lib\n\nimport \"testing\"\n\nfunc TestPublic(t *testing.T) { Public() }\n",
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Unused(".", nil)
+	if err != nil {
+		t.Fatalf("Unused error: %v", err)
+	}
+	got := strings.Join(unusedNames(result), ",")
+	want := "modeA,modeB,Thing.unused,Thing.helper,dead,deadToo,orphan"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	result, err = refactor.Unused(".", &refactor.UnusedOptions{Exported: true})
+	if err != nil {
+		t.Fatalf("Unused error: %v", err)
+	}
+	if !strings.Contains(strings.Join(unusedNames(result), ","), "Exported") {
+		t.Errorf("expected Exported to be reported with --exported, got %v", unusedNames(result))
+	}
+}
+
+func TestUnusedApply(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/lib\n\ngo 1.21\n",
+		"lib.go": unusedModule,
+	})
+	chdir(t, dir)
+
+	result, err := refactor.Unused(".", &refactor.UnusedOptions{Apply: true})
+	if err != nil {
+		t.Fatalf("Unused error: %v", err)
+	}
+	if result.Check == nil || !result.Check.BuildOK {
+		t.Fatalf("expected build to pass after apply, got %+v", result)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dir, "lib.go"))
+	src := string(content)
+	for _, gone := range []string{"func dead()", "func deadToo()", "type orphan", "func (t Thing) helper()"} {
+		if strings.Contains(src, gone) {
+			t.Errorf("%s should have been deleted", gone)
+		}
+	}
+	if !strings.Contains(src, "modeC") || !strings.Contains(src, "_ = iota") {
+		t.Errorf("iota group should keep its values, got:\n%s", src)
+	}
+	if len(result.Skipped) != 0 || len(result.ReportOnly) != 1 || result.ReportOnly[0] != "Thing.unused" {
+		t.Errorf("expected field to be report-only, got skipped %v, report-only %v", result.Skipped, result.ReportOnly)
+	}
+	if len(result.Deleted) != result.Count-len(result.ReportOnly) {
+		t.Errorf("expected every other symbol deleted, got %v", result.Deleted)
+	}
+}
@@ -0,0 +1,215 @@
+package refactor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// workspace is the set of modules commands work across: the modules a
+// go.work file uses or, without one, the module containing the working
+// directory together with the modules nested below it.
+type workspace struct {
+	Root    string // directory of go.work, or of the root module
+	Work    string // path of go.work, empty without one
+	Modules []*wsModule
+}
+
+type wsModule struct {
+	Dir       string
+	Path      string
+	GoVersion string
+	File      *modfile.File
+}
+
+// findWorkspace discovers the workspace of dir. GOWORK is honoured the way
+// the go command does: "off" ignores go.work, a path selects one.
+func findWorkspace(dir string) (*workspace, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	work := os.Getenv("GOWORK")
+	switch work {
+	case "off":
+		work = ""
+	case "":
+		for d := absDir; ; d = filepath.Dir(d) {
+			if _, err := os.Stat(filepath.Join(d, "go.work")); err == nil {
+				work = filepath.Join(d, "go.work")
+				break
+			}
+			if filepath.Dir(d) == d {
+				break
+			}
+		}
+	}
+
+	if work != "" {
+		data, err := os.ReadFile(work)
+		if err != nil {
+			return nil, err
+		}
+		wf, err := modfile.ParseWork(work, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		ws := &workspace{Root: filepath.Dir(work), Work: work}
+		for _, use := range wf.Use {
+			modDir := use.Path
+			if !filepath.IsAbs(modDir) {
+				modDir = filepath.Join(ws.Root, modDir)
+			}
+			m, err := readModule(filepath.Clean(modDir))
+			if err != nil {
+				return nil, err
+			}
+			ws.Modules = append(ws.Modules, m)
+		}
+		if len(ws.Modules) == 0 {
+			return nil, fmt.Errorf("%s uses no modules", work)
+		}
+		ws.sortModules()
+		return ws, nil
+	}
+
+	root, _, err := findModuleRoot(absDir)
+	if err != nil {
+		return nil, err
+	}
+	ws := &workspace{Root: root}
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if base := fi.Name(); path != root && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || base == "vendor" || base == "testdata") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+			if m, err := readModule(path); err == nil {
+				ws.Modules = append(ws.Modules, m)
+			}
+		}
+		return nil
+	})
+	ws.sortModules()
+	return ws, nil
+}
+
+// moduleRoot returns the directory and path of the module containing dir,
+// preferring the workspace modules. From a go.work directory outside every
+// module it returns the workspace root and an empty module path.
+func (w *workspace) moduleRoot(dir string) (string, string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if m := w.moduleOf(absDir); m != nil {
+		return m.Dir, m.Path, nil
+	}
+	root, modulePath, err := findModuleRoot(absDir)
+	if err != nil && w.Work != "" {
+		return w.Root, "", nil
+	}
+	return root, modulePath, err
+}
+
+func readModule(dir string) (*wsModule, error) {
+	path := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.ParseLax(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := &wsModule{Dir: dir, File: f}
+	if f.Module != nil {
+		m.Path = f.Module.Mod.Path
+	}
+	if f.Go != nil {
+		m.GoVersion = f.Go.Version
+	}
+	return m, nil
+}
+
+func (w *workspace) sortModules() {
+	sort.Slice(w.Modules, func(i, j int) bool { return w.Modules[i].Dir < w.Modules[j].Dir })
+}
+
+// requires lists the module paths required by any workspace module.
+func (w *workspace) requires() []string {
+	var paths []string
+	for _, m := range w.Modules {
+		for _, r := range m.File.Require {
+			paths = appendUnique(paths, r.Mod.Path)
+		}
+	}
+	return paths
+}
+
+// moduleOf returns the innermost module containing a file or directory.
+func (w *workspace) moduleOf(path string) *wsModule {
+	var best *wsModule
+	for _, m := range w.Modules {
+		if withinDir(path, m.Dir, true) && (best == nil || len(m.Dir) > len(best.Dir)) {
+			best = m
+		}
+	}
+	return best
+}
+
+// importPath returns the import path of the package in dir, or "" if dir
+// is outside the workspace.
+func (w *workspace) importPath(dir string) string {
+	m := w.moduleOf(dir)
+	if m == nil {
+		return ""
+	}
+	rel, err := filepath.Rel(m.Dir, dir)
+	if err != nil || rel == "." {
+		return m.Path
+	}
+	return m.Path + "/" + filepath.ToSlash(rel)
+}
+
+// moduleFor returns the workspace module an import path belongs to.
+func (w *workspace) moduleFor(importPath string) *wsModule {
+	var best *wsModule
+	for _, m := range w.Modules {
+		if (importPath == m.Path || strings.HasPrefix(importPath, m.Path+"/")) && (best == nil || len(m.Path) > len(best.Path)) {
+			best = m
+		}
+	}
+	return best
+}
+
+// walkPackageDirs calls visit for every directory of every workspace
+// module that may hold a package, skipping hidden, vendor and testdata
+// directories and the roots of other modules.
+func (w *workspace) walkPackageDirs(visit func(m *wsModule, dir string)) {
+	for _, m := range w.Modules {
+		filepath.Walk(m.Dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if path != m.Dir {
+				base := fi.Name()
+				if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || base == "vendor" || base == "testdata" {
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			visit(m, path)
+			return nil
+		})
+	}
+}